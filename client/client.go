@@ -0,0 +1,293 @@
+// Package client plays scopa against a server over the /join websocket, for bots and tools.
+//
+//	c, err := client.Dial("http://localhost:8080", "bot", nil)
+//	...
+//	for {
+//		m, err := c.Next()
+//		...
+//		if m.State != nil && m.State.NextPlayer == c.Nickname {
+//			err = c.Drop(m.State.Player.Hand[0])
+//		}
+//	}
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sbadame/scopa/protocol"
+	"github.com/sbadame/scopa/scopa"
+	"golang.org/x/net/websocket"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Options are the optional settings for Dial.
+type Options struct {
+	// MatchID, Token and LastSeq resume a seat that the client had before.
+	MatchID int64
+	Token   string
+	LastSeq int
+
+	// Header is sent with the websocket handshake, e.g. the session cookie of a registered player.
+	Header http.Header
+}
+
+// Client is a seat in a match. Its methods can be called from any goroutine.
+type Client struct {
+	Nickname string
+	Version  int // The negotiated protocol version.
+	MatchID  int64
+	Token    string
+
+	ws *websocket.Conn
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	queue    []protocol.ServerMessage // Received and waiting for Next.
+	err      error                    // Set once the connection is broken.
+	lastSeq  int
+	state    []byte // JSON of the latest State, that patches apply to.
+	stateSeq int    // Its Seq.
+	nextID   int
+	requests map[string]chan error // Commands waiting for an Ack or Error, by ID.
+}
+
+// Dial joins a match on the server at serverURL (e.g. "https://scopa.example.com") as nick, and returns once
+// the client has a seat.
+func Dial(serverURL, nick string, opts *Options) (*Client, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, err
+	}
+	origin := u.String()
+	u.Scheme = strings.Replace(u.Scheme, "http", "ws", 1)
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/join"
+	q := url.Values{}
+	q.Set("Nickname", nick)
+	q.Set("Version", strconv.Itoa(protocol.Version))
+	if opts.Token != "" {
+		q.Set("MatchID", strconv.FormatInt(opts.MatchID, 10))
+		q.Set("Token", opts.Token)
+		q.Set("LastSeq", strconv.Itoa(opts.LastSeq))
+	}
+	u.RawQuery = q.Encode()
+
+	config, err := websocket.NewConfig(u.String(), origin)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range opts.Header {
+		config.Header[k] = v
+	}
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// The first message tells us where we're seated, or why we can't be.
+	var m protocol.ServerMessage
+	if err := websocket.JSON.Receive(ws, &m); err != nil {
+		ws.Close()
+		return nil, err
+	}
+	if m.Token == "" {
+		ws.Close()
+		return nil, fmt.Errorf("couldn't join: %s", m.Message)
+	}
+
+	c := &Client{
+		Nickname: nick,
+		Version:  m.Version,
+		MatchID:  m.MatchID,
+		Token:    m.Token,
+		ws:       ws,
+		lastSeq:  opts.LastSeq,
+		requests: make(map[string]chan error),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	go c.read()
+	return c, nil
+}
+
+func (c *Client) read() {
+	for {
+		var m protocol.ServerMessage
+		err := websocket.JSON.Receive(c.ws, &m)
+
+		c.mu.Lock()
+		if err != nil {
+			c.err = err
+			for id, r := range c.requests {
+				r <- err
+				delete(c.requests, id)
+			}
+			c.cond.Broadcast()
+			c.mu.Unlock()
+			return
+		}
+
+		switch {
+		case m.Ack != nil:
+			c.reply(m.Ack.ID, nil)
+		case m.Error != nil && m.Error.ID != "":
+			c.reply(m.Error.ID, m.Error)
+		case m.Ping != 0:
+			go websocket.JSON.Send(c.ws, protocol.Command{Type: protocol.PongCommand})
+		default:
+			c.patch(&m)
+			if m.Seq > c.lastSeq {
+				c.lastSeq = m.Seq
+			}
+			c.queue = append(c.queue, m)
+			c.cond.Broadcast()
+		}
+		c.mu.Unlock()
+	}
+}
+
+// patch turns the Patch in m into a State, so that users of Client always get states in full. If the client doesn't
+// have the state that the patch is for, it asks the server to resync and leaves the state out of m.
+// Callers must hold the lock.
+func (c *Client) patch(m *protocol.ServerMessage) {
+	switch {
+	case m.State != nil:
+		if b, err := json.Marshal(m.State); err == nil {
+			c.state, c.stateSeq = b, m.Seq
+		}
+	case m.Base != 0:
+		var s protocol.State
+		b, err := protocol.Apply(c.state, m.Patch)
+		if err == nil && m.Base == c.stateSeq {
+			err = json.Unmarshal(b, &s)
+		}
+		if err != nil || m.Base != c.stateSeq {
+			c.state, c.stateSeq = nil, 0
+			go websocket.JSON.Send(c.ws, protocol.Command{Type: protocol.ResyncCommand})
+		} else {
+			m.State = &s
+			c.state, c.stateSeq = b, m.Seq
+		}
+		m.Base, m.Patch = 0, nil
+	}
+}
+
+// reply finishes the command with id, callers must hold the lock.
+func (c *Client) reply(id string, err error) {
+	if r, ok := c.requests[id]; ok {
+		r <- err
+		delete(c.requests, id)
+	}
+}
+
+// Next blocks until the next message from the server, other than replies to commands.
+// Once the connection is broken, it returns the error.
+func (c *Client) Next() (protocol.ServerMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.queue) == 0 && c.err == nil {
+		c.cond.Wait()
+	}
+	if len(c.queue) == 0 {
+		return protocol.ServerMessage{}, c.err
+	}
+	m := c.queue[0]
+	c.queue = c.queue[1:]
+	return m, nil
+}
+
+// LastSeq is the last event received, pass it in Options to catch up after reconnecting.
+func (c *Client) LastSeq() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastSeq
+}
+
+// Send sends the command and waits for the server to reply to it. The ID is filled in.
+// Failed commands return a *protocol.Error.
+func (c *Client) Send(cmd protocol.Command) error {
+	r := make(chan error, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.nextID++
+	cmd.ID = strconv.Itoa(c.nextID)
+	c.requests[cmd.ID] = r
+	c.mu.Unlock()
+
+	if err := websocket.JSON.Send(c.ws, cmd); err != nil {
+		c.mu.Lock()
+		delete(c.requests, cmd.ID)
+		c.mu.Unlock()
+		return err
+	}
+	return <-r
+}
+
+// Drop plays card onto the table.
+func (c *Client) Drop(card scopa.Card) error {
+	return c.Send(protocol.Command{Type: protocol.DropCommand, Card: &card})
+}
+
+// Take plays card to capture table.
+func (c *Client) Take(card scopa.Card, table []scopa.Card) error {
+	return c.Send(protocol.Command{Type: protocol.TakeCommand, Card: &card, Table: table})
+}
+
+// Chat sends a message to everyone in the match.
+func (c *Client) Chat(text string) error {
+	return c.Send(protocol.Command{Type: protocol.ChatCommand, Text: text})
+}
+
+// Resign forfeits the game.
+func (c *Client) Resign() error {
+	return c.Send(protocol.Command{Type: protocol.ResignCommand})
+}
+
+// Abort proposes, or agrees, to throw the game away. Only games that haven't got past the first hand can be aborted.
+func (c *Client) Abort() error {
+	return c.Send(protocol.Command{Type: protocol.AbortCommand})
+}
+
+// Draw proposes, or agrees, to end the game as a draw.
+func (c *Client) Draw() error {
+	return c.Send(protocol.Command{Type: protocol.DrawCommand})
+}
+
+// OfferRematch offers to play again once the game is over.
+func (c *Client) OfferRematch(o protocol.RematchOptions) error {
+	return c.Send(protocol.Command{Type: protocol.OfferRematchCommand, Rematch: &o})
+}
+
+// AcceptRematch starts the rematch that the other player offered.
+func (c *Client) AcceptRematch() error {
+	return c.Send(protocol.Command{Type: protocol.AcceptRematchCommand})
+}
+
+// DeclineRematch turns down the rematch that the other player offered, which ends the match.
+func (c *Client) DeclineRematch() error {
+	return c.Send(protocol.Command{Type: protocol.DeclineRematchCommand})
+}
+
+// SetPresence tells the other player whether you're protocol.Online or protocol.Away.
+func (c *Client) SetPresence(status string) error {
+	return c.Send(protocol.Command{Type: protocol.PresenceCommand, Presence: status})
+}
+
+// Ping checks that the server is still there.
+func (c *Client) Ping() error {
+	return c.Send(protocol.Command{Type: protocol.PingCommand})
+}
+
+// Close leaves the match, the seat can be resumed later with Token.
+func (c *Client) Close() error {
+	return c.ws.Close()
+}
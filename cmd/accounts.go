@@ -0,0 +1,310 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/sbadame/scopa/store"
+	"golang.org/x/crypto/bcrypt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sessionCookie   = "scopa_session"
+	sessionLifetime = 30 * 24 * time.Hour
+	minPasswordLen  = 8
+)
+
+// account is a registered player. Scores are keyed on the ID, so Username can't be spoofed by a guest.
+type account struct {
+	ID           string
+	Username     string
+	PasswordHash []byte `json:",omitempty"`
+	OIDCIssuer   string `json:",omitempty"`
+	OIDCSubject  string `json:",omitempty"`
+	Created      time.Time
+}
+
+type session struct {
+	AccountID string
+	Expires   time.Time
+}
+
+// accounts stores all of the registered players and their login sessions.
+// Sessions are keyed on a hash of the cookie value so that the file on disk can't be used to log in.
+type accounts struct {
+	sync.Mutex
+	store    store.Store
+	NextID   int
+	Accounts map[string]*account
+	Sessions map[string]session
+}
+
+// accountsMigrations bring older accounts files up to date, see store.Open.
+var accountsMigrations = []store.Migration{store.Unchanged}
+
+func loadAccounts(st store.Store) *accounts {
+	a := &accounts{store: st, Accounts: make(map[string]*account), Sessions: make(map[string]session)}
+	if err := st.Load(a, nil); err != nil {
+		fmt.Printf("Couldn't read the accounts, %v\n", err)
+	}
+	return a
+}
+
+// save writes the accounts to disk, callers must hold the lock.
+func (a *accounts) save() {
+	if err := a.store.Save(a); err != nil {
+		fmt.Printf("Couldn't save the accounts: %v\n", err)
+	}
+}
+
+// isAccountID reports whether id names an account rather than a guest nickname.
+func isAccountID(id string) bool {
+	return strings.HasPrefix(id, "acct:")
+}
+
+func validUsername(u string) error {
+	if len(u) < 2 || len(u) > 20 {
+		return fmt.Errorf("usernames must be between 2 and 20 characters")
+	}
+	if strings.ContainsAny(u, ":|") {
+		return fmt.Errorf("usernames can't contain ':' or '|'")
+	}
+	return nil
+}
+
+// byUsername returns the account with the (case insensitive) username, callers must hold the lock.
+func (a *accounts) byUsername(u string) *account {
+	for _, acct := range a.Accounts {
+		if strings.EqualFold(acct.Username, u) {
+			return acct
+		}
+	}
+	return nil
+}
+
+// registered reports whether a guest would be impersonating someone by using nick.
+func (a *accounts) registered(nick string) bool {
+	a.Lock()
+	defer a.Unlock()
+	return a.byUsername(nick) != nil
+}
+
+// create adds a new account, callers must hold the lock.
+func (a *accounts) create(username string) *account {
+	a.NextID++
+	acct := &account{
+		ID:       "acct:" + strconv.Itoa(a.NextID),
+		Username: username,
+		Created:  time.Now(),
+	}
+	a.Accounts[acct.ID] = acct
+	return acct
+}
+
+func (a *accounts) register(username, password string) (*account, error) {
+	if err := validUsername(username); err != nil {
+		return nil, err
+	}
+	if len(password) < minPasswordLen {
+		return nil, fmt.Errorf("passwords need at least %d characters", minPasswordLen)
+	}
+
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	if a.byUsername(username) != nil {
+		return nil, fmt.Errorf("username %s is already taken", username)
+	}
+	acct := a.create(username)
+	acct.PasswordHash = h
+	a.save()
+	return acct, nil
+}
+
+// noAccountHash is compared against when there's no account to log in to, so that logins take as long either way
+// and don't give away which usernames are taken.
+var noAccountHash = []byte("$2a$10$w3GmyetzL6ExcTB5SmmYvO9ifQ9UuIPV9C3DzP2QYLIGstn2T7Z5.")
+
+// login checks the password of username. bcrypt is slow on purpose, so it runs without the lock.
+func (a *accounts) login(username, password string) (*account, error) {
+	a.Lock()
+	acct := a.byUsername(username)
+	hash := noAccountHash
+	found := acct != nil && len(acct.PasswordHash) > 0
+	if found {
+		hash = acct.PasswordHash
+	}
+	a.Unlock()
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !found {
+		return nil, fmt.Errorf("wrong username or password")
+	}
+	return acct, nil
+}
+
+// oidcAccount returns the account linked to the OIDC identity, creating one if this is their first login.
+func (a *accounts) oidcAccount(issuer, subject, preferredName string) *account {
+	a.Lock()
+	defer a.Unlock()
+	for _, acct := range a.Accounts {
+		if acct.OIDCIssuer == issuer && acct.OIDCSubject == subject {
+			return acct
+		}
+	}
+
+	// Find a username that nobody has taken yet.
+	if validUsername(preferredName) != nil {
+		preferredName = "player"
+	}
+	username := preferredName
+	for i := 2; a.byUsername(username) != nil; i++ {
+		username = preferredName + strconv.Itoa(i)
+	}
+
+	acct := a.create(username)
+	acct.OIDCIssuer, acct.OIDCSubject = issuer, subject
+	a.save()
+	return acct
+}
+
+func hashSession(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// startSession logs acct in by setting a session cookie on the response.
+func (a *accounts) startSession(w http.ResponseWriter, r *http.Request, acct *account) error {
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	expires := time.Now().Add(sessionLifetime)
+
+	a.Lock()
+	for k, s := range a.Sessions {
+		if time.Now().After(s.Expires) {
+			delete(a.Sessions, k)
+		}
+	}
+	a.Sessions[hashSession(token)] = session{acct.ID, expires}
+	a.save()
+	a.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// fromRequest returns the logged in account for the request, or nil for guests.
+func (a *accounts) fromRequest(r *http.Request) *account {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	s, ok := a.Sessions[hashSession(c.Value)]
+	if !ok || time.Now().After(s.Expires) {
+		return nil
+	}
+	return a.Accounts[s.AccountID]
+}
+
+func (a *accounts) endSession(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		a.Lock()
+		delete(a.Sessions, hashSession(c.Value))
+		a.save()
+		a.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", MaxAge: -1})
+}
+
+func writeAccount(w http.ResponseWriter, acct *account) {
+	b, err := json.Marshal(struct{ ID, Username string }{acct.ID, acct.Username})
+	if err != nil {
+		w.WriteHeader(500)
+		io.WriteString(w, errorJSON(err.Error()))
+		return
+	}
+	w.Write(b)
+}
+
+// /register and /login request content body json is marshaled into this struct.
+type credentials struct {
+	Username string
+	Password string
+}
+
+func (s *server) register(w http.ResponseWriter, r *http.Request) {
+	var c credentials
+	if !parseRequestJSON(w, r, &c) {
+		return
+	}
+
+	acct, err := s.accounts.register(c.Username, c.Password)
+	if err != nil {
+		w.WriteHeader(400)
+		io.WriteString(w, errorJSON(err.Error()))
+		return
+	}
+	if err := s.accounts.startSession(w, r, acct); err != nil {
+		w.WriteHeader(500)
+		io.WriteString(w, errorJSON(err.Error()))
+		return
+	}
+	writeAccount(w, acct)
+}
+
+func (s *server) login(w http.ResponseWriter, r *http.Request) {
+	var c credentials
+	if !parseRequestJSON(w, r, &c) {
+		return
+	}
+
+	acct, err := s.accounts.login(c.Username, c.Password)
+	if err != nil {
+		w.WriteHeader(401)
+		io.WriteString(w, errorJSON(err.Error()))
+		return
+	}
+	if err := s.accounts.startSession(w, r, acct); err != nil {
+		w.WriteHeader(500)
+		io.WriteString(w, errorJSON(err.Error()))
+		return
+	}
+	writeAccount(w, acct)
+}
+
+func (s *server) logout(w http.ResponseWriter, r *http.Request) {
+	s.accounts.endSession(w, r)
+}
+
+// account tells the client who is logged in, and whether OIDC login is available.
+func (s *server) account(w http.ResponseWriter, r *http.Request) {
+	acct := s.accounts.fromRequest(r)
+	if acct == nil {
+		w.WriteHeader(401)
+		io.WriteString(w, fmt.Sprintf(`{"Message": "Not logged in.", "OIDC": %t}`, s.oidc != nil))
+		return
+	}
+	writeAccount(w, acct)
+}
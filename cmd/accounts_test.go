@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"golang.org/x/crypto/bcrypt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tempAccounts(t *testing.T) *accounts {
+	return loadAccounts(tempStore(t, "accounts", accountsMigrations...))
+}
+
+func TestAccounts(t *testing.T) {
+	a := tempAccounts(t)
+
+	acct, err := a.register("marco", "polo1234")
+	if err != nil {
+		t.Fatalf("Couldn't register: %v", err)
+	}
+	if _, err := a.register("Marco", "different"); err == nil {
+		t.Errorf("Expected usernames to be case insensitively unique.")
+	}
+	if _, err := a.register("ab", "short"); err == nil {
+		t.Errorf("Expected a short password to be rejected.")
+	}
+
+	if _, err := a.login("marco", "wrong-password"); err == nil {
+		t.Errorf("Expected a wrong password to fail.")
+	}
+	if _, err := a.login("polo", "polo1234"); err == nil {
+		t.Errorf("Expected a username without an account to fail.")
+	}
+	// Logins without an account take as long as those with one.
+	if cost, err := bcrypt.Cost(noAccountHash); err != nil || cost != bcrypt.DefaultCost {
+		t.Errorf("Expected noAccountHash to cost %d, got %d: %v", bcrypt.DefaultCost, cost, err)
+	}
+	if got, err := a.login("marco", "polo1234"); err != nil || got.ID != acct.ID {
+		t.Errorf("Expected to log in as %s but got %v, %v", acct.ID, got, err)
+	}
+	if !a.registered("MARCO") {
+		t.Errorf("Expected 'MARCO' to be reserved for the registered player.")
+	}
+
+	// Sessions survive a reload of the file.
+	w := httptest.NewRecorder()
+	if err := a.startSession(w, httptest.NewRequest("POST", "/login", nil), acct); err != nil {
+		t.Fatalf("Couldn't start a session: %v", err)
+	}
+	r := httptest.NewRequest("GET", "/account", nil)
+	r.AddCookie(w.Result().Cookies()[0])
+	if got := loadAccounts(a.store).fromRequest(r); got == nil || got.ID != acct.ID {
+		t.Errorf("Expected the session cookie to log in as %s but got %v", acct.ID, got)
+	}
+}
+
+// fakeOIDC is a local stand-in for an OpenID Connect provider that logs everyone in as the same subject.
+type fakeOIDC struct {
+	*httptest.Server
+	key     *rsa.PrivateKey
+	subject string
+	kid     string            // That tokens are signed with, the key set only has k1.
+	nonces  map[string]string // nonce by authorization code.
+	fetches int               // Of the key set.
+}
+
+func newFakeOIDC(t *testing.T, subject string) *fakeOIDC {
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Couldn't generate a key: %v", err)
+	}
+	f := &fakeOIDC{key: k, subject: subject, kid: "k1", nonces: make(map[string]string)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 f.URL,
+			"authorization_endpoint": f.URL + "/authorize",
+			"token_endpoint":         f.URL + "/token",
+			"jwks_uri":               f.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		code := "code-" + r.FormValue("state")
+		f.nonces[code] = r.FormValue("nonce")
+		u, _ := url.Parse(r.FormValue("redirect_uri"))
+		u.RawQuery = url.Values{"code": {code}, "state": {r.FormValue("state")}}.Encode()
+		http.Redirect(w, r, u.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "scopa" || secret != "shh" {
+			w.WriteHeader(401)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": f.sign(t, map[string]interface{}{
+			"iss":                f.URL,
+			"sub":                f.subject,
+			"aud":                "scopa",
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"nonce":              f.nonces[r.FormValue("code")],
+			"preferred_username": "marco",
+		})})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		f.fetches++
+		enc := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kid": "k1",
+			"kty": "RSA",
+			"n":   enc(f.key.N.Bytes()),
+			"e":   enc(big.NewInt(int64(f.key.E)).Bytes()),
+		}}})
+	})
+	f.Server = httptest.NewServer(mux)
+	return f
+}
+
+func (f *fakeOIDC) sign(t *testing.T, claims map[string]interface{}) string {
+	enc := func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	s := enc(map[string]string{"alg": "RS256", "kid": f.kid}) + "." + enc(claims)
+	h := sha256.Sum256([]byte(s))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, f.key, crypto.SHA256, h[:])
+	if err != nil {
+		t.Fatalf("Couldn't sign: %v", err)
+	}
+	return s + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	provider := newFakeOIDC(t, "subject-1")
+	defer provider.Close()
+
+	s := &server{accounts: tempAccounts(t)}
+	// Someone already registered "marco", so the OIDC user has to get another name.
+	if _, err := s.accounts.register("marco", "polo1234"); err != nil {
+		t.Fatalf("Couldn't register: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oidc/login", s.oidcLogin)
+	mux.HandleFunc("/oidc/callback", s.oidcCallback)
+	mux.HandleFunc("/account", s.account)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+	app := httptest.NewServer(mux)
+	defer app.Close()
+
+	o, err := newOIDCProvider(provider.Client(), provider.URL, "scopa", "shh", app.URL+"/oidc/callback")
+	if err != nil {
+		t.Fatalf("Couldn't discover the provider: %v", err)
+	}
+	s.oidc = o
+
+	jar, _ := cookiejar.New(nil)
+	c := &http.Client{Jar: jar}
+	for i := 0; i < 2; i++ {
+		if r, err := c.Get(app.URL + "/oidc/login"); err != nil || r.StatusCode != 200 {
+			t.Fatalf("Login %d failed: %v %v", i, r, err)
+		}
+	}
+
+	r, err := c.Get(app.URL + "/account")
+	if err != nil {
+		t.Fatalf("Couldn't get the account: %v", err)
+	}
+	b, _ := ioutil.ReadAll(r.Body)
+	if !strings.Contains(string(b), `"Username":"marco2"`) {
+		t.Errorf("Expected to be logged in as marco2, got %s", b)
+	}
+	if n := len(s.accounts.Accounts); n != 2 {
+		t.Errorf("Expected logging in twice to reuse the account, but there are %d accounts", n)
+	}
+
+	// Forged tokens are rejected.
+	forged := provider.sign(t, map[string]interface{}{"iss": provider.URL, "sub": "x", "aud": "someone-else", "exp": time.Now().Add(time.Hour).Unix()})
+	if _, err := o.verify(forged); err == nil {
+		t.Errorf("Expected a token for another audience to be rejected.")
+	}
+	if _, err := o.verify(forged[:len(forged)-4] + "AAAA"); err == nil {
+		t.Errorf("Expected a token with a bad signature to be rejected.")
+	}
+
+	// Tokens signed with keys that the provider doesn't have only fetch the key set again once in a while.
+	provider.kid = "k2"
+	unknown := provider.sign(t, map[string]interface{}{"iss": provider.URL, "sub": "x", "aud": "scopa", "exp": time.Now().Add(time.Hour).Unix()})
+	for i := 0; i < 3; i++ {
+		if _, err := o.verify(unknown); err == nil {
+			t.Errorf("Expected a token signed with an unknown key to be rejected.")
+		}
+	}
+	if provider.fetches != 1 {
+		t.Errorf("Expected the key set to be fetched once, got %d", provider.fetches)
+	}
+	o.fetched = o.fetched.Add(-jwksRefreshInterval)
+	o.verify(unknown)
+	if provider.fetches != 2 {
+		t.Errorf("Expected the key set to be fetched again after %v, got %d fetches", jwksRefreshInterval, provider.fetches)
+	}
+}
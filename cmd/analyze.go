@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sbadame/scopa/scopa/bot"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// maxAnalysisIterations caps how many games an analysis plays out, each of which takes about a tenth of a millisecond.
+const maxAnalysisIterations = 5000
+
+// maxAnalyses is how many analyses can run at once, anyone can ask for one without logging in.
+const maxAnalyses = 2
+
+// analyses holds a value for every analysis that is running.
+var analyses = make(chan struct{}, maxAnalyses)
+
+// analysis is what the search made of the position that a move in an archived match was made in.
+type analysis struct {
+	Number int          // Of the archived match.
+	Move   int          // Starts at 1.
+	Played archivedMove // What the player did, to compare with Moves.
+	Moves  []bot.Analysis
+}
+
+// analyze serves /archive/{number}/analysis?move=5&iterations=2000, where the search plays iterations games out from
+// the position that the 5th move of the match was made in. It only sees what the player who made the move could, and
+// lists every move that they had with the best first.
+func (s *server) analyze(w http.ResponseWriter, r *http.Request, am *archivedMatch) {
+	move, err := strconv.Atoi(r.FormValue("move"))
+	if err != nil || move < 1 || move > len(am.Moves) {
+		w.WriteHeader(400)
+		io.WriteString(w, errorJSON(fmt.Sprintf("move should be between 1 and %d.", len(am.Moves))))
+		return
+	}
+	played := am.Moves[move-1]
+	if played.Drop == nil && played.Take == nil {
+		w.WriteHeader(400)
+		io.WriteString(w, errorJSON(fmt.Sprintf("Move %d doesn't play a card.", move)))
+		return
+	}
+	iterations := 2000
+	if i := r.FormValue("iterations"); i != "" {
+		if iterations, err = strconv.Atoi(i); err != nil || iterations < 1 || iterations > maxAnalysisIterations {
+			w.WriteHeader(400)
+			io.WriteString(w, errorJSON(fmt.Sprintf("iterations should be between 1 and %d.", maxAnalysisIterations)))
+			return
+		}
+	}
+
+	g, err := am.replayTo(move - 1)
+	if err != nil {
+		w.WriteHeader(500)
+		io.WriteString(w, errorJSON(fmt.Sprintf("Couldn't replay match %d: %v", am.Number, err)))
+		return
+	}
+
+	select {
+	case analyses <- struct{}{}:
+		defer func() { <-analyses }()
+	default:
+		w.WriteHeader(503)
+		io.WriteString(w, errorJSON("Other games are being analyzed, try again in a moment."))
+		return
+	}
+	json.NewEncoder(w).Encode(analysis{am.Number, move, played, bot.Analyze(g, iterations, am.Seed+int64(move))})
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sbadame/scopa/scopa"
+	"github.com/sbadame/scopa/store"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// archive is every finished match. Matches are appended to the store's journal, and the whole archive is saved
+// every snapshotGames.
+type archive struct {
+	sync.Mutex
+	store    store.Store
+	matches  []*archivedMatch // Oldest first, matches[i].Number is i+1.
+	journals int              // Matches appended to the journal since the archive was last saved.
+}
+
+// archivedMatch is a finished match, with everything needed to replay it.
+type archivedMatch struct {
+	Number      int // Starts at 1, in the order the matches finished.
+	MatchID     int64
+	Variant     string
+	Seed        int64 // That the deck was shuffled with, see scopa.NewSeededGame.
+	Started     time.Time
+	Ended       time.Time
+	Players     []archivedPlayer // In the order they moved.
+	Forfeited   string           `json:",omitempty"` // Nickname of the player that forfeited.
+	Ending      string           `json:",omitempty"` // How the game ended early, see protocol.State.Ending.
+	Forfeits    string           `json:",omitempty"` // What a forfeit scores, see scopa.Game.ForfeitPoints.
+	Substituted bool             `json:",omitempty"` // The bot moved for a disconnected player, see archivedMove.
+	Moves       []archivedMove   `json:",omitempty"`
+}
+
+// archivedPlayer is a player's results in an archived match.
+type archivedPlayer struct {
+	ID       string // Account ID or guest nickname.
+	Nickname string
+	Points   int
+	Scopas   int
+	Awards   []string
+	Cards    int
+	Denari   int
+	Primiera int
+}
+
+// archivedMove is a move in an archived match. Exactly one of Drop, Take, Forfeit or Stop is set.
+type archivedMove struct {
+	Time       time.Time
+	Player     string       // Nickname.
+	Drop       *scopa.Card  `json:",omitempty"`
+	Take       *scopa.Card  `json:",omitempty"`
+	Table      []scopa.Card `json:",omitempty"` // The cards that Take captured.
+	Forfeit    bool         `json:",omitempty"`
+	Stop       string       `json:",omitempty"` // Why the game was stopped, see scopa.Game.Stop.
+	Timeout    bool         `json:",omitempty"` // The clock made the move for the player.
+	Substitute bool         `json:",omitempty"` // The bot made the move for the player, who was disconnected.
+}
+
+// archiveMigrations bring older archive files up to date, see store.Open.
+var archiveMigrations = []store.Migration{store.Unchanged}
+
+func loadArchive(st store.Store) *archive {
+	a := &archive{store: st}
+	err := st.Load(&a.matches, func(e json.RawMessage) error {
+		var m archivedMatch
+		if err := json.Unmarshal(e, &m); err != nil {
+			return err
+		}
+		a.matches = append(a.matches, &m)
+		a.journals++
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Couldn't read the archive, %v\n", err)
+	}
+	return a
+}
+
+// newSeed returns the seed to shuffle a new game's deck with.
+func newSeed() int64 {
+	return rand.Int63()
+}
+
+// played records a move in the match's history, callers must hold the lock.
+func (m *Match) played(mv archivedMove) {
+	mv.Time = m.now()
+	if m.substitutes[mv.Player] {
+		mv.Substitute, m.substituted = true, true
+	}
+	m.moves = append(m.moves, mv)
+}
+
+// record archives the match that just ended in m. Callers must hold the match lock.
+func (a *archive) record(m *Match) {
+	am := &archivedMatch{
+		MatchID:     m.ID,
+		Variant:     scopa.Scopa,
+		Seed:        m.seed,
+		Started:     m.started,
+		Ended:       m.now(),
+		Forfeited:   m.state.Forfeited,
+		Ending:      m.ending,
+		Forfeits:    m.state.ForfeitPoints,
+		Substituted: m.substituted,
+		Moves:       m.moves,
+	}
+	if m.state.Variant != "" {
+		am.Variant = m.state.Variant
+	}
+	for i, p := range m.players {
+		sp := m.state.Players[i]
+		ap := archivedPlayer{
+			ID:       p.id,
+			Nickname: p.nick,
+			Points:   len(sp.Awards) + sp.Scopas,
+			Scopas:   sp.Scopas,
+			Awards:   sp.Awards,
+			Cards:    len(sp.Grabbed),
+			Primiera: sp.Primiera(),
+		}
+		for _, c := range sp.Grabbed {
+			if c.Suit == scopa.Denari {
+				ap.Denari++
+			}
+		}
+		am.Players = append(am.Players, ap)
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	am.Number = len(a.matches) + 1
+	a.matches = append(a.matches, am)
+	if a.journals++; a.journals >= snapshotGames {
+		a.save()
+	} else if err := a.store.Append(am); err != nil {
+		fmt.Printf("Couldn't journal the match, saving the whole archive instead: %v\n", err)
+		a.save()
+	}
+}
+
+// save writes the whole archive to disk, callers must hold the lock.
+func (a *archive) save() {
+	if err := a.store.Save(a.matches); err != nil {
+		fmt.Printf("Couldn't save the archive: %v\n", err)
+		return
+	}
+	a.journals = 0
+}
+
+// replay plays the archived match again from its seed, and returns the game as it ended.
+func (am *archivedMatch) replay() (scopa.Game, error) {
+	return am.replayTo(len(am.Moves))
+}
+
+// replayTo plays the first n moves of the archived match again from its seed, and returns the game after them.
+func (am *archivedMatch) replayTo(n int) (scopa.Game, error) {
+	var names []string
+	for _, p := range am.Players {
+		names = append(names, p.Nickname)
+	}
+	g := scopa.NewSeededGame(names, am.Seed)
+	if am.Variant != scopa.Scopa {
+		g.Variant = am.Variant
+	}
+	g.ForfeitPoints = am.Forfeits
+	for i, mv := range am.Moves[:n] {
+		var err error
+		switch {
+		case mv.Forfeit:
+			err = g.Forfeit(mv.Player)
+		case mv.Stop != "":
+			err = g.Stop(mv.Stop)
+		case mv.Player != g.NextPlayer:
+			err = fmt.Errorf("it's %s's turn, not %s's", g.NextPlayer, mv.Player)
+		case mv.Drop != nil:
+			err = g.Drop(*mv.Drop)
+		case mv.Take != nil:
+			err = g.Take(*mv.Take, mv.Table)
+		default:
+			err = fmt.Errorf("it's empty")
+		}
+		if err != nil {
+			return g, fmt.Errorf("move %d: %v", i+1, err)
+		}
+	}
+	return g, nil
+}
+
+// archiveQuery picks the matches that /archive lists, the zero value picks every match.
+type archiveQuery struct {
+	player, opponent string // IDs.
+	variant          string
+	from, to         time.Time // When the match ended, to is exclusive.
+}
+
+func (q archiveQuery) matches(am *archivedMatch) bool {
+	has := func(id string) bool {
+		for _, p := range am.Players {
+			if p.ID == id {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case q.player != "" && !has(q.player):
+		return false
+	case q.opponent != "" && (!has(q.opponent) || q.opponent == q.player):
+		return false
+	case q.variant != "" && q.variant != am.Variant:
+		return false
+	case !q.from.IsZero() && am.Ended.Before(q.from):
+		return false
+	case !q.to.IsZero() && !am.Ended.Before(q.to):
+		return false
+	}
+	return true
+}
+
+// parseDate reads a date like 2020-06-28, or an RFC 3339 time.
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// archived serves /archive?player=a&opponent=b&variant=scopa&from=2020-06-01&to=2020-07-01&limit=50, the
+// matches that finished in that time, newest first and without their moves, and /archive/{number} which is a
+// single match with all of its moves. Every parameter is optional, and to is exclusive. See analyze for
+// /archive/{number}/analysis.
+func (s *server) archived(w http.ResponseWriter, r *http.Request) {
+	a := s.m.archive
+	if a == nil {
+		w.WriteHeader(404)
+		io.WriteString(w, errorJSON("Matches aren't archived on this server."))
+		return
+	}
+
+	if n := strings.TrimPrefix(r.URL.Path, "/archive/"); n != r.URL.Path && n != "" {
+		path := strings.Split(n, "/")
+		number, err := strconv.Atoi(path[0])
+		// Archived matches don't change, so they can be used without the lock.
+		var am *archivedMatch
+		a.Lock()
+		if err == nil && number >= 1 && number <= len(a.matches) {
+			am = a.matches[number-1]
+		}
+		a.Unlock()
+		switch {
+		case am == nil || len(path) > 2 || (len(path) == 2 && path[1] != "analysis"):
+			w.WriteHeader(404)
+			io.WriteString(w, errorJSON(fmt.Sprintf("There's no archived match %s.", n)))
+		case len(path) == 2:
+			s.analyze(w, r, am)
+		default:
+			json.NewEncoder(w).Encode(am)
+		}
+		return
+	}
+
+	q := archiveQuery{variant: r.FormValue("variant")}
+	if p := r.FormValue("player"); p != "" {
+		q.player = s.playerID(p)
+	}
+	if o := r.FormValue("opponent"); o != "" {
+		q.opponent = s.playerID(o)
+	}
+	for _, d := range []struct {
+		param string
+		t     *time.Time
+	}{{"from", &q.from}, {"to", &q.to}} {
+		v := r.FormValue(d.param)
+		if v == "" {
+			continue
+		}
+		t, err := parseDate(v)
+		if err != nil {
+			w.WriteHeader(400)
+			io.WriteString(w, errorJSON(fmt.Sprintf("%s should be a date like 2020-06-28, not %s.", d.param, v)))
+			return
+		}
+		*d.t = t
+	}
+	limit := 50
+	if l := r.FormValue("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 {
+			w.WriteHeader(400)
+			io.WriteString(w, errorJSON("limit should be a positive number."))
+			return
+		}
+	}
+
+	found := make([]archivedMatch, 0)
+	a.Lock()
+	for i := len(a.matches) - 1; i >= 0 && len(found) < limit; i-- {
+		if q.matches(a.matches[i]) {
+			am := *a.matches[i]
+			am.Moves = nil
+			found = append(found, am)
+		}
+	}
+	a.Unlock()
+	json.NewEncoder(w).Encode(found)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/google/go-cmp/cmp"
+	"github.com/sbadame/scopa/scopa"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func tempArchive(t *testing.T) *archive {
+	return loadArchive(tempStore(t, "archive", archiveMigrations...))
+}
+
+// playGame plays a whole game between a and b in a new match, taking a card off the table whenever one
+// matches and dropping otherwise.
+func playGame(t *testing.T, s *server, a, b string) {
+	s.m.Lock()
+	s.m.Reset(s.m.ID + 1)
+	id := s.m.ID
+	s.m.Unlock()
+
+	tokens := make(map[string]string)
+	for _, n := range []string{a, b} {
+		p, err := s.m.addPlayer(id, n, n, "", s.sb)
+		if err != nil {
+			t.Fatalf("Couldn't seat %s: %v", n, err)
+		}
+		tokens[n] = p.token
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	for !s.m.state.Ended() {
+		next := s.m.state.NextPlayer
+		var hand []scopa.Card
+		for _, p := range s.m.state.Players {
+			if p.Name == next {
+				hand = p.Hand
+			}
+		}
+		card, table := hand[0], []scopa.Card(nil)
+	search:
+		for _, h := range hand {
+			for _, c := range s.m.state.Table {
+				if h.Value == c.Value {
+					card, table = h, []scopa.Card{c}
+					break search
+				}
+			}
+		}
+		var err error
+		if table != nil {
+			err = s.m.take(tokens[next], card, table, s.sb)
+		} else {
+			err = s.m.drop(tokens[next], card, s.sb)
+		}
+		if err != nil {
+			t.Fatalf("%s couldn't move: %v", next, err)
+		}
+	}
+}
+
+func TestArchive(t *testing.T) {
+	s := &server{m: Match{ID: 1}, sb: tempScoreboard(t), accounts: tempAccounts(t)}
+	s.m.archive = tempArchive(t)
+	playGame(t, s, "a", "b")
+	resignGame(t, s, "a", "c", "c")
+
+	// The full game replays from its seed to how it ended.
+	s.m.archive.Lock()
+	played := s.m.archive.matches[0]
+	s.m.archive.Unlock()
+	g, err := played.replay()
+	if err != nil {
+		t.Fatalf("Couldn't replay the game: %v", err)
+	}
+	if !g.Ended() || len(played.Moves) != 36 {
+		t.Errorf("Expected the replay to end after all 36 cards, got %d moves", len(played.Moves))
+	}
+	for i, p := range played.Players {
+		if p.Points != len(g.Players[i].Awards)+g.Players[i].Scopas || p.Cards != len(g.Players[i].Grabbed) {
+			t.Errorf("The replay of %s doesn't match the archive: %+v vs %+v", p.Nickname, g.Players[i], p)
+		}
+	}
+
+	// The archive survives a restart.
+	loaded := loadArchive(s.m.archive.store)
+	if d := cmp.Diff(s.m.archive.matches, loaded.matches); d != "" {
+		t.Errorf("mismatch (-recorded, +loaded):\n%s", d)
+	}
+
+	get := func(url string, v interface{}) int {
+		w := httptest.NewRecorder()
+		s.archived(w, httptest.NewRequest("GET", url, nil))
+		json.Unmarshal(w.Body.Bytes(), v)
+		return w.Code
+	}
+	tomorrow := time.Now().Add(24 * time.Hour).Format("2006-01-02")
+	for _, tc := range []struct {
+		query string
+		want  []int // Numbers.
+	}{
+		{"", []int{2, 1}},
+		{"?player=a", []int{2, 1}},
+		{"?player=a&opponent=c", []int{2}},
+		{"?player=b&opponent=c", []int{}},
+		{"?variant=scopa&limit=1", []int{2}},
+		{"?variant=asso", []int{}},
+		{"?from=" + tomorrow, []int{}},
+		{"?to=" + tomorrow, []int{2, 1}},
+	} {
+		var found []archivedMatch
+		if code := get("/archive"+tc.query, &found); code != 200 {
+			t.Errorf("%s: got %d", tc.query, code)
+			continue
+		}
+		got := make([]int, 0)
+		for _, m := range found {
+			got = append(got, m.Number)
+			if len(m.Moves) > 0 {
+				t.Errorf("%s: expected the list to leave out the moves", tc.query)
+			}
+		}
+		if d := cmp.Diff(tc.want, got); d != "" {
+			t.Errorf("%s mismatch (-want +got):\n%s", tc.query, d)
+		}
+	}
+	if code := get("/archive?from=yesterday", nil); code != 400 {
+		t.Errorf("Expected a bad date to fail, got %d", code)
+	}
+
+	var resigned archivedMatch
+	if code := get("/archive/2", &resigned); code != 200 || resigned.Forfeited != "c" || len(resigned.Moves) != 1 {
+		t.Errorf("Expected match 2 to be c resigning, got %d: %+v", code, resigned)
+	}
+	if code := get("/archive/3", nil); code != 404 {
+		t.Errorf("Expected a 404 for a match that isn't archived, got %d", code)
+	}
+
+	// The last move of the game only had one card to play.
+	var analyzed analysis
+	if code := get("/archive/1/analysis?move=36&iterations=100", &analyzed); code != 200 || len(analyzed.Moves) == 0 {
+		t.Fatalf("Couldn't analyze the last move, got %d: %+v", code, analyzed)
+	}
+	if d := cmp.Diff(played.Moves[35], analyzed.Played); d != "" {
+		t.Errorf("analyzed move mismatch (-want +got):\n%s", d)
+	}
+	card := analyzed.Played.Drop
+	if card == nil {
+		card = analyzed.Played.Take
+	}
+	if analyzed.Number != 1 || *card != analyzed.Moves[0].Card {
+		t.Errorf("Expected the only card left to be analyzed, got %+v", analyzed)
+	}
+	for _, tc := range []struct {
+		url  string
+		code int
+	}{
+		{"/archive/1/analysis?move=37", 400},
+		{"/archive/1/analysis?move=1&iterations=50000", 400},
+		{"/archive/2/analysis?move=1", 400}, // c resigned, which isn't playing a card.
+		{"/archive/3/analysis?move=1", 404},
+		{"/archive/1/replay", 404},
+	} {
+		if code := get(tc.url, nil); code != tc.code {
+			t.Errorf("%s: expected %d, got %d", tc.url, tc.code, code)
+		}
+	}
+
+	// Only so many analyses run at once.
+	for i := 0; i < maxAnalyses; i++ {
+		analyses <- struct{}{}
+	}
+	if code := get("/archive/1/analysis?move=36&iterations=100", nil); code != 503 {
+		t.Errorf("Expected a 503 while the server is busy analyzing, got %d", code)
+	}
+	for i := 0; i < maxAnalyses; i++ {
+		<-analyses
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"github.com/sbadame/scopa/scopa"
+	"github.com/sbadame/scopa/scopa/bot"
+	"io"
+	"net/http"
+)
+
+// botName is what the server's bot plays under, as its ID and its nickname. Guests can't pick it.
+const botName = "Scopabot"
+
+// maxPracticeTables is how many tables can be open for practicing against the bot at once, since guests can start
+// as many as they have nicknames.
+const maxPracticeTables = 200
+
+// botToken returns the seat token of the player that the bot moves for when it's their turn, "" when it isn't the
+// bot's turn. Callers must hold the lock.
+func (m *Match) botToken() string {
+	if len(m.players) < 2 || m.state.Ended() {
+		return ""
+	}
+	for _, p := range m.players {
+		if p.nick == m.state.NextPlayer && (p.token == m.bot || m.substitutes[p.nick]) {
+			return p.token
+		}
+	}
+	return ""
+}
+
+// moveBot plays for the bot, or for the player it's substituting, when it's their turn. Hard searches for its move
+// without the lock, and plays it later if the game hasn't moved on by then. Callers must hold the lock.
+func (m *Match) moveBot(sb *scoreboard) {
+	token := m.botToken()
+	if token == "" {
+		return
+	}
+	// Seeded by the deal and the move, so that the same moves always get the same answer, like in the daily challenge.
+	b := bot.New(m.botLevel, m.seed+int64(len(m.moves)))
+	if m.botLevel != bot.Hard {
+		m.playBot(token, b.Move(m.state), sb)
+		return
+	}
+
+	m.searchTurns++
+	id, turn, moves, g := m.ID, m.searchTurns, len(m.moves), m.state.Clone()
+	m.searches.Add(1)
+	go func() {
+		defer m.searches.Done()
+		mv := b.Move(g)
+		m.Lock()
+		defer m.Unlock()
+		if m.ID == id && m.searchTurns == turn && len(m.moves) == moves && m.botToken() == token {
+			m.playBot(token, mv, sb)
+		}
+	}()
+}
+
+// playBot makes the bot's move for the player holding token. Callers must hold the lock.
+func (m *Match) playBot(token string, mv scopa.Move, sb *scoreboard) {
+	var err error
+	if len(mv.Table) > 0 {
+		err = m.take(token, mv.Card, mv.Table, sb)
+	} else {
+		err = m.drop(token, mv.Card, sb)
+	}
+	if err != nil {
+		m.logs = append(m.logs, fmt.Sprintf("FAIL bot: %#v, %#v\n", mv, err))
+	}
+}
+
+// addBotTable adds a table where the player with id plays the bot. The bot's games aren't rated, and don't count
+// towards anyone's stats. Callers must hold s.m's lock.
+func (s *server) addBotTable(id string) *Match {
+	table := s.addTable(&s.m, id, botName)
+	table.ratings, table.stats = nil, nil
+	return table
+}
+
+// startPractice seats the player with id at a table of their own against the bot playing at level. Players only get
+// one practice table, so the one they had before is taken down, and it's taken down once they stop playing rematches.
+func (s *server) startPractice(id string, level bot.Level) (int64, error) {
+	s.m.Lock()
+	table := s.addBotTable(id)
+	table.botLevel = level
+	table.onOver = func(m *Match) { s.practiceOver(id, m.ID) }
+	s.m.Unlock()
+
+	s.tablesLock.Lock()
+	if s.practicing == nil {
+		s.practicing = make(map[string]int64)
+	}
+	old, ok := s.practicing[id]
+	if !ok && len(s.practicing) >= maxPracticeTables {
+		s.deleteTable(table.ID)
+		s.tablesLock.Unlock()
+		return 0, matchErrorf(503, "Every practice table is taken, try again later.")
+	}
+	if ok {
+		s.deleteTable(old)
+	}
+	s.practicing[id] = table.ID
+	s.tablesLock.Unlock()
+
+	p, err := table.addPlayer(table.ID, botName, botName, "", s.sb)
+	if err != nil {
+		return 0, err
+	}
+	table.Lock()
+	table.bot = p.token
+	table.Unlock()
+	return table.ID, nil
+}
+
+// practiceOver takes down the practice table with matchID, where the player with id won't play again.
+func (s *server) practiceOver(id string, matchID int64) {
+	s.tablesLock.Lock()
+	defer s.tablesLock.Unlock()
+	s.deleteTable(matchID)
+	if s.practicing[id] == matchID {
+		delete(s.practicing, id)
+	}
+}
+
+// practice serves POST /bot {"Nickname": "marco", "Level": "hard"}, which starts a game against the bot for a guest,
+// or for whoever is logged in. The level is easy, medium or hard, and medium when it's left out. It returns the
+// MatchID to join, and the bot is already sitting there. The bot plays as many rematches as the player wants.
+func (s *server) practice(w http.ResponseWriter, r *http.Request) {
+	var p struct{ Nickname, Level string }
+	if !parseRequestJSON(w, r, &p) {
+		return
+	}
+	level := bot.Medium
+	if p.Level != "" {
+		var err error
+		if level, err = bot.ParseLevel(p.Level); err != nil {
+			w.WriteHeader(400)
+			io.WriteString(w, errorJSON(err.Error()))
+			return
+		}
+	}
+
+	var id string
+	if acct := s.accounts.fromRequest(r); acct != nil {
+		id = acct.ID
+	} else {
+		var err error
+		if id, err = s.guestID(p.Nickname); err != nil {
+			w.WriteHeader(400)
+			io.WriteString(w, errorJSON(err.Error()))
+			return
+		}
+	}
+	matchID, err := s.startPractice(id, level)
+	if err != nil {
+		w.WriteHeader(statusCode(err))
+		io.WriteString(w, errorJSON(err.Error()))
+		return
+	}
+	io.WriteString(w, fmt.Sprintf(`{"MatchID": %d}`, matchID))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sbadame/scopa/protocol"
+	"github.com/sbadame/scopa/scopa/bot"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// waitForBot waits for the bot to make the move that it's searching for, if it's searching for one. Callers must
+// hold m's lock.
+func waitForBot(m *Match) {
+	m.Unlock()
+	m.searches.Wait()
+	m.Lock()
+}
+
+func TestPractice(t *testing.T) {
+	s := &server{m: Match{ID: 1}, sb: tempScoreboard(t), accounts: tempAccounts(t)}
+	s.m.ratings = tempRatings(t, s.sb)
+	start := func(nick, level string) (int64, int) {
+		body := fmt.Sprintf(`{"Nickname": %q, "Level": %q}`, nick, level)
+		r := httptest.NewRequest("POST", "/bot", strings.NewReader(body))
+		r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		w := httptest.NewRecorder()
+		s.practice(w, r)
+		var started struct{ MatchID int64 }
+		json.Unmarshal(w.Body.Bytes(), &started)
+		return started.MatchID, w.Code
+	}
+
+	if _, code := start(botName, ""); code != 400 {
+		t.Errorf("Expected the bot's nickname to be taken, got %d", code)
+	}
+	if _, code := start("marco", "grandmaster"); code != 400 {
+		t.Errorf("Expected an unknown level to be refused, got %d", code)
+	}
+	first, code := start("marco", "")
+	if code != 200 || first == 0 {
+		t.Fatalf("Couldn't start practicing: %d", code)
+	}
+	id, code := start("marco", "hard")
+	if code != 200 || id == first {
+		t.Fatalf("Couldn't start practicing again: %d", code)
+	}
+	if s.match(first) == s.match(id) || s.match(first) != &s.m {
+		t.Errorf("Expected the first table to be taken down.")
+	}
+
+	m := s.match(id)
+	p, err := m.addPlayer(id, "marco", "marco", "", s.sb)
+	if err != nil {
+		t.Fatalf("Couldn't seat marco: %v", err)
+	}
+	m.Lock()
+	defer m.Unlock()
+	if m.botLevel != bot.Hard || m.ratings != nil {
+		t.Errorf("Expected an unrated game against the hard bot, got %v rated %v", m.botLevel, m.ratings != nil)
+	}
+	for waitForBot(m); !m.state.Ended(); waitForBot(m) {
+		if m.state.NextPlayer != "marco" {
+			t.Fatalf("Expected it to be marco's turn, not %s's", m.state.NextPlayer)
+		}
+		playMove(t, m, s.sb)
+	}
+
+	// The bot always plays again.
+	if err := m.offerRematch(p.token, protocol.RematchOptions{SwapSeats: true}, s.sb); err != nil {
+		t.Fatalf("Couldn't offer a rematch: %v", err)
+	}
+	if m.state.Ended() || m.rematch != nil || m.ID != id {
+		t.Errorf("Expected the bot to accept the rematch, got %+v", m.rematch)
+	}
+	waitForBot(m)
+	if s.matchFor(p.token) != m {
+		t.Errorf("Expected marco's seat token to find the table.")
+	}
+
+	// The table is taken down once marco stops playing.
+	if err := m.resign(p.token, s.sb); err != nil {
+		t.Fatalf("Couldn't resign: %v", err)
+	}
+	if err := m.leave(p.token); err != nil {
+		t.Fatalf("Couldn't leave: %v", err)
+	}
+	if s.match(id) == m || len(s.practicing) != 0 || len(s.tokens) != 0 {
+		t.Errorf("Expected the table to be taken down, practicing at %v with seats %v", s.practicing, s.tokens)
+	}
+	if s.matchFor(p.token) != &s.m {
+		t.Errorf("Expected marco's seat token to be forgotten with the table.")
+	}
+}
+
+func TestHardSearchesWithoutTheLock(t *testing.T) {
+	s := &server{m: Match{ID: 1}, sb: tempScoreboard(t), accounts: tempAccounts(t)}
+	id, err := s.startPractice("marco", bot.Hard)
+	if err != nil {
+		t.Fatalf("Couldn't start practicing: %v", err)
+	}
+	m := s.match(id)
+	p, err := m.addPlayer(id, "marco", "marco", "", s.sb)
+	if err != nil {
+		t.Fatalf("Couldn't seat marco: %v", err)
+	}
+
+	// The bot sat down first, so it moves first. marco resigns while it's still thinking, and its move is thrown away.
+	m.Lock()
+	defer m.Unlock()
+	if m.state.NextPlayer != botName {
+		t.Fatalf("Expected the bot to still be thinking about its first move, got %+v", m.moves)
+	}
+	if err := m.resign(p.token, s.sb); err != nil {
+		t.Fatalf("Couldn't resign: %v", err)
+	}
+	waitForBot(m)
+	if len(m.moves) != 1 || !m.moves[0].Forfeit {
+		t.Errorf("Expected only marco's resignation, got %+v", m.moves)
+	}
+	for _, l := range m.logs {
+		if strings.Contains(l, "FAIL") {
+			t.Errorf("Expected the bot not to try its move: %s", l)
+		}
+	}
+}
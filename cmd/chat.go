@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"github.com/sbadame/scopa/protocol"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// chatPresets are the quick phrases that players can send with a single click, by key.
+var chatPresets = map[string]string{
+	"bella":     "Bella scopa!",
+	"ciao":      "Ciao",
+	"bravo":     "Bravo!",
+	"grazie":    "Grazie",
+	"sfortuna":  "Che sfortuna!",
+	"rivincita": "Un'altra partita?",
+}
+
+const (
+	maxChatLength = 200 // In runes.
+
+	// Players can send chatBurst messages in a row, and then one every chatRefill.
+	chatBurst  = 5
+	chatRefill = 3 * time.Second
+)
+
+// rateLimit is a token bucket, the zero value starts out full.
+type rateLimit struct {
+	used float64
+	last time.Time
+}
+
+func (r *rateLimit) allow(now time.Time) bool {
+	if !r.last.IsZero() {
+		r.used -= float64(now.Sub(r.last)) / float64(chatRefill)
+		if r.used < 0 {
+			r.used = 0
+		}
+	}
+	r.last = now
+	if r.used+1 > chatBurst {
+		return false
+	}
+	r.used++
+	return true
+}
+
+// chat broadcasts text, or the preset phrase with the given key, from the player holding token.
+// Callers must hold the lock.
+func (m *Match) chat(token, text, preset string) error {
+	p := m.seat(token)
+	if p == nil {
+		return matchErrorf(403, "You don't have a seat in this match.")
+	}
+
+	if preset != "" {
+		var ok bool
+		if text, ok = chatPresets[preset]; !ok {
+			return matchErrorf(400, "%s isn't a quick phrase.", preset)
+		}
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return matchErrorf(400, "Chat messages can't be empty.")
+	}
+	if utf8.RuneCountInString(text) > maxChatLength {
+		return matchErrorf(400, "Chat messages can't be longer than %d characters.", maxChatLength)
+	}
+
+	if m.chatLimits == nil {
+		m.chatLimits = make(map[string]*rateLimit)
+	}
+	l, ok := m.chatLimits[p.nick]
+	if !ok {
+		l = &rateLimit{}
+		m.chatLimits[p.nick] = l
+	}
+	now := m.now()
+	if !l.allow(now) {
+		return matchErrorf(429, "Slow down! You're sending too many messages.")
+	}
+
+	c := &protocol.Chat{From: p.nick, Text: text, Time: now.UnixNano() / int64(time.Millisecond)}
+	m.logs = append(m.logs, fmt.Sprintf("chat: %s: %q\n", c.From, c.Text))
+	m.appendEvent(event{chat: c})
+	return nil
+}
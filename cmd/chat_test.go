@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChat(t *testing.T) {
+	m, c, _ := clockedMatch(t, "")
+	a := m.players[0]
+
+	if err := m.chat("nobody", "hi", ""); err == nil {
+		t.Errorf("Expected chatting without a seat to fail.")
+	}
+	if err := m.chat(a.token, "   ", ""); err == nil {
+		t.Errorf("Expected an empty message to fail.")
+	}
+	if err := m.chat(a.token, strings.Repeat("a", maxChatLength+1), ""); err == nil {
+		t.Errorf("Expected a long message to fail.")
+	}
+
+	if err := m.chat(a.token, "", "bella"); err != nil {
+		t.Fatalf("Couldn't send a preset: %v", err)
+	}
+	e := m.events[len(m.events)-1]
+	if e.chat == nil || e.chat.Text != "Bella scopa!" || e.chat.From != a.nick || e.chat.Time != 1000000 {
+		t.Errorf("Expected the preset to be broadcast with a timestamp, got %#v", e.chat)
+	}
+	select {
+	case <-m.players[1].conn.updates:
+	default:
+		t.Errorf("Expected the other player to be notified of the message.")
+	}
+
+	// The first message used one of the burst.
+	for i := 1; i < chatBurst; i++ {
+		if err := m.chat(a.token, "spam", ""); err != nil {
+			t.Fatalf("Message %d should have been allowed: %v", i, err)
+		}
+	}
+	if err := m.chat(a.token, "spam", ""); err == nil {
+		t.Errorf("Expected to be rate limited.")
+	}
+	c.Advance(chatRefill)
+	if err := m.chat(a.token, "ok now", ""); err != nil {
+		t.Errorf("Expected to be allowed to chat after waiting: %v", err)
+	}
+
+	// Clients that join late start at the latest state, and get the chat after it.
+	if got, want := m.latestState(), 1; got != want {
+		t.Errorf("Expected the latest state to be event %d, got %d", want, got)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"github.com/sbadame/scopa/protocol"
+	"github.com/sbadame/scopa/scopa"
+	"strings"
+	"time"
+)
+
+// clock is where a match gets the time from, tests swap it out to control time.
+type clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) stopper
+}
+
+// stopper cancels a pending AfterFunc call, like *time.Timer.
+type stopper interface {
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) AfterFunc(d time.Duration, f func()) stopper { return time.AfterFunc(d, f) }
+
+// The different kinds of time controls.
+const (
+	noClock        = ""
+	moveClock      = protocol.MoveClock      // Every move has to be made within PerMove, or a card is dropped for you.
+	totalClock     = protocol.TotalClock     // Chess style, Initial time for the whole game plus Increment per move.
+	correspondence = protocol.Correspondence // Every move has to be made within PerMove (usually days), or you forfeit.
+)
+
+// timeControl configures the clocks of a match.
+type timeControl struct {
+	Mode      string
+	PerMove   time.Duration
+	Initial   time.Duration
+	Increment time.Duration
+}
+
+// parseTimeControl parses "move:30s", "total:5m+3s" or "correspondence:72h". The empty string means no clock.
+func parseTimeControl(s string) (timeControl, error) {
+	if s == "" || s == "none" {
+		return timeControl{}, nil
+	}
+
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return timeControl{}, fmt.Errorf("time control %q should look like mode:duration", s)
+	}
+
+	var err error
+	tc := timeControl{Mode: parts[0]}
+	switch tc.Mode {
+	case moveClock, correspondence:
+		tc.PerMove, err = time.ParseDuration(parts[1])
+	case totalClock:
+		d := strings.SplitN(parts[1], "+", 2)
+		if tc.Initial, err = time.ParseDuration(d[0]); err == nil && len(d) == 2 {
+			tc.Increment, err = time.ParseDuration(d[1])
+		}
+	default:
+		return timeControl{}, fmt.Errorf("unknown time control mode %q", tc.Mode)
+	}
+	if err != nil {
+		return timeControl{}, fmt.Errorf("time control %q has a bad duration: %v", s, err)
+	}
+	if tc.PerMove < 0 || tc.Initial < 0 || tc.Increment < 0 || tc.PerMove+tc.Initial == 0 {
+		return timeControl{}, fmt.Errorf("time control %q needs a positive duration", s)
+	}
+	return tc, nil
+}
+
+func millis(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+func (m *Match) now() time.Time {
+	if m.clock == nil {
+		return time.Now()
+	}
+	return m.clock.Now()
+}
+
+func (m *Match) afterFunc(d time.Duration, f func()) stopper {
+	if m.clock == nil {
+		return realClock{}.AfterFunc(d, f)
+	}
+	return m.clock.AfterFunc(d, f)
+}
+
+// stopClock stops the running clock, callers must hold the lock.
+func (m *Match) stopClock() {
+	if m.timer != nil {
+		m.timer.Stop()
+		m.timer = nil
+	}
+	m.deadline = time.Time{}
+}
+
+// startClock charges the player that just moved, and starts the clock of the player to move.
+// Callers must hold the lock.
+func (m *Match) startClock(sb *scoreboard) {
+	m.stopClock()
+	if m.control.Mode == noClock {
+		return
+	}
+
+	now := m.now()
+	if m.control.Mode == totalClock {
+		if m.remaining == nil {
+			m.remaining = make(map[string]time.Duration)
+			for _, p := range m.players {
+				m.remaining[p.nick] = m.control.Initial
+			}
+		}
+		if m.turnPlayer != "" {
+			m.remaining[m.turnPlayer] += m.control.Increment - now.Sub(m.turnStart)
+		}
+	}
+	if m.state.Ended() {
+		return
+	}
+
+	m.turns++
+	m.turnPlayer, m.turnStart = m.state.NextPlayer, now
+	limit := m.control.PerMove
+	if m.control.Mode == totalClock {
+		limit = m.remaining[m.turnPlayer]
+	}
+	m.deadline = now.Add(limit)
+
+	id, turn := m.ID, m.turns
+	m.timer = m.afterFunc(limit, func() {
+		m.Lock()
+		defer m.Unlock()
+		// Moves and resets that raced with the timer win.
+		if m.ID == id && m.turns == turn {
+			m.timeout(sb)
+		}
+	})
+}
+
+// timeout plays for, or forfeits, the player who ran out of time. Callers must hold the lock.
+func (m *Match) timeout(sb *scoreboard) {
+	nick := m.state.NextPlayer
+	if m.control.Mode == moveClock {
+		// Drop the cheapest card in their hand.
+		var hand []scopa.Card
+		for _, p := range m.state.Players {
+			if p.Name == nick {
+				hand = p.Hand
+			}
+		}
+		c := hand[0]
+		for _, h := range hand {
+			if h.Value < c.Value {
+				c = h
+			}
+		}
+		if err := m.state.Drop(c); err != nil {
+			m.logs = append(m.logs, fmt.Sprintf("FAIL timeout drop: %#v, %#v\n", c, err))
+			return
+		}
+		m.logs = append(m.logs, fmt.Sprintf("timeout drop: %s, %#v\n", nick, c))
+		m.played(archivedMove{Player: nick, Drop: &c, Timeout: true})
+	} else {
+		if m.control.Mode == totalClock {
+			m.remaining[nick] = 0
+		}
+		m.turnPlayer = "" // Their clock has already been charged.
+		if err := m.state.Forfeit(nick); err != nil {
+			m.logs = append(m.logs, fmt.Sprintf("FAIL timeout forfeit: %s, %#v\n", nick, err))
+			return
+		}
+		m.ending = protocol.TimedOut
+		m.logs = append(m.logs, fmt.Sprintf("timeout forfeit: %s\n", nick))
+		m.played(archivedMove{Player: nick, Forfeit: true, Timeout: true})
+	}
+	m.endTurn(sb)
+}
+
+// clockView snapshots the clocks for clients, callers must hold the lock.
+func (m *Match) clockView() *protocol.Clock {
+	if m.control.Mode == noClock {
+		return nil
+	}
+
+	v := &protocol.Clock{Mode: m.control.Mode, Remaining: make(map[string]int64)}
+	for _, p := range m.players {
+		if m.control.Mode == totalClock {
+			v.Remaining[p.nick] = millis(m.remaining[p.nick])
+		} else {
+			v.Remaining[p.nick] = millis(m.control.PerMove)
+		}
+	}
+	if !m.deadline.IsZero() {
+		v.Deadline = m.deadline.UnixNano() / int64(time.Millisecond)
+		v.Remaining[m.turnPlayer] = millis(m.deadline.Sub(m.now()))
+	}
+	return v
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves forward when Advance is called, and runs timers as it goes.
+type fakeClock struct {
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	when    time.Time
+	f       func()
+	stopped bool
+}
+
+func (t *fakeTimer) Stop() bool {
+	wasRunning := !t.stopped
+	t.stopped = true
+	return wasRunning
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) stopper {
+	t := &fakeTimer{when: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+	// Timers can start more timers, so don't range over a copy.
+	for i := 0; i < len(c.timers); i++ {
+		if t := c.timers[i]; !t.stopped && !t.when.After(c.now) {
+			t.stopped = true
+			t.f()
+		}
+	}
+}
+
+func TestParseTimeControl(t *testing.T) {
+	for s, want := range map[string]timeControl{
+		"":                   {},
+		"move:30s":           {Mode: moveClock, PerMove: 30 * time.Second},
+		"total:5m+3s":        {Mode: totalClock, Initial: 5 * time.Minute, Increment: 3 * time.Second},
+		"total:5m":           {Mode: totalClock, Initial: 5 * time.Minute},
+		"correspondence:72h": {Mode: correspondence, PerMove: 72 * time.Hour},
+	} {
+		got, err := parseTimeControl(s)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", s, err)
+		}
+		if d := cmp.Diff(want, got); d != "" {
+			t.Errorf("%q: mismatch (-want +got):\n%s", s, d)
+		}
+	}
+
+	for _, s := range []string{"move", "move:soon", "blitz:3m", "move:0s"} {
+		if _, err := parseTimeControl(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func clockedMatch(t *testing.T, tc string) (*Match, *fakeClock, *scoreboard) {
+	control, err := parseTimeControl(tc)
+	if err != nil {
+		t.Fatalf("Bad time control: %v", err)
+	}
+	c := &fakeClock{now: time.Unix(1000, 0)}
+	m := &Match{ID: 1, clock: c, control: control, defaultControl: control}
+	sb := tempScoreboard(t)
+	for _, n := range []string{"a", "b"} {
+		if _, err := m.addPlayer(1, n, n, "", sb); err != nil {
+			t.Fatalf("Couldn't join a match: %v", err)
+		}
+	}
+	return m, c, sb
+}
+
+func TestMoveClock(t *testing.T) {
+	m, c, _ := clockedMatch(t, "move:30s")
+	first := m.state.NextPlayer
+
+	c.Advance(29 * time.Second)
+	if v := m.clockView(); v.Remaining[first] != 1000 {
+		t.Errorf("Expected 1s left, got %dms", v.Remaining[first])
+	}
+	if m.state.NextPlayer != first {
+		t.Fatalf("Expected %s to still be thinking.", first)
+	}
+
+	c.Advance(time.Second)
+	if m.state.NextPlayer == first || m.state.LastMove.Drop == nil {
+		t.Errorf("Expected a card to be dropped for %s when their time ran out: %#v", first, m.state.LastMove)
+	}
+	if m.state.Ended() {
+		t.Errorf("Running out of time on a move shouldn't end the game.")
+	}
+}
+
+func TestTotalClock(t *testing.T) {
+	m, c, sb := clockedMatch(t, "total:1m+10s")
+	first := m.state.NextPlayer
+	second := m.players[1].nick
+
+	// Moving in 20s leaves 1m - 20s + 10s.
+	c.Advance(20 * time.Second)
+	if err := m.state.Drop(m.state.Players[0].Hand[0]); err != nil {
+		t.Fatalf("Couldn't drop: %v", err)
+	}
+	m.endTurn(sb)
+	if got := m.remaining[first]; got != 50*time.Second {
+		t.Errorf("Expected 50s left for %s, got %v", first, got)
+	}
+
+	c.Advance(time.Minute)
+	if m.state.Forfeited != second {
+		t.Fatalf("Expected %s to forfeit on time, but forfeited is %q", second, m.state.Forfeited)
+	}
+	if got := sb.scores(first, second)[first]; got != 4 {
+		t.Errorf("Expected %s to be awarded 4 points for the forfeit, got %d", first, got)
+	}
+	if e := m.events[len(m.events)-1]; e.clock.Remaining[second] != 0 || e.clock.Deadline != 0 {
+		t.Errorf("Expected the clock to be stopped with no time left: %#v", e.clock)
+	}
+}
+
+func TestTimeoutFails(t *testing.T) {
+	m, _, sb := clockedMatch(t, "total:1m")
+	m.state.NextPlayer = "nobody"
+	m.timeout(sb)
+	if m.state.Ended() || m.ending != "" {
+		t.Errorf("Expected a forfeit that failed to leave the game going, got ending %q", m.ending)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sbadame/scopa/store"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dailies are the daily challenges: one deal a day, in UTC, that everyone plays once against the bot.
+type dailies struct {
+	sync.Mutex
+	store store.Store
+	Salt  int64 // Mixed into every day's seed, so that nobody can work out the deal from the date.
+	Days  map[string]*daily
+}
+
+// daily is a day's challenge, by date like 2020-06-28.
+type daily struct {
+	Date string
+	Seed int64
+	Runs []*dailyRun // In the order they were started.
+}
+
+// dailyRun is a player's go at a daily challenge. Runs that were never finished, like those that were being played
+// when the server stopped, still use up the player's go.
+type dailyRun struct {
+	Player    string // ID.
+	MatchID   int64
+	Started   time.Time
+	Ended     time.Time      `json:",omitempty"`
+	Points    int            // The player's.
+	BotPoints int            // The bot's.
+	Moves     []archivedMove `json:",omitempty"`
+}
+
+// dailiesMigrations bring older daily challenge files up to date, see store.Open.
+var dailiesMigrations = []store.Migration{store.Unchanged}
+
+func loadDailies(st store.Store) *dailies {
+	d := &dailies{store: st, Days: make(map[string]*daily)}
+	if err := st.Load(d, nil); err != nil {
+		fmt.Printf("Couldn't read the daily challenges, %v\n", err)
+	}
+	if d.Salt == 0 {
+		d.Salt = rand.Int63()
+	}
+	return d
+}
+
+// save writes the daily challenges to disk, callers must hold the lock.
+func (d *dailies) save() {
+	if err := d.store.Save(d); err != nil {
+		fmt.Printf("Couldn't save the daily challenges: %v\n", err)
+	}
+}
+
+// day returns the challenge on date, and creates it if nobody has played it yet. Callers must hold the lock.
+func (d *dailies) day(date string) *daily {
+	if c, ok := d.Days[date]; ok {
+		return c
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d %s", d.Salt, date)
+	c := &daily{Date: date, Seed: int64(h.Sum64())}
+	d.Days[date] = c
+	return c
+}
+
+// leaderboard returns the finished runs, with the most points first. Ties are broken by the biggest win over the
+// bot, and then by who finished first.
+func (c *daily) leaderboard() []*dailyRun {
+	var runs []*dailyRun
+	for _, r := range c.Runs {
+		if !r.Ended.IsZero() {
+			runs = append(runs, r)
+		}
+	}
+	sort.SliceStable(runs, func(i, j int) bool {
+		a, b := runs[i], runs[j]
+		switch {
+		case a.Points != b.Points:
+			return a.Points > b.Points
+		case a.Points-a.BotPoints != b.Points-b.BotPoints:
+			return a.Points-a.BotPoints > b.Points-b.BotPoints
+		}
+		return a.Ended.Before(b.Ended)
+	})
+	return runs
+}
+
+// today is the date of the daily challenge being played.
+func (s *server) today() string {
+	return s.m.now().UTC().Format("2006-01-02")
+}
+
+// startDaily seats the player with id at a table against the bot, dealt today's deal. A player only gets one go a
+// day, so it returns the table they're already playing at if there is one.
+func (s *server) startDaily(id string) (int64, error) {
+	d := s.dailies
+	s.m.Lock()
+	d.Lock()
+	c := d.day(s.today())
+	for _, r := range c.Runs {
+		if r.Player != id {
+			continue
+		}
+		d.Unlock()
+		s.m.Unlock()
+		s.tablesLock.Lock()
+		_, playing := s.tables[r.MatchID]
+		s.tablesLock.Unlock()
+		if playing {
+			return r.MatchID, nil
+		}
+		return 0, fmt.Errorf("You've already played today's deal, come back tomorrow.")
+	}
+
+	// The player always moves first, so that everyone plays the same game for as long as they make the same moves.
+	// Runs aren't archived, since the archive would give away the deal before the day is over, see dailyView.
+	table := s.addBotTable(id)
+	table.seats = []string{id, botName}
+	table.dealSeed = c.Seed
+	table.archive = nil
+	date := c.Date
+	table.onEnd = func(m *Match) { s.dailyOver(date, m) }
+	c.Runs = append(c.Runs, &dailyRun{Player: id, MatchID: table.ID, Started: table.now()})
+	d.save()
+	d.Unlock()
+	s.m.Unlock()
+
+	p, err := table.addPlayer(table.ID, botName, botName, "", s.sb)
+	if err != nil {
+		return 0, err
+	}
+	table.Lock()
+	table.bot = p.token
+	table.Unlock()
+	return table.ID, nil
+}
+
+// dailyOver records the run that just ended in m, and takes the table down. Callers must hold m's lock.
+func (s *server) dailyOver(date string, m *Match) {
+	d := s.dailies
+	d.Lock()
+	defer d.Unlock()
+	for _, r := range d.Days[date].Runs {
+		if r.MatchID != m.ID {
+			continue
+		}
+		for i, p := range m.state.Players {
+			points := len(p.Awards) + p.Scopas
+			if m.players[i].id == botName {
+				r.BotPoints = points
+			} else {
+				r.Points = points
+			}
+		}
+		r.Ended, r.Moves = m.now(), m.moves
+	}
+	s.removeTable(m.ID)
+	d.save()
+}
+
+// dailyView is a daily challenge as the API shows it. The seed and the best run's moves are only shown once the day
+// is over, so that they can't be used to play it.
+type dailyView struct {
+	Date        string
+	Players     int // That started it.
+	Leaderboard []dailyEntry
+	Seed        int64     `json:",omitempty"`
+	Best        *dailyRun `json:",omitempty"`
+}
+
+type dailyEntry struct {
+	Nickname  string
+	Points    int
+	BotPoints int
+	Ended     time.Time
+}
+
+// daily serves the daily challenges:
+//
+//	GET /daily is today's leaderboard, and GET /daily/2020-06-28 is the leaderboard of that day. Past days also
+//	  have their seed, and the moves of the best run to replay it.
+//	POST /daily {"Nickname": "marco"} starts today's challenge for a guest, or for whoever is logged in. It returns
+//	  the MatchID to join, and the bot is already sitting there.
+func (s *server) daily(w http.ResponseWriter, r *http.Request) {
+	d := s.dailies
+	if d == nil {
+		w.WriteHeader(404)
+		io.WriteString(w, errorJSON("There are no daily challenges on this server."))
+		return
+	}
+
+	if r.Method == "POST" {
+		var id string
+		if acct := s.accounts.fromRequest(r); acct != nil {
+			id = acct.ID
+		} else {
+			var p struct{ Nickname string }
+			if !parseRequestJSON(w, r, &p) {
+				return
+			}
+			var err error
+			if id, err = s.guestID(p.Nickname); err != nil {
+				w.WriteHeader(400)
+				io.WriteString(w, errorJSON(err.Error()))
+				return
+			}
+		}
+		matchID, err := s.startDaily(id)
+		if err != nil {
+			w.WriteHeader(400)
+			io.WriteString(w, errorJSON(err.Error()))
+			return
+		}
+		io.WriteString(w, fmt.Sprintf(`{"MatchID": %d}`, matchID))
+		return
+	}
+
+	date := strings.Trim(strings.TrimPrefix(r.URL.Path, "/daily"), "/")
+	if date == "" {
+		date = s.today()
+	}
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		w.WriteHeader(404)
+		io.WriteString(w, errorJSON(fmt.Sprintf("There's no daily challenge on %s, dates look like 2020-06-28.", date)))
+		return
+	}
+
+	v := dailyView{Date: date, Leaderboard: make([]dailyEntry, 0)}
+	d.Lock()
+	if c, ok := d.Days[date]; ok {
+		v.Players = len(c.Runs)
+		runs := c.leaderboard()
+		for _, run := range runs {
+			v.Leaderboard = append(v.Leaderboard, dailyEntry{s.nickname(run.Player), run.Points, run.BotPoints, run.Ended})
+		}
+		if date < s.today() {
+			v.Seed = c.Seed
+			if len(runs) > 0 {
+				best := *runs[0]
+				best.Player = s.nickname(best.Player)
+				v.Best = &best
+			}
+		}
+	}
+	d.Unlock()
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tempDailies(t *testing.T) *dailies {
+	return loadDailies(tempStore(t, "dailies", dailiesMigrations...))
+}
+
+func TestDailyLeaderboard(t *testing.T) {
+	now := time.Now()
+	c := &daily{Runs: []*dailyRun{
+		{Player: "a", Points: 4, BotPoints: 5, Ended: now},
+		{Player: "b", Points: 6, BotPoints: 1, Ended: now.Add(time.Second)},
+		{Player: "c", Points: 6, BotPoints: 1, Ended: now},
+		{Player: "d"},
+		{Player: "e", Points: 6, BotPoints: 0, Ended: now.Add(time.Minute)},
+	}}
+	var got string
+	for _, r := range c.leaderboard() {
+		got += r.Player
+	}
+	if got != "ecba" {
+		t.Errorf("Expected ecba, got %s", got)
+	}
+}
+
+func TestDaily(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2020, 6, 28, 12, 0, 0, 0, time.UTC)}
+	s := &server{m: Match{ID: 1, clock: clock}, sb: tempScoreboard(t), accounts: tempAccounts(t), dailies: tempDailies(t)}
+	s.m.archive = tempArchive(t)
+	do := func(method, url, body string, v interface{}) int {
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		w := httptest.NewRecorder()
+		s.daily(w, r)
+		if v != nil {
+			json.Unmarshal(w.Body.Bytes(), v)
+		}
+		return w.Code
+	}
+	start := func(nick string) (int64, int) {
+		var started struct{ MatchID int64 }
+		code := do("POST", "/daily", fmt.Sprintf(`{"Nickname": %q}`, nick), &started)
+		return started.MatchID, code
+	}
+
+	id, code := start("marco")
+	if code != 200 || id == 0 {
+		t.Fatalf("Couldn't start the daily challenge: %d", code)
+	}
+	if again, _ := start("marco"); again != id {
+		t.Errorf("Expected to get back to table %d, got %d", id, again)
+	}
+	if _, code := start(botName); code != 400 {
+		t.Errorf("Expected the bot's nickname to be taken, got %d", code)
+	}
+
+	// The bot moves as soon as it's its turn, so it's always marco's.
+	m := s.match(id)
+	if _, err := m.addPlayer(id, "marco", "marco", "", s.sb); err != nil {
+		t.Fatalf("Couldn't seat marco: %v", err)
+	}
+	m.Lock()
+	for !m.state.Ended() {
+		if m.state.NextPlayer != "marco" {
+			t.Fatalf("Expected it to be marco's turn, not %s's", m.state.NextPlayer)
+		}
+		playMove(t, m, s.sb)
+	}
+	p1, p2 := m.state.Players[0], m.state.Players[1]
+	points, botPoints := len(p1.Awards)+p1.Scopas, len(p2.Awards)+p2.Scopas
+	m.Unlock()
+	if s.match(id) == m {
+		t.Errorf("Expected the table to be taken down after the game.")
+	}
+	if _, code := start("marco"); code != 400 {
+		t.Errorf("Expected marco to only get one go, got %d", code)
+	}
+
+	var v dailyView
+	if code := do("GET", "/daily", "", &v); code != 200 || len(v.Leaderboard) != 1 {
+		t.Fatalf("Expected marco on the leaderboard, got %d %+v", code, v)
+	}
+	if e := v.Leaderboard[0]; e.Nickname != "marco" || e.Points != points || e.BotPoints != botPoints {
+		t.Errorf("Expected marco to have %d to %d, got %+v", points, botPoints, e)
+	}
+	if v.Seed != 0 || v.Best != nil {
+		t.Errorf("Expected today's deal to be kept secret, got %+v", v)
+	}
+	var archived []archivedMatch
+	w := httptest.NewRecorder()
+	s.archived(w, httptest.NewRequest("GET", "/archive", nil))
+	if json.Unmarshal(w.Body.Bytes(), &archived); len(archived) != 0 {
+		t.Errorf("Expected today's deal to be kept out of the archive, got %+v", archived)
+	}
+
+	// Once the day is over the best run can be replayed, and there's a new deal to play.
+	clock.Advance(12 * time.Hour)
+	if code := do("GET", "/daily/2020-06-28", "", &v); code != 200 || v.Best == nil {
+		t.Fatalf("Expected yesterday's best run, got %d %+v", code, v)
+	}
+	var next dailyView
+	if code := do("GET", "/daily", "", &next); code != 200 || next.Date != "2020-06-29" || next.Players != 0 {
+		t.Errorf("Expected nobody to have played the 29th yet, got %d %+v", code, next)
+	}
+	if _, code := start("marco"); code != 200 {
+		t.Errorf("Expected marco to get a go the next day, got %d", code)
+	}
+	am := archivedMatch{Seed: v.Seed, Players: []archivedPlayer{{Nickname: "marco"}, {Nickname: botName}}, Moves: v.Best.Moves}
+	g, err := am.replay()
+	if err != nil || !g.Ended() || len(g.Players[0].Awards)+g.Players[0].Scopas != points {
+		t.Errorf("Expected the best run to replay to %d points: %v %+v", points, err, g.Players[0])
+	}
+	if code := do("GET", "/daily/tomorrow", "", nil); code != 404 {
+		t.Errorf("Expected a bad date to be a 404, got %d", code)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/sbadame/scopa/protocol"
+)
+
+// snapshotEvery is how many patches are sent in a row before a full state, so that a client can't drift forever.
+const snapshotEvery = 20
+
+// deltas sends the states of one client as patches against the previous state, for protocol version 2 and up.
+// The zero value sends the next state in full.
+type deltas struct {
+	base    int    // Seq of the last state sent, 0 when the next one has to be sent in full.
+	last    []byte // Its JSON.
+	patches int    // Patches sent since the last full state.
+}
+
+// encode sets either State, or Base and Patch, of m to s.
+func (d *deltas) encode(m *protocol.ServerMessage, s *protocol.State) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	if d.base != 0 && d.patches < snapshotEvery {
+		ops, err := protocol.Diff(d.last, b)
+		if err != nil {
+			return err
+		}
+		// Patches that touch most of the state are no cheaper to send.
+		if p, err := json.Marshal(ops); err == nil && len(p) < len(b) {
+			m.Base, m.Patch = d.base, ops
+			d.base, d.last = m.Seq, b
+			d.patches++
+			return nil
+		}
+	}
+
+	m.State = s
+	d.base, d.last, d.patches = m.Seq, b, 0
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"github.com/sbadame/scopa/protocol"
+	"time"
+)
+
+// abortMoves is how many moves into a game it can still be aborted, that's the first hand.
+const abortMoves = 6
+
+// propose offers to end the game early for the player holding token, kind is protocol.AbortCommand or
+// protocol.DrawCommand. The game ends once the other player proposes the same. Callers must hold the lock.
+func (m *Match) propose(token, kind string, sb *scoreboard) error {
+	p := m.seat(token)
+	if p == nil {
+		return matchErrorf(403, "You don't have a seat in this match.")
+	}
+	if len(m.players) < 2 || m.state.Ended() {
+		return matchErrorf(400, "There's no game being played.")
+	}
+	if m.onEnd != nil {
+		// Like tournament rounds and daily challenges.
+		return matchErrorf(400, "This game has to be played out, or resigned.")
+	}
+	if kind == protocol.AbortCommand && len(m.moves) >= abortMoves {
+		return matchErrorf(400, "It's too late to abort, the first hand has been played.")
+	}
+
+	r := m.proposal
+	switch {
+	case r == nil || r.Type != kind:
+		m.proposal = &protocol.Proposal{Type: kind, From: p.nick}
+		m.appendEvent(event{proposal: m.proposal})
+		return nil
+	case r.From == p.nick:
+		return matchErrorf(400, "You've already proposed it, wait for %s to answer.", m.opponent(p.nick))
+	}
+
+	ending := protocol.Aborted
+	if kind == protocol.DrawCommand {
+		ending = protocol.Drawn
+	}
+	if err := m.state.Stop(ending); err != nil {
+		return err
+	}
+	m.ending = ending
+	m.logs = append(m.logs, fmt.Sprintf("%s: %s and %s\n", ending, r.From, p.nick))
+	m.played(archivedMove{Player: p.nick, Stop: ending})
+	m.endTurn(sb)
+	return nil
+}
+
+// opponent returns the nickname of the player that nick is playing. Callers must hold the lock.
+func (m *Match) opponent(nick string) string {
+	for _, p := range m.players {
+		if p.nick != nick {
+			return p.nick
+		}
+	}
+	return ""
+}
+
+// disconnected tells the other player that p's connection to the match just went away, and gives p abandonAfter
+// to come back before they forfeit the game. Callers must hold the lock.
+func (m *Match) disconnected(p player, sb *scoreboard) {
+	seat := m.seat(p.token)
+	if seat == nil || seat.conn != p.conn {
+		return // They're back already, or the match was reset.
+	}
+	m.setPresence(p.nick, protocol.Disconnected)
+	if len(m.players) < 2 || m.state.Ended() {
+		return // Nothing to wait for.
+	}
+	switch {
+	case m.substituteAfter > 0 && m.bot == "":
+		// Games against the bot are the player's own to finish.
+		m.ifGone(p, m.substituteAfter, func(nick string) { m.substitute(nick, sb) })
+	case m.abandonAfter > 0:
+		m.ifGone(p, m.abandonAfter, func(nick string) { m.abandon(nick, sb) })
+	}
+}
+
+// ifGone calls f with p's nickname and the lock held if p still hasn't come back to the game after d. Callers must
+// hold the lock.
+func (m *Match) ifGone(p player, d time.Duration, f func(nick string)) {
+	id, started := m.ID, m.started
+	m.afterFunc(d, func() {
+		m.Lock()
+		defer m.Unlock()
+		// Reconnecting, new games and resets all win.
+		seat := m.seat(p.token)
+		if m.ID != id || !m.started.Equal(started) || seat == nil || seat.conn != p.conn || m.state.Ended() {
+			return
+		}
+		f(seat.nick)
+	})
+}
+
+// substitute has the bot play for nick, who disconnected, until they come back. Callers must hold the lock.
+func (m *Match) substitute(nick string, sb *scoreboard) {
+	if m.substitutes == nil {
+		m.substitutes = make(map[string]bool)
+	}
+	m.substitutes[nick] = true
+	m.logs = append(m.logs, fmt.Sprintf("substitute: %s\n", nick))
+	m.setPresence(nick, protocol.Substituted)
+	m.moveBot(sb)
+}
+
+// abandon forfeits the game for nick, who disconnected and didn't come back. Callers must hold the lock.
+func (m *Match) abandon(nick string, sb *scoreboard) {
+	if err := m.state.Forfeit(nick); err != nil {
+		m.logs = append(m.logs, fmt.Sprintf("FAIL abandon: %s, %#v\n", nick, err))
+		return
+	}
+	m.ending = protocol.Abandoned
+	m.logs = append(m.logs, fmt.Sprintf("abandoned: %s\n", nick))
+	m.played(archivedMove{Player: nick, Forfeit: true})
+	m.endTurn(sb)
+}
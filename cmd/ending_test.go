@@ -0,0 +1,169 @@
+package main
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"github.com/sbadame/scopa/protocol"
+	"github.com/sbadame/scopa/scopa"
+	"github.com/sbadame/scopa/scopa/bot"
+	"testing"
+	"time"
+)
+
+// playMove makes the medium bot's move for whoever's turn it is.
+func playMove(t *testing.T, m *Match, sb *scoreboard) {
+	for _, p := range m.players {
+		if p.nick != m.state.NextPlayer {
+			continue
+		}
+		mv := bot.New(bot.Medium, 0).Move(m.state)
+		var err error
+		if len(mv.Table) > 0 {
+			err = m.take(p.token, mv.Card, mv.Table, sb)
+		} else {
+			err = m.drop(p.token, mv.Card, sb)
+		}
+		if err != nil {
+			t.Fatalf("Couldn't move: %v", err)
+		}
+		return
+	}
+}
+
+func TestAbort(t *testing.T) {
+	m, _, sb := clockedMatch(t, "")
+	m.archive = tempArchive(t)
+	a, b := m.players[0], m.players[1]
+
+	if err := m.propose(a.token, protocol.AbortCommand, sb); err != nil {
+		t.Fatalf("Couldn't propose an abort: %v", err)
+	}
+	if err := m.propose(a.token, protocol.AbortCommand, sb); err == nil {
+		t.Errorf("Expected players to be kept from accepting their own proposal.")
+	}
+	playMove(t, m, sb)
+	if m.proposal != nil {
+		t.Errorf("Expected the move to withdraw the proposal.")
+	}
+
+	if err := m.propose(a.token, protocol.AbortCommand, sb); err != nil {
+		t.Fatalf("Couldn't propose an abort: %v", err)
+	}
+	if err := m.propose(b.token, protocol.AbortCommand, sb); err != nil {
+		t.Fatalf("Couldn't agree to abort: %v", err)
+	}
+	if !m.state.Ended() || m.ending != protocol.Aborted {
+		t.Fatalf("Expected the game to be aborted, got %q", m.ending)
+	}
+
+	// Aborted games don't count, but they're remembered.
+	if d := cmp.Diff(map[string]int{"a": 0, "b": 0}, sb.scores("a", "b")); d != "" {
+		t.Errorf("scores mismatch (-want +got):\n%s", d)
+	}
+	if got := sb.cards[scorekey("a", "b")].Endings[protocol.Aborted]; got != 1 {
+		t.Errorf("Expected 1 aborted game on the scorecard, got %d", got)
+	}
+	am := m.archive.matches[0]
+	if am.Ending != protocol.Aborted {
+		t.Errorf("Expected the archive to have the game as aborted, got %q", am.Ending)
+	}
+	if g, err := am.replay(); err != nil || g.Stopped != protocol.Aborted {
+		t.Errorf("Couldn't replay the aborted game: %v %q", err, g.Stopped)
+	}
+}
+
+func TestTooLateToAbort(t *testing.T) {
+	m, _, sb := clockedMatch(t, "")
+	for i := 0; i < abortMoves; i++ {
+		playMove(t, m, sb)
+	}
+	if err := m.propose(m.players[0].token, protocol.AbortCommand, sb); err == nil {
+		t.Errorf("Expected aborting after the first hand to fail.")
+	}
+
+	// Drawing is still fine, and nobody scores.
+	for _, p := range m.players {
+		if err := m.propose(p.token, protocol.DrawCommand, sb); err != nil {
+			t.Fatalf("Couldn't draw: %v", err)
+		}
+	}
+	if m.ending != protocol.Drawn || m.outcome() != 0.5 {
+		t.Errorf("Expected a draw, got %q with outcome %v", m.ending, m.outcome())
+	}
+	if d := cmp.Diff(map[string]int{"a": 0, "b": 0}, sb.scores("a", "b")); d != "" {
+		t.Errorf("scores mismatch (-want +got):\n%s", d)
+	}
+	if err := m.propose(m.players[0].token, protocol.DrawCommand, sb); err == nil {
+		t.Errorf("Expected proposals after the game to fail.")
+	}
+}
+
+func TestAbandon(t *testing.T) {
+	m, c, sb := clockedMatch(t, "")
+	m.abandonAfter = time.Minute
+	m.forfeitPoints, m.state.ForfeitPoints = scopa.ForfeitScopas, scopa.ForfeitScopas
+	a, b := m.players[0], m.players[1]
+
+	// Coming back in time keeps the game going.
+	m.disconnected(a, sb)
+	c.Advance(30 * time.Second)
+	back, err := m.addPlayer(m.ID, a.id, a.nick, a.token, sb)
+	if err != nil {
+		t.Fatalf("Couldn't reconnect: %v", err)
+	}
+	c.Advance(time.Minute)
+	if m.state.Ended() {
+		t.Fatalf("Expected the game to wait for a player that came back.")
+	}
+
+	m.disconnected(back, sb)
+	m.disconnected(b, sb)
+	c.Advance(time.Minute)
+	if m.ending != protocol.Abandoned || m.state.Forfeited != a.nick {
+		t.Errorf("Expected %s to abandon the game, got %q by %q", a.nick, m.ending, m.state.Forfeited)
+	}
+	if m.state.Players[1].Awards != nil {
+		t.Errorf("Expected nobody to get awards, got %v", m.state.Players[1].Awards)
+	}
+	if got := sb.cards[scorekey("a", "b")].Endings[protocol.Abandoned]; got != 1 {
+		t.Errorf("Expected 1 abandoned game on the scorecard, got %d", got)
+	}
+}
+
+func TestSubstitute(t *testing.T) {
+	m, c, sb := clockedMatch(t, "")
+	m.substituteAfter = time.Minute
+	m.ratings, m.archive = tempRatings(t, sb), tempArchive(t)
+	a, b := m.players[0], m.players[1] // a moves first.
+
+	m.disconnected(a, sb)
+	c.Advance(time.Minute)
+	if m.presence[a.nick] != protocol.Substituted || len(m.moves) != 1 || !m.moves[0].Substitute {
+		t.Fatalf("Expected the bot to move for %s, got %v and %+v", a.nick, m.presence, m.moves)
+	}
+	if m.state.NextPlayer != b.nick {
+		t.Fatalf("Expected it to be %s's turn, got %s", b.nick, m.state.NextPlayer)
+	}
+	playMove(t, m, sb)
+	if len(m.moves) != 3 || !m.moves[2].Substitute || m.moves[1].Substitute {
+		t.Errorf("Expected the bot to answer %s's move, got %+v", b.nick, m.moves)
+	}
+
+	// Coming back takes the seat back.
+	if _, err := m.addPlayer(m.ID, a.id, a.nick, a.token, sb); err != nil {
+		t.Fatalf("Couldn't reconnect: %v", err)
+	}
+	playMove(t, m, sb)
+	if len(m.moves) != 4 || m.state.NextPlayer != a.nick {
+		t.Errorf("Expected %s to be left to play, got %d moves", a.nick, len(m.moves))
+	}
+
+	for !m.state.Ended() {
+		playMove(t, m, sb)
+	}
+	if r := m.ratings.view(a.id); r != nil {
+		t.Errorf("Expected a game that the bot played in not to be rated, got %+v", r)
+	}
+	if am := m.archive.matches[0]; !am.Substituted {
+		t.Errorf("Expected the archive to show the substitution.")
+	}
+}
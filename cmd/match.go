@@ -1,16 +1,21 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"github.com/sbadame/scopa/protocol"
 	"github.com/sbadame/scopa/scopa"
+	"github.com/sbadame/scopa/scopa/bot"
+	"github.com/sbadame/scopa/store"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // Match contains all of the state for a server coordinating a scopa match.
@@ -22,40 +27,213 @@ type Match struct {
 	logs      []string
 	gameStart chan struct{} // Channel is closed when the game has started.
 	players   []player
+	events    []event // Every update sent to clients, so that reconnecting clients can catch up.
+	seed      int64   // That the deck was shuffled with.
+	started   time.Time
+	moves     []archivedMove
+
+	// Time controls, the zero values mean that there's no clock.
+	clock          clock // nil means the real time.
+	control        timeControl
+	defaultControl timeControl              // That control goes back to on Reset, rematches can agree on another.
+	remaining      map[string]time.Duration // Time left by nickname for the "total" mode.
+	turns          int                      // Identifies the turn that timer belongs to.
+	turnPlayer     string                   // Whose clock is running.
+	turnStart      time.Time
+	deadline       time.Time
+	timer          stopper
+
+	chatLimits map[string]*rateLimit // By nickname.
+
+	reserved []string     // IDs of the only players that can sit, anyone can when it's empty.
+	seats    []string     // IDs in the order they play in, the scoreboard picks who goes first when it's empty.
+	dealSeed int64        // Every game is dealt from this seed, a new seed is drawn for each game when it's 0.
+	bot      string       // Seat token of the player that the server moves for, "" when there's none.
+	botLevel bot.Level    // That the bot plays at, for the players it substitutes too.
+	onEnd    func(*Match) // Called with the lock held when a game ends, nil for nothing.
+	onOver   func(*Match) // Called with the lock held when the match is over, see over, nil for nothing.
+	onSeat   func(string) // Called with the lock held with the seat token of every player that sits, nil for nothing.
+
+	searches    sync.WaitGroup // The Hard bot's searches for its move, which run without the lock.
+	searchTurns int            // Identifies the search that the bot's move has to come from.
+
+	variant      string            // Of the games dealt, see scopa.Game.Variant.
+	rematch      *protocol.Rematch // Where the rematch stands once the game is over, nil until anyone offers one.
+	rematchTimer stopper
+	rematchTurns int // Identifies the offer that rematchTimer belongs to.
+
+	forfeitPoints   string             // Of the games dealt, see scopa.Game.ForfeitPoints.
+	abandonAfter    time.Duration      // How long a game waits for a player that disconnected, 0 waits forever.
+	substituteAfter time.Duration      // How long before the bot plays for a player that disconnected, 0 never.
+	substitutes     map[string]bool    // Nicknames of the disconnected players that the bot is playing for.
+	substituted     bool               // Whether the bot has moved for anyone in this game, which isn't rated then.
+	ending          string             // How the game ended early, see protocol.State.Ending.
+	proposal        *protocol.Proposal // To end the game early, nil when there's none.
+
+	presence      map[string]string // By nickname, see protocol.ServerMessage.Presence.
+	presenceTurns int               // Changes with every change to presence.
+
+	ratings *ratings // nil when ratings aren't kept.
+	stats   *stats   // nil when stats aren't kept.
+	archive *archive // nil when matches aren't archived.
 }
 
-// Reset zereos out all of the fields and sets a new match ID.
+// event is an update that is pushed to every client. Seq starts at 1 and increases by 1 with every event.
+// Events carry the game state, a chat message, a proposal to end the game early, or where the rematch stands.
+type event struct {
+	Seq   int
+	state map[string]*protocol.State // By nickname.
+	clock *protocol.Clock
+	chat  *protocol.Chat
+
+	rematch   *protocol.Rematch
+	proposal  *protocol.Proposal
+	nicknames map[int]string // With the state of a rematch, which can change seats.
+	scorecard map[string]int
+}
+
+// connection is the link between a player's seat and the goroutine streaming updates to their socket.
+type connection struct {
+	updates chan struct{} // Signalled when there are new events to send.
+	done    chan struct{} // Closed when the connection has been replaced or the match reset.
+	resync  chan struct{} // Signalled when the client needs the state in full.
+}
+
+func newConnection() *connection {
+	return &connection{make(chan struct{}, 1), make(chan struct{}), make(chan struct{}, 1)}
+}
+
+// notify wakes up the connection without ever blocking, a pending notification already covers new events.
+func (c *connection) notify() {
+	select {
+	case c.updates <- struct{}{}:
+	default:
+	}
+}
+
+// requestResync asks the connection to send the state in full, without ever blocking.
+func (c *connection) requestResync() {
+	select {
+	case c.resync <- struct{}{}:
+	default:
+	}
+}
+
+// Reset zereos out all of the fields and sets a new match ID. The clock, default time control, forfeit rules, ratings,
+// stats and archive are kept.
+// Callers must hold the lock.
 func (m *Match) Reset(id int64) {
-	*m = Match{ID: id}
+	for _, p := range m.players {
+		close(p.conn.done)
+	}
+	m.stopClock()
+	m.remaining = nil
+	m.turns = 0
+	m.turnPlayer = ""
+	m.state = scopa.Game{}
+	m.ID = id
+	m.logs = nil
+	m.gameStart = nil
+	m.players = nil
+	m.events = nil
+	m.seed = 0
+	m.started = time.Time{}
+	m.moves = nil
+	m.chatLimits = nil
+	m.bot = ""
+	m.botLevel = 0
+	m.variant = ""
+	m.stopRematchTimer()
+	m.rematch = nil
+	m.ending = ""
+	m.proposal = nil
+	m.presence = nil
+	m.substitutes = nil
+	m.substituted = false
+	m.control = m.defaultControl
+}
+
+// newToken returns an unguessable hex string that identifies a seat in a match.
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// seat returns the player holding the given seat token, or nil if nobody does.
+func (m *Match) seat(token string) *player {
+	if token == "" {
+		return nil
+	}
+	for i, p := range m.players {
+		if p.token == token {
+			return &m.players[i]
+		}
+	}
+	return nil
 }
 
-func (m *Match) addPlayer(matchID int64, nick string, sb scoreboard) (chan struct{}, error) {
+// addPlayer seats nick in the match, or reconnects the player holding token if it belongs to this match.
+// id is the account ID for registered players and the nickname for guests, it's what scores are kept under.
+func (m *Match) addPlayer(matchID int64, id, nick, token string, sb *scoreboard) (player, error) {
 	m.Lock()
 	defer m.Unlock()
 
 	if matchID == m.ID {
-		for _, p := range m.players {
-			if p.nick == nick {
-				p.client = make(chan struct{}, 1000)
-				return p.client, nil
-			}
+		if p := m.seat(token); p != nil {
+			// Replace the old connection, which tears down the goroutine that was streaming to it.
+			close(p.conn.done)
+			p.conn = newConnection()
+			delete(m.substitutes, p.nick) // They're back, so they play for themselves again.
+			return *p, nil
 		}
 	}
 
+	if token != "" && matchID != 0 && matchID != m.ID {
+		// Don't sit a player from a match that's over down in a new one, they have to ask for it.
+		return player{}, fmt.Errorf("match %d is over, join again without its seat token to play", matchID)
+	}
+	if m.over() {
+		// Neither player wants to play again, so whoever joins next gets a new match.
+		next := m.now().Unix()
+		if next <= m.ID {
+			next = m.ID + 1
+		}
+		m.Reset(next)
+	}
+
 	// Keep track of the number of players that have "joined".
 	// Give them a player id.
 	if len(m.players) >= 2 {
-		return nil, fmt.Errorf("match is full")
+		return player{}, fmt.Errorf("match is full")
+	}
+	if len(m.reserved) > 0 {
+		ok := false
+		for _, r := range m.reserved {
+			ok = ok || r == id
+		}
+		if !ok {
+			return player{}, fmt.Errorf("the seats in this match are reserved for other players")
+		}
 	}
 
 	for _, p := range m.players {
-		if p.nick == nick {
-			return nil, fmt.Errorf("nickname %s is already taken", nick)
+		if p.nick == nick || p.id == id {
+			return player{}, fmt.Errorf("nickname %s is already taken", nick)
 		}
 	}
 
-	updateChan := make(chan struct{}, 1000)
-	m.players = append(m.players, player{updateChan, nick})
+	token, err := newToken()
+	if err != nil {
+		return player{}, fmt.Errorf("couldn't create a seat token: %v", err)
+	}
+	p := player{newConnection(), id, nick, token}
+	m.players = append(m.players, p)
+	if m.onSeat != nil {
+		m.onSeat(token)
+	}
 
 	if m.gameStart == nil {
 		m.gameStart = make(chan struct{}, 0)
@@ -64,104 +242,303 @@ func (m *Match) addPlayer(matchID int64, nick string, sb scoreboard) (chan struc
 	if len(m.players) == 2 {
 		// Now that we have all of the players, check if these two have played before, and if yes, who goes
 		// first?
-		n := sb.nextPlayer(m.players[0].nick, m.players[1].nick)
-		if m.players[0].nick != n {
-			m.players[0], m.players[1] = m.players[1], m.players[0]
+		m.deal(sb, false)
+		m.publish()
+		close(m.gameStart) // Broadcast that the game is ready to start to all clients.
+		m.moveBot(sb)
+	}
+	return p, nil
+}
+
+// deal starts a new game between the seated players. The scoreboard, or the seats when they're fixed, picks who
+// moves first, and swap gives the first move to the other player instead. Callers must hold the lock.
+func (m *Match) deal(sb *scoreboard, swap bool) {
+	n := sb.nextPlayer(m.players[0].id, m.players[1].id)
+	if len(m.seats) > 0 {
+		n = m.seats[0]
+	}
+	if m.players[0].id != n != swap {
+		m.players[0], m.players[1] = m.players[1], m.players[0]
+	}
+
+	names := make([]string, 0)
+	for _, p := range m.players {
+		names = append(names, p.nick)
+	}
+	m.seed, m.started, m.moves = m.dealSeed, m.now(), nil
+	if m.seed == 0 {
+		m.seed = newSeed()
+	}
+	m.state = scopa.NewSeededGame(names, m.seed)
+	m.state.Variant, m.state.ForfeitPoints = m.variant, m.forfeitPoints
+	m.ending, m.proposal, m.substituted = "", nil, false
+	m.startClock(sb)
+}
+
+// lineup returns the nicknames of the players by seat, starting at 1, and their points against eachother by
+// nickname. Callers must hold the lock, and the game must have started.
+func (m *Match) lineup(sb *scoreboard) (map[int]string, map[string]int) {
+	nicks, scorecard := make(map[int]string), make(map[string]int)
+	scores := sb.scores(m.players[0].id, m.players[1].id)
+	for i, p := range m.players {
+		nicks[i+1] = p.nick
+		if v, ok := scores[p.id]; ok {
+			scorecard[p.nick] = v
 		}
+	}
+	return nicks, scorecard
+}
 
-		names := make([]string, 0)
-		for _, p := range m.players {
-			names = append(names, p.nick)
+// publish records the current state as a new event and wakes up all of the connections.
+func (m *Match) publish() {
+	m.appendEvent(m.stateEvent())
+}
+
+// stateEvent returns an event with the current state as each player sees it. Callers must hold the lock.
+func (m *Match) stateEvent() event {
+	e := event{state: make(map[string]*protocol.State), clock: m.clockView()}
+	for _, p := range m.players {
+		// JSONForPlayer decides what each player gets to see.
+		b, err := m.state.JSONForPlayer(p.nick)
+		if err != nil {
+			panic(fmt.Sprintf("%s is seated but not a player: %v", p.nick, err))
 		}
-		m.state = scopa.NewGame(names)
-		close(m.gameStart) // Broadcast that the game is ready to start to all clients.
+		var s protocol.State
+		if err := json.Unmarshal(b, &s); err != nil {
+			panic(fmt.Sprintf("JSONForPlayer doesn't match protocol.State: %v", err))
+		}
+		s.Ending = m.ending
+		e.state[p.nick] = &s
+	}
+	return e
+}
+
+// appendEvent numbers the event, and wakes up all of the connections to send it.
+func (m *Match) appendEvent(e event) {
+	e.Seq = len(m.events) + 1
+	m.events = append(m.events, e)
+
+	for _, p := range m.players {
+		p.conn.notify()
 	}
-	return updateChan, nil
+}
+
+// latestState returns the Seq of the most recent event with the game state, or 0 if there isn't one yet.
+// Callers must hold the lock.
+func (m *Match) latestState() int {
+	for i := len(m.events) - 1; i >= 0; i-- {
+		if m.events[i].state != nil {
+			return m.events[i].Seq
+		}
+	}
+	return 0
+}
+
+// eventsSince returns the events after seq, callers must hold the lock.
+func (m *Match) eventsSince(seq int) []event {
+	if seq < 0 {
+		seq = 0
+	}
+	if seq > len(m.events) {
+		return nil
+	}
+	return append([]event(nil), m.events[seq:]...)
 }
 
 func (m *Match) scorecardKey() string {
 	s := make([]string, 0)
 	for _, p := range m.players {
-		s = append(s, p.nick)
+		s = append(s, p.id)
 	}
 	sort.Strings(s)
 	return strings.Join(s, "|")
 }
 
-type scoreboard map[string]*scorecard
+// scoreboard holds the scorecards of every pair of players that have played eachother.
+// Players are identified by their account ID, or their nickname if they played as a guest.
+// Finished games are appended to the store's journal, and the whole scoreboard is saved every snapshotGames.
+type scoreboard struct {
+	sync.Mutex
+	store    store.Store
+	cards    map[string]*scorecard
+	journals int // Games appended to the journal since the scoreboard was last saved.
+}
 
 type scorecard struct {
 	Scores     map[string]int
 	NextPlayer string
+	Partita    map[string]int `json:",omitempty"` // Points in the partita being played, by ID.
+	PartitaTo  int            `json:",omitempty"` // What the partita being played goes to, partitaPoints when it's 0.
+	Endings    map[string]int `json:",omitempty"` // Games that ended early by how, see protocol.State.Ending.
+}
+
+// game is a journal entry, the points of a finished game.
+type game struct {
+	A, B           string // IDs.
+	AScore, BScore int
+	PartitaTo      int    `json:",omitempty"` // Set on entries that extend the partita, instead of recording a game.
+	Ending         string `json:",omitempty"` // How the game ended early, see protocol.State.Ending.
 }
 
-func scorekey(aNick, bNick string) string {
-	n := []string{aNick, bNick}
+// snapshotGames is how many games are journaled before the whole scoreboard is saved.
+const snapshotGames = 100
+
+// scoreboardMigrations bring older scoreboard files up to date, see store.Open.
+var scoreboardMigrations = []store.Migration{store.Unchanged}
+
+// partitaPoints is what a partita is played to, unless the players extend it in a rematch. If both players get
+// there in the same game, the one with more points wins, and it keeps going if they're tied.
+const partitaPoints = 11
+
+func scorekey(aID, bID string) string {
+	n := []string{aID, bID}
 	sort.Strings(n)
 	return strings.Join(n, "|")
 }
 
-func (sb scoreboard) scores(aNick, bNick string) map[string]int {
-	if v := sb[scorekey(aNick, bNick)]; v != nil {
-		return v.Scores
+// scores returns a copy of the points that a and b have won against eachother.
+func (sb *scoreboard) scores(aID, bID string) map[string]int {
+	sb.Lock()
+	defer sb.Unlock()
+	scores := make(map[string]int)
+	if v := sb.cards[scorekey(aID, bID)]; v != nil {
+		for id, n := range v.Scores {
+			scores[id] = n
+		}
 	}
-	return map[string]int{}
+	return scores
 }
 
-func (sb scoreboard) record(aNick, bNick string, aScore, bScore int) {
-	key := scorekey(aNick, bNick)
-	s, ok := sb[key]
+// record adds the points of a game between a and b, and how it ended early if it did, and stores it. If that
+// finishes a partita, the winner's ID is returned.
+func (sb *scoreboard) record(aID, bID string, aScore, bScore int, ending string) string {
+	sb.Lock()
+	defer sb.Unlock()
+	g := game{A: aID, B: bID, AScore: aScore, BScore: bScore, Ending: ending}
+	partita := sb.add(g)
+	sb.journal(g)
+	return partita
+}
+
+// extend plays the partita that a and b are playing to more points.
+func (sb *scoreboard) extend(aID, bID string, to int) {
+	sb.Lock()
+	defer sb.Unlock()
+	g := game{A: aID, B: bID, PartitaTo: to}
+	sb.add(g)
+	sb.journal(g)
+}
+
+// partitaTo returns what the partita that a and b are playing goes to.
+func (sb *scoreboard) partitaTo(aID, bID string) int {
+	sb.Lock()
+	defer sb.Unlock()
+	if v, ok := sb.cards[scorekey(aID, bID)]; ok && v.PartitaTo > 0 {
+		return v.PartitaTo
+	}
+	return partitaPoints
+}
+
+// journal appends g to the store's journal, or saves the whole scoreboard every snapshotGames. Callers must hold
+// the lock.
+func (sb *scoreboard) journal(g game) {
+	if sb.journals++; sb.journals >= snapshotGames {
+		sb.save()
+	} else if err := sb.store.Append(g); err != nil {
+		fmt.Printf("Couldn't journal the game, saving the whole scoreboard instead: %v\n", err)
+		sb.save()
+	}
+}
+
+// add adds the points of a game to the scorecards, callers must hold the lock.
+func (sb *scoreboard) add(g game) string {
+	key := scorekey(g.A, g.B)
+	s, ok := sb.cards[key]
 	if !ok {
 		// First time these two players have played eachother.
 		// b goes first next time.
-		sb[key] = &scorecard{
-			Scores:     map[string]int{aNick: aScore, bNick: bScore},
-			NextPlayer: bNick,
+		s = &scorecard{
+			Scores:     map[string]int{g.A: 0, g.B: 0},
+			NextPlayer: g.A,
 		}
-		return
+		sb.cards[key] = s
+	}
+	if g.PartitaTo > 0 {
+		s.PartitaTo = g.PartitaTo
+		return ""
+	}
+	if g.Ending != "" {
+		if s.Endings == nil {
+			s.Endings = make(map[string]int)
+		}
+		s.Endings[g.Ending]++
+	}
+	if g.Ending == protocol.Aborted {
+		return "" // The game doesn't count.
 	}
 
-	s.Scores[aNick] += aScore
-	s.Scores[bNick] += bScore
+	s.Scores[g.A] += g.AScore
+	s.Scores[g.B] += g.BScore
 
 	// Match has been recorded, swap the next player...
-	if s.NextPlayer == aNick {
-		s.NextPlayer = bNick
+	if s.NextPlayer == g.A {
+		s.NextPlayer = g.B
 	} else {
-		s.NextPlayer = aNick
+		s.NextPlayer = g.A
+	}
+
+	if s.Partita == nil {
+		s.Partita = make(map[string]int)
 	}
+	s.Partita[g.A] += g.AScore
+	s.Partita[g.B] += g.BScore
+	a, b := s.Partita[g.A], s.Partita[g.B]
+	to := s.PartitaTo
+	if to == 0 {
+		to = partitaPoints
+	}
+	if (a < to && b < to) || a == b {
+		return ""
+	}
+	s.Partita, s.PartitaTo = nil, 0
+	if a > b {
+		return g.A
+	}
+	return g.B
 }
 
-func (sb scoreboard) nextPlayer(aNick, bNick string) string {
-	if v, ok := sb[scorekey(aNick, bNick)]; ok {
+func (sb *scoreboard) nextPlayer(aID, bID string) string {
+	sb.Lock()
+	defer sb.Unlock()
+	if v, ok := sb.cards[scorekey(aID, bID)]; ok {
 		return v.NextPlayer
 	}
-	return aNick
+	return aID
 }
 
-func (sb scoreboard) save(filename string) {
-	b, err := json.Marshal(sb)
-	if err != nil {
-		fmt.Printf("Couldn't convert scoreboard to json: %v\n", err)
+// save writes the whole scoreboard to disk, callers must hold the lock.
+func (sb *scoreboard) save() {
+	if err := sb.store.Save(sb.cards); err != nil {
+		fmt.Printf("Couldn't save the scoreboard: %v\n", err)
 		return
 	}
-
-	if err := ioutil.WriteFile(filename, b, 0644); err != nil {
-		fmt.Printf("Couldn't write to %s: %v\n", filename, err)
-	}
+	sb.journals = 0
 }
 
-func loadScoreboard(f string) scoreboard {
-	sb := make(scoreboard)
+func loadScoreboard(st store.Store) *scoreboard {
+	sb := &scoreboard{store: st, cards: make(map[string]*scorecard)}
 
-	b, err := ioutil.ReadFile(f)
+	err := st.Load(&sb.cards, func(e json.RawMessage) error {
+		var g game
+		if err := json.Unmarshal(e, &g); err != nil {
+			return err
+		}
+		sb.add(g)
+		sb.journals++
+		return nil
+	})
 	if err != nil {
-		fmt.Printf("Couldn't read %s, %v\n", f, err)
-		return sb
-	}
-
-	if err := json.Unmarshal(b, &sb); err != nil {
-		fmt.Printf("Couldn't parse json from %s, %v\n", f, err)
+		fmt.Printf("Couldn't read the scoreboard, %v\n", err)
 	}
 	return sb
 }
@@ -189,20 +566,132 @@ func parseRequestJSON(w http.ResponseWriter, r *http.Request, v interface{}) boo
 	return true
 }
 
-func (m *Match) endTurn(sb scoreboard) {
+// matchError is an error caused by a player's request, Code is the matching HTTP status.
+type matchError struct {
+	Code    int
+	Message string
+}
+
+func (e *matchError) Error() string {
+	return e.Message
+}
+
+func matchErrorf(code int, format string, a ...interface{}) error {
+	return &matchError{code, fmt.Sprintf(format, a...)}
+}
+
+// statusCode is the HTTP status to report err with.
+func statusCode(err error) int {
+	switch e := err.(type) {
+	case *matchError:
+		return e.Code
+	case *scopa.MoveError:
+		return 400
+	default:
+		return 500
+	}
+}
+
+// mover returns the player holding token if it's their turn. Callers must hold the lock.
+func (m *Match) mover(token string) (*player, error) {
+	p := m.seat(token)
+	if p == nil {
+		return nil, matchErrorf(403, "You don't have a seat in this match.")
+	}
+	if p.nick != m.state.NextPlayer {
+		return nil, matchErrorf(400, "Not your turn!")
+	}
+	return p, nil
+}
+
+// drop plays card from the hand of the player holding token. Callers must hold the lock.
+func (m *Match) drop(token string, card scopa.Card, sb *scoreboard) error {
+	p, err := m.mover(token)
+	if err != nil {
+		return err
+	}
+
+	m.logs = append(m.logs, fmt.Sprintf("state: %#v\n", m.state))
+	if err := m.state.Drop(card); err != nil {
+		m.logs = append(m.logs, fmt.Sprintf("FAIL drop: %#v, %#v\n", card, err))
+		return err
+	}
+	m.logs = append(m.logs, fmt.Sprintf("drop: %#v\n", card))
+	m.played(archivedMove{Player: p.nick, Drop: &card})
+	m.endTurn(sb)
+	return nil
+}
+
+// take captures table with card for the player holding token. Callers must hold the lock.
+func (m *Match) take(token string, card scopa.Card, table []scopa.Card, sb *scoreboard) error {
+	p, err := m.mover(token)
+	if err != nil {
+		return err
+	}
+
+	m.logs = append(m.logs, fmt.Sprintf("state: %#v\n", m.state))
+	if err := m.state.Take(card, table); err != nil {
+		m.logs = append(m.logs, fmt.Sprintf("FAIL take: %#v, %#v, %#v\n", card, table, err))
+		m.logs = append(m.logs, fmt.Sprintf("state: %#v\n", m.state))
+		return err
+	}
+	m.logs = append(m.logs, fmt.Sprintf("take: %#v, %#v\n", card, table))
+	m.played(archivedMove{Player: p.nick, Take: &card, Table: table})
+	m.endTurn(sb)
+	return nil
+}
+
+// resign forfeits the game for the player holding token, whether or not it's their turn.
+// Callers must hold the lock.
+func (m *Match) resign(token string, sb *scoreboard) error {
+	p := m.seat(token)
+	if p == nil {
+		return matchErrorf(403, "You don't have a seat in this match.")
+	}
+	if len(m.players) < 2 {
+		return matchErrorf(400, "The game hasn't started yet.")
+	}
+
+	if err := m.state.Forfeit(p.nick); err != nil {
+		return err
+	}
+	m.ending = protocol.Resigned
+	m.logs = append(m.logs, fmt.Sprintf("resign: %s\n", p.nick))
+	m.played(archivedMove{Player: p.nick, Forfeit: true})
+	m.endTurn(sb)
+	return nil
+}
+
+func (m *Match) endTurn(sb *scoreboard) {
 	m.logs = append(m.logs, fmt.Sprintf("state: %#v\n", m.state))
 
 	if m.state.Ended() {
 		// Record the scores.
 		p1, p2 := m.state.Players[0], m.state.Players[1]
 		a1, a2 := len(p1.Awards)+p1.Scopas, len(p2.Awards)+p2.Scopas
-		n1, n2 := m.players[0].nick, m.players[1].nick
-		sb.record(n1, n2, a1, a2)
+		if m.state.Stopped != "" {
+			a1, a2 = 0, 0 // Nobody scores in games that were stopped.
+		}
+		partita := sb.record(m.players[0].id, m.players[1].id, a1, a2, m.ending)
+		// Aborted games only count towards the history, and games that the bot played in aren't rated.
+		if m.ratings != nil && m.ending != protocol.Aborted && !m.substituted {
+			m.ratings.record(m.players[0].id, m.players[0].nick, m.players[1].id, m.players[1].nick, m.outcome(), m.now())
+		}
+		if m.stats != nil && m.ending != protocol.Aborted {
+			m.stats.record(m, partita)
+		}
+		if m.archive != nil {
+			m.archive.record(m)
+		}
+		if m.onEnd != nil {
+			m.onEnd(m)
+		}
+		m.timeRematch()
 	}
+	m.proposal = nil // Moves withdraw it.
 
 	// Update all of the clients, that there is some new state.
-	for _, p := range m.players {
-		var s struct{}
-		p.client <- s
-	}
+	m.startClock(sb)
+	m.publish()
+	m.moveBot(sb)
 }
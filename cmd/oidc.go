@@ -0,0 +1,353 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long a user has to finish logging in with the OIDC provider.
+const oidcLoginTimeout = 10 * time.Minute
+
+// How long to wait before fetching the provider's signing keys again, when a token is signed with one we don't know.
+const jwksRefreshInterval = time.Minute
+
+// oidcProvider implements the OpenID Connect authorization code flow against a single issuer.
+type oidcProvider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	client       *http.Client
+
+	// Discovered from the issuer's /.well-known/openid-configuration.
+	authURL  string
+	tokenURL string
+	jwksURL  string
+
+	sync.Mutex
+	keys    map[string]*rsa.PublicKey // Signing keys by key id.
+	fetched time.Time                 // When the signing keys were last fetched, or started to be.
+	pending map[string]oidcLogin      // Logins in progress by state.
+}
+
+type oidcLogin struct {
+	nonce   string
+	expires time.Time
+}
+
+// idClaims are the parts of the ID token that we care about.
+type idClaims struct {
+	Issuer            string      `json:"iss"`
+	Subject           string      `json:"sub"`
+	Audience          interface{} `json:"aud"` // Either a string or a list of strings.
+	Expires           int64       `json:"exp"`
+	Nonce             string      `json:"nonce"`
+	PreferredUsername string      `json:"preferred_username"`
+	Email             string      `json:"email"`
+}
+
+func getJSON(c *http.Client, u string, v interface{}) error {
+	r, err := c.Get(u)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != 200 {
+		return fmt.Errorf("GET %s: %s", u, r.Status)
+	}
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+// newOIDCProvider looks up the issuer's endpoints with OpenID Connect discovery.
+func newOIDCProvider(c *http.Client, issuer, clientID, clientSecret, redirectURL string) (*oidcProvider, error) {
+	var d struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err := getJSON(c, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %v", err)
+	}
+	if d.Issuer != issuer {
+		return nil, fmt.Errorf("OIDC discovery returned issuer %q, expected %q", d.Issuer, issuer)
+	}
+
+	return &oidcProvider{
+		issuer:       issuer,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		client:       c,
+		authURL:      d.AuthorizationEndpoint,
+		tokenURL:     d.TokenEndpoint,
+		jwksURL:      d.JWKSURI,
+		keys:         make(map[string]*rsa.PublicKey),
+		pending:      make(map[string]oidcLogin),
+	}, nil
+}
+
+// loginURL starts a new login and returns where to send the user.
+func (o *oidcProvider) loginURL() (string, error) {
+	state, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	o.Lock()
+	for s, l := range o.pending {
+		if time.Now().After(l.expires) {
+			delete(o.pending, s)
+		}
+	}
+	o.pending[state] = oidcLogin{nonce, time.Now().Add(oidcLoginTimeout)}
+	o.Unlock()
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", o.clientID)
+	v.Set("redirect_uri", o.redirectURL)
+	v.Set("scope", "openid profile email")
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	sep := "?"
+	if strings.Contains(o.authURL, "?") {
+		sep = "&"
+	}
+	return o.authURL + sep + v.Encode(), nil
+}
+
+// exchange redeems the authorization code and returns the verified claims of the user's ID token.
+func (o *oidcProvider) exchange(code, state string) (*idClaims, error) {
+	o.Lock()
+	l, ok := o.pending[state]
+	delete(o.pending, state)
+	o.Unlock()
+	if !ok || time.Now().After(l.expires) {
+		return nil, fmt.Errorf("unknown or expired login, please try again")
+	}
+
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", code)
+	v.Set("redirect_uri", o.redirectURL)
+	req, err := http.NewRequest("POST", o.tokenURL, strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(o.clientID), url.QueryEscape(o.clientSecret))
+
+	r, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != 200 {
+		return nil, fmt.Errorf("token exchange failed: %s", r.Status)
+	}
+	var t struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		return nil, err
+	}
+
+	c, err := o.verify(t.IDToken)
+	if err != nil {
+		return nil, err
+	}
+	if c.Nonce != l.nonce {
+		return nil, fmt.Errorf("ID token nonce doesn't match the login")
+	}
+	return c, nil
+}
+
+// key returns the signing key with the given id, refreshing the key set if we haven't seen it before. The key set is
+// fetched at most once every jwksRefreshInterval, and without holding the lock so that other logins carry on.
+func (o *oidcProvider) key(kid string) (*rsa.PublicKey, error) {
+	o.Lock()
+	k, ok := o.keys[kid]
+	refresh := !ok && time.Since(o.fetched) >= jwksRefreshInterval
+	if refresh {
+		o.fetched = time.Now()
+	}
+	o.Unlock()
+	if ok {
+		return k, nil
+	}
+	if !refresh {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := getJSON(o.client, o.jwksURL, &jwks); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	o.Lock()
+	defer o.Unlock()
+	for id, k := range keys {
+		o.keys[id] = k
+	}
+	if k, ok := o.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// verify checks the signature and claims of an RS256 signed ID token.
+func (o *oidcProvider) verify(token string) (*idClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed ID token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported ID token algorithm %q", header.Alg)
+	}
+
+	k, err := o.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, h[:], sig); err != nil {
+		return nil, fmt.Errorf("bad ID token signature: %v", err)
+	}
+
+	var c idClaims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, err
+	}
+	if c.Issuer != o.issuer {
+		return nil, fmt.Errorf("ID token is from %q, expected %q", c.Issuer, o.issuer)
+	}
+	if !audience(c.Audience, o.clientID) {
+		return nil, fmt.Errorf("ID token isn't meant for us")
+	}
+	if time.Now().After(time.Unix(c.Expires, 0)) {
+		return nil, fmt.Errorf("ID token has expired")
+	}
+	if c.Subject == "" {
+		return nil, fmt.Errorf("ID token has no subject")
+	}
+	return &c, nil
+}
+
+func decodeSegment(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func audience(aud interface{}, clientID string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == clientID
+	case []interface{}:
+		for _, s := range a {
+			if s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *server) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	if s.oidc == nil {
+		w.WriteHeader(404)
+		io.WriteString(w, errorJSON("OIDC login isn't configured."))
+		return
+	}
+	u, err := s.oidc.loginURL()
+	if err != nil {
+		w.WriteHeader(500)
+		io.WriteString(w, errorJSON(err.Error()))
+		return
+	}
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+func (s *server) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	if s.oidc == nil {
+		w.WriteHeader(404)
+		io.WriteString(w, errorJSON("OIDC login isn't configured."))
+		return
+	}
+	if e := r.FormValue("error"); e != "" {
+		w.WriteHeader(401)
+		io.WriteString(w, errorJSON(fmt.Sprintf("Login failed: %s", e)))
+		return
+	}
+
+	c, err := s.oidc.exchange(r.FormValue("code"), r.FormValue("state"))
+	if err != nil {
+		w.WriteHeader(401)
+		io.WriteString(w, errorJSON(fmt.Sprintf("Login failed: %v", err)))
+		return
+	}
+
+	name := c.PreferredUsername
+	if name == "" {
+		name = strings.Split(c.Email, "@")[0]
+	}
+	acct := s.accounts.oidcAccount(c.Issuer, c.Subject, name)
+	if err := s.accounts.startSession(w, r, acct); err != nil {
+		w.WriteHeader(500)
+		io.WriteString(w, errorJSON(err.Error()))
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sbadame/scopa/protocol"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// summary is what the leaderboard and profiles show about a player.
+type summary struct {
+	Nickname       string
+	Rating         *protocol.Rating `json:",omitempty"` // Left out until they finish a game.
+	Games          int
+	Wins           int
+	Losses         int
+	Ties           int
+	Scopas         int
+	SettebelloRate float64 // The share of their games played to the last card in which they took the settebello.
+	PrimieraRate   float64 // The share of their games played to the last card in which they won the primiera.
+}
+
+// nickname returns the name to show for a player ID.
+func (s *server) nickname(id string) string {
+	if !isAccountID(id) {
+		return id
+	}
+	s.accounts.Lock()
+	defer s.accounts.Unlock()
+	if acct, ok := s.accounts.Accounts[id]; ok {
+		return acct.Username
+	}
+	return id
+}
+
+// summaries returns the summary of every player with stats or a rating, by ID.
+func (s *server) summaries() map[string]*summary {
+	all := make(map[string]*summary)
+	get := func(id string) *summary {
+		if _, ok := all[id]; !ok {
+			all[id] = &summary{Nickname: s.nickname(id)}
+		}
+		return all[id]
+	}
+
+	if st := s.m.stats; st != nil {
+		st.Lock()
+		for id, p := range st.Players {
+			sum := get(id)
+			sum.Games, sum.Wins, sum.Losses, sum.Ties, sum.Scopas = p.Games, p.Wins, p.Losses, p.Ties, p.Scopas
+			sum.SettebelloRate, sum.PrimieraRate = rate(p.Settebellos, p.PlayedOut), rate(p.Primieras, p.PlayedOut)
+		}
+		st.Unlock()
+	}
+	if r := s.m.ratings; r != nil {
+		r.Lock()
+		ids := make([]string, 0, len(r.Players))
+		for id := range r.Players {
+			ids = append(ids, id)
+		}
+		r.Unlock()
+		for _, id := range ids {
+			get(id).Rating = r.view(id)
+		}
+	}
+	return all
+}
+
+// The orders that the leaderboard can be sorted in, by the sort parameter.
+var leaderboardOrders = map[string]func(a, b *summary) bool{
+	"rating": func(a, b *summary) bool {
+		if a.Rating == nil || b.Rating == nil {
+			return b.Rating == nil && a.Rating != nil
+		}
+		return a.Rating.Rating > b.Rating.Rating
+	},
+	"wins":       func(a, b *summary) bool { return a.Wins > b.Wins },
+	"scopas":     func(a, b *summary) bool { return a.Scopas > b.Scopas },
+	"settebello": func(a, b *summary) bool { return a.SettebelloRate > b.SettebelloRate },
+	"primiera":   func(a, b *summary) bool { return a.PrimieraRate > b.PrimieraRate },
+}
+
+// leaderboard serves /leaderboard?sort=rating&limit=50, the best players first.
+// sort is one of rating (the default), wins, scopas, settebello or primiera.
+func (s *server) leaderboard(w http.ResponseWriter, r *http.Request) {
+	order := r.FormValue("sort")
+	if order == "" {
+		order = "rating"
+	}
+	better, ok := leaderboardOrders[order]
+	if !ok {
+		w.WriteHeader(400)
+		io.WriteString(w, errorJSON(fmt.Sprintf("Can't sort by %s, try rating, wins, scopas, settebello or primiera.", order)))
+		return
+	}
+	limit := 50
+	if l := r.FormValue("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 {
+			w.WriteHeader(400)
+			io.WriteString(w, errorJSON("limit should be a positive number."))
+			return
+		}
+	}
+
+	board := make([]*summary, 0)
+	for _, sum := range s.summaries() {
+		board = append(board, sum)
+	}
+	sort.Slice(board, func(i, j int) bool {
+		if better(board[i], board[j]) != better(board[j], board[i]) {
+			return better(board[i], board[j])
+		}
+		return board[i].Nickname < board[j].Nickname
+	})
+	if len(board) > limit {
+		board = board[:limit]
+	}
+	json.NewEncoder(w).Encode(board)
+}
+
+// headToHead is the points that a player has won against an opponent, from the scoreboard.
+type headToHead struct {
+	Opponent       string
+	Points         int
+	OpponentPoints int
+}
+
+// recentGame is a game in a player's profile.
+type recentGame struct {
+	gameResult
+	Opponent string // Nickname.
+}
+
+// player serves /players/{nickname}, the player's summary, lifetime stats, head to head records and recent games.
+func (s *server) player(w http.ResponseWriter, r *http.Request) {
+	nick := strings.TrimPrefix(r.URL.Path, "/players/")
+	id := s.playerID(nick)
+
+	sum, ok := s.summaries()[id]
+	if !ok {
+		w.WriteHeader(404)
+		io.WriteString(w, errorJSON(fmt.Sprintf("%s hasn't finished a game yet.", nick)))
+		return
+	}
+	resp := struct {
+		*summary
+		Lifetime   lifetime
+		HeadToHead []headToHead
+		Recent     []recentGame // Newest first.
+	}{summary: sum, HeadToHead: make([]headToHead, 0), Recent: make([]recentGame, 0)}
+
+	s.sb.Lock()
+	for _, sc := range s.sb.cards {
+		if _, ok := sc.Scores[id]; !ok {
+			continue
+		}
+		for opp, v := range sc.Scores {
+			if opp != id {
+				resp.HeadToHead = append(resp.HeadToHead, headToHead{opp, sc.Scores[id], v})
+			}
+		}
+	}
+	s.sb.Unlock()
+	for i := range resp.HeadToHead {
+		resp.HeadToHead[i].Opponent = s.nickname(resp.HeadToHead[i].Opponent)
+	}
+	sort.Slice(resp.HeadToHead, func(i, j int) bool { return resp.HeadToHead[i].Opponent < resp.HeadToHead[j].Opponent })
+
+	var recent []gameResult
+	if st := s.m.stats; st != nil {
+		st.Lock()
+		if p, ok := st.Players[id]; ok {
+			resp.Lifetime = p.lifetime()
+			recent = append(recent, p.Recent...)
+		}
+		st.Unlock()
+	}
+	for i := len(recent) - 1; i >= 0; i-- {
+		resp.Recent = append(resp.Recent, recentGame{recent[i], s.nickname(recent[i].Opponent)})
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// lifetimeStats serves /stats/{nickname}, the player's stats over every game they've finished.
+func (s *server) lifetimeStats(w http.ResponseWriter, r *http.Request) {
+	nick := strings.TrimPrefix(r.URL.Path, "/stats/")
+	id := s.playerID(nick)
+
+	var p *playerStats
+	if st := s.m.stats; st != nil {
+		st.Lock()
+		defer st.Unlock()
+		p = st.Players[id]
+	}
+	if p == nil {
+		w.WriteHeader(404)
+		io.WriteString(w, errorJSON(fmt.Sprintf("%s hasn't finished a game yet.", nick)))
+		return
+	}
+	json.NewEncoder(w).Encode(p.lifetime())
+}
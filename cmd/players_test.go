@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func tempStats(t *testing.T) *stats {
+	return loadStats(tempStore(t, "stats", statsMigrations...))
+}
+
+// resignGame plays a game between a and b in a new match, which loser resigns.
+func resignGame(t *testing.T, s *server, a, b, loser string) {
+	s.m.Lock()
+	s.m.Reset(s.m.ID + 1)
+	id := s.m.ID
+	s.m.Unlock()
+
+	tokens := make(map[string]string)
+	for _, n := range []string{a, b} {
+		p, err := s.m.addPlayer(id, n, n, "", s.sb)
+		if err != nil {
+			t.Fatalf("Couldn't seat %s: %v", n, err)
+		}
+		tokens[n] = p.token
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	if err := s.m.resign(tokens[loser], s.sb); err != nil {
+		t.Fatalf("%s couldn't resign: %v", loser, err)
+	}
+}
+
+func TestLeaderboard(t *testing.T) {
+	s := &server{m: Match{ID: 1}, sb: tempScoreboard(t), accounts: tempAccounts(t)}
+	s.m.ratings = tempRatings(t, s.sb)
+	s.m.stats = tempStats(t)
+	resignGame(t, s, "a", "b", "b")
+	resignGame(t, s, "a", "c", "c")
+	resignGame(t, s, "b", "c", "c")
+
+	get := func(url string, v interface{}) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", url, nil)
+		if strings.HasPrefix(url, "/players/") {
+			s.player(w, r)
+		} else {
+			s.leaderboard(w, r)
+		}
+		json.Unmarshal(w.Body.Bytes(), v)
+		return w.Code
+	}
+
+	var board []summary
+	if code := get("/leaderboard", &board); code != 200 || len(board) != 3 {
+		t.Fatalf("Expected 3 players on the leaderboard, got %d: %+v", code, board)
+	}
+	if board[0].Nickname != "a" || board[1].Nickname != "b" || board[2].Nickname != "c" {
+		t.Errorf("Expected the leaderboard to be a, b, c by rating, got %+v", board)
+	}
+	if board[0].Games != 2 || board[0].Wins != 2 || board[0].Rating == nil {
+		t.Errorf("Unexpected leaderboard entry for a: %+v", board[0])
+	}
+	if get("/leaderboard?sort=wins&limit=1", &board); len(board) != 1 || board[0].Nickname != "a" {
+		t.Errorf("Expected only a when limited to 1, got %+v", board)
+	}
+	if code := get("/leaderboard?sort=luck", &board); code != 400 {
+		t.Errorf("Expected sorting by luck to fail, got %d", code)
+	}
+
+	var profile struct {
+		Nickname   string
+		Losses     int
+		HeadToHead []headToHead
+		Recent     []struct {
+			Opponent string
+			Score    float64
+		}
+	}
+	if code := get("/players/c", &profile); code != 200 {
+		t.Fatalf("Couldn't get c's profile: %d", code)
+	}
+	if profile.Nickname != "c" || profile.Losses != 2 || len(profile.HeadToHead) != 2 || profile.HeadToHead[0].Opponent != "a" {
+		t.Errorf("Unexpected profile: %+v", profile)
+	}
+	if len(profile.Recent) != 2 || profile.Recent[0].Opponent != "b" || profile.Recent[0].Score != 0 {
+		t.Errorf("Expected c's newest game to be the loss to b, got %+v", profile.Recent)
+	}
+	if code := get("/players/nobody", &profile); code != 404 {
+		t.Errorf("Expected a 404 for a player without games, got %d", code)
+	}
+}
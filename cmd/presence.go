@@ -0,0 +1,61 @@
+package main
+
+import (
+	"github.com/sbadame/scopa/protocol"
+	"golang.org/x/net/websocket"
+	"time"
+)
+
+// setPresence records whether nick is there, and tells the players if that changed. Presence isn't an event, so it
+// doesn't get replayed to players catching up. Callers must hold the lock.
+func (m *Match) setPresence(nick, status string) {
+	if m.presence[nick] == status {
+		return
+	}
+	if m.presence == nil {
+		m.presence = make(map[string]string)
+	}
+	m.presence[nick] = status
+	m.presenceTurns++
+	for _, p := range m.players {
+		p.conn.notify()
+	}
+}
+
+// presenceView returns a copy of everyone's presence, callers must hold the lock.
+func (m *Match) presenceView() map[string]string {
+	v := make(map[string]string)
+	for n, s := range m.presence {
+		v[n] = s
+	}
+	return v
+}
+
+// reportPresence tells the other player whether the player holding token is looking at the game. Callers must hold
+// the lock.
+func (m *Match) reportPresence(token, status string) error {
+	p := m.seat(token)
+	if p == nil {
+		return matchErrorf(403, "You don't have a seat in this match.")
+	}
+	if status != protocol.Online && status != protocol.Away {
+		return matchErrorf(400, "Presence should be %s or %s.", protocol.Online, protocol.Away)
+	}
+	m.setPresence(p.nick, status)
+	return nil
+}
+
+// heartbeat pings ws every protocol.HeartbeatInterval until quit is closed, so that the client can tell that the
+// server is still there, and answers to show that it is too.
+func heartbeat(ws *websocket.Conn, quit <-chan struct{}) {
+	t := time.NewTicker(wsHeartbeat)
+	defer t.Stop()
+	for {
+		select {
+		case now := <-t.C:
+			websocket.JSON.Send(ws, protocol.ServerMessage{Ping: now.UnixNano() / int64(time.Millisecond)})
+		case <-quit:
+			return
+		}
+	}
+}
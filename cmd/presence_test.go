@@ -0,0 +1,65 @@
+package main
+
+import (
+	"github.com/sbadame/scopa/protocol"
+	"golang.org/x/net/websocket"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPresence(t *testing.T) {
+	defer func(h, to time.Duration) { wsHeartbeat, wsTimeout = h, to }(wsHeartbeat, wsTimeout)
+	wsHeartbeat, wsTimeout = 10*time.Millisecond, 100*time.Millisecond
+
+	s := &server{m: Match{ID: 1}, sb: tempScoreboard(t), accounts: tempAccounts(t)}
+	ts := httptest.NewServer(websocket.Handler(s.join))
+	defer ts.Close()
+	u := "ws" + strings.TrimPrefix(ts.URL, "http") + "/join?Version=3&Nickname="
+	dial := func(nick string) *websocket.Conn {
+		ws, err := websocket.Dial(u+nick, "", ts.URL)
+		if err != nil {
+			t.Fatalf("Couldn't join as %s: %v", nick, err)
+		}
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return ws
+	}
+	a, b := dial("a"), dial("b")
+	defer a.Close()
+	defer b.Close()
+
+	// a answers every ping and hides the game, b never says anything so the server gives up on them.
+	seen := make(map[string]bool)
+	for !seen["b "+protocol.Disconnected] {
+		var m protocol.ServerMessage
+		if err := websocket.JSON.Receive(a, &m); err != nil {
+			t.Fatalf("Waiting for b to be gone, after %v: %v", seen, err)
+		}
+		if m.Ping != 0 {
+			seen["ping"] = true
+			websocket.JSON.Send(a, protocol.Command{Type: protocol.PongCommand})
+		}
+		if m.Nicknames != nil {
+			websocket.JSON.Send(a, protocol.Command{Type: protocol.PresenceCommand, Presence: protocol.Away})
+		}
+		for n, p := range m.Presence {
+			seen[n+" "+p] = true
+		}
+	}
+	if !seen["ping"] || !seen["a "+protocol.Away] {
+		t.Errorf("Expected pings and a to be away, got %v", seen)
+	}
+	for {
+		var m protocol.ServerMessage
+		if err := websocket.JSON.Receive(b, &m); err != nil {
+			break // Hung up on.
+		}
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	if got := s.m.presence["a"]; got != protocol.Away {
+		t.Errorf("Expected a to still be there but away, got %q", got)
+	}
+}
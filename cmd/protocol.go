@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sbadame/scopa/protocol"
+	"golang.org/x/net/websocket"
+	"time"
+)
+
+// handle runs c for the player holding token.
+func (s *server) handle(token string, c protocol.Command) (*protocol.Ack, error) {
+	m := s.matchFor(token)
+	m.Lock()
+	defer m.Unlock()
+
+	var err error
+	switch c.Type {
+	case protocol.DropCommand, protocol.TakeCommand:
+		if c.Card == nil {
+			err = matchErrorf(400, "A %s needs a Card.", c.Type)
+		} else if c.Type == protocol.DropCommand {
+			err = m.drop(token, *c.Card, s.sb)
+		} else {
+			err = m.take(token, *c.Card, c.Table, s.sb)
+		}
+	case protocol.ChatCommand:
+		err = m.chat(token, c.Text, c.Preset)
+	case protocol.ResignCommand:
+		err = m.resign(token, s.sb)
+	case protocol.AbortCommand, protocol.DrawCommand:
+		err = m.propose(token, c.Type, s.sb)
+	case protocol.OfferRematchCommand:
+		var o protocol.RematchOptions
+		if c.Rematch != nil {
+			o = *c.Rematch
+		}
+		err = m.offerRematch(token, o, s.sb)
+	case protocol.AcceptRematchCommand:
+		err = m.acceptRematch(token, s.sb)
+	case protocol.DeclineRematchCommand:
+		err = m.declineRematch(token)
+	case protocol.ResyncCommand:
+		if p := m.seat(token); p != nil {
+			p.conn.requestResync()
+		} else {
+			err = matchErrorf(403, "You don't have a seat in this match.")
+		}
+	case protocol.PingCommand, protocol.PongCommand:
+	case protocol.PresenceCommand:
+		err = m.reportPresence(token, c.Presence)
+	default:
+		err = matchErrorf(400, "Unknown command type %q.", c.Type)
+	}
+	return &protocol.Ack{ID: c.ID, Seq: len(m.events)}, err
+}
+
+// readMessages runs the commands that the client holding token sends over the websocket, until it's closed. From
+// version 3, clients that don't send anything for wsTimeout are given up on.
+func (s *server) readMessages(ws *websocket.Conn, token string, version int) {
+	for {
+		if version >= 3 {
+			ws.SetReadDeadline(time.Now().Add(wsTimeout))
+		}
+		var b []byte
+		if err := websocket.Message.Receive(ws, &b); err != nil {
+			// The connection is closed, broken or quiet.
+			return
+		}
+
+		var c protocol.Command
+		if err := json.Unmarshal(b, &c); err != nil {
+			websocket.JSON.Send(ws, protocol.ServerMessage{Error: &protocol.Error{
+				Code:    400,
+				Message: fmt.Sprintf("Couldn't parse the command: %v", err),
+			}})
+			continue
+		}
+
+		a, err := s.handle(token, c)
+		if err != nil {
+			websocket.JSON.Send(ws, protocol.ServerMessage{Error: &protocol.Error{ID: c.ID, Code: statusCode(err), Message: err.Error()}})
+			continue
+		}
+		websocket.JSON.Send(ws, protocol.ServerMessage{Ack: a})
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sbadame/scopa/glicko"
+	"github.com/sbadame/scopa/protocol"
+	"github.com/sbadame/scopa/store"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ratings are the Glicko-2 ratings of every player that has finished a game, by account ID or guest nickname.
+// Every game is its own rating period.
+type ratings struct {
+	sync.Mutex
+	store   store.Store
+	Players map[string]*playerRating
+}
+
+type playerRating struct {
+	Nickname string // The last one they played under.
+	glicko.Rating
+	Games   int
+	History []ratingChange
+}
+
+// ratingChange is a player's rating after a game.
+type ratingChange struct {
+	Time      time.Time
+	Opponent  string  // Account ID or guest nickname.
+	Score     float64 // 1 for a win, 0.5 for a tie and 0 for a loss.
+	Rating    float64
+	Deviation float64
+}
+
+// ratingsMigrations bring older ratings files up to date, see store.Open.
+var ratingsMigrations = []store.Migration{store.Unchanged}
+
+// loadRatings reads the ratings from st. The first time, when nothing has been saved yet, they are seeded from the
+// points in sb instead.
+func loadRatings(st store.Store, sb *scoreboard) *ratings {
+	r := &ratings{store: st, Players: make(map[string]*playerRating)}
+	err := st.Load(r, nil)
+	if os.IsNotExist(err) {
+		fmt.Printf("Couldn't read the ratings, %v, seeding them from the scoreboard.\n", err)
+		r.seed(sb)
+	} else if err != nil {
+		fmt.Printf("Couldn't read the ratings, %v\n", err)
+	}
+	return r
+}
+
+// seed rates the players in sb as if each pair had played one game between new players, scored by the share of
+// points they won against eachother. It's only a rough start, the deviations stay high.
+func (r *ratings) seed(sb *scoreboard) {
+	results := make(map[string][]glicko.Result)
+	sb.Lock()
+	defer sb.Unlock()
+	for _, sc := range sb.cards {
+		var ids []string
+		total := 0
+		for id, v := range sc.Scores {
+			ids = append(ids, id)
+			total += v
+		}
+		if len(ids) != 2 || total == 0 {
+			continue
+		}
+		for _, id := range ids {
+			score := float64(sc.Scores[id]) / float64(total)
+			results[id] = append(results[id], glicko.Result{Opponent: glicko.New(), Score: score})
+		}
+	}
+
+	for id, res := range results {
+		nick := id
+		if isAccountID(id) {
+			nick = "" // Filled in the next time they play.
+		}
+		r.Players[id] = &playerRating{Nickname: nick, Rating: glicko.New().Update(res)}
+	}
+}
+
+// save writes the ratings to disk, callers must hold the lock.
+func (r *ratings) save() {
+	if err := r.store.Save(r); err != nil {
+		fmt.Printf("Couldn't save the ratings: %v\n", err)
+	}
+}
+
+// player returns the rating of id, new players get the default one.
+// Callers must hold the lock.
+func (r *ratings) player(id string) *playerRating {
+	p, ok := r.Players[id]
+	if !ok {
+		p = &playerRating{Nickname: id, Rating: glicko.New()}
+		r.Players[id] = p
+	}
+	return p
+}
+
+// record updates the ratings of both players after a game that a scored aScore against b.
+func (r *ratings) record(aID, aNick, bID, bNick string, aScore float64, now time.Time) {
+	r.Lock()
+	defer r.Unlock()
+
+	a, b := r.player(aID), r.player(bID)
+	// Both are rated against where the other one was before the game.
+	ar := a.Update([]glicko.Result{{Opponent: b.Rating, Score: aScore}})
+	br := b.Update([]glicko.Result{{Opponent: a.Rating, Score: 1 - aScore}})
+
+	for _, u := range []struct {
+		p              *playerRating
+		nick, opponent string
+		rating         glicko.Rating
+		score          float64
+	}{
+		{a, aNick, bID, ar, aScore},
+		{b, bNick, aID, br, 1 - aScore},
+	} {
+		u.p.Nickname, u.p.Rating = u.nick, u.rating
+		u.p.Games++
+		u.p.History = append(u.p.History, ratingChange{now, u.opponent, u.score, u.rating.Rating, u.rating.Deviation})
+	}
+	r.save()
+}
+
+// view returns the rating of id for clients, or nil if they aren't rated yet.
+func (r *ratings) view(id string) *protocol.Rating {
+	r.Lock()
+	defer r.Unlock()
+	p, ok := r.Players[id]
+	if !ok {
+		return nil
+	}
+	return &protocol.Rating{Rating: int(math.Round(p.Rating.Rating)), Deviation: int(math.Round(p.Deviation))}
+}
+
+// outcome is how the game went for the first player: 1 for a win, 0.5 for a tie and 0 for a loss.
+// Callers must hold the lock, and the game must have ended.
+func (m *Match) outcome() float64 {
+	if f := m.state.Forfeited; f != "" {
+		if f == m.players[0].nick {
+			return 0
+		}
+		return 1
+	}
+	if m.state.Stopped != "" {
+		return 0.5 // Drawn, or aborted.
+	}
+
+	p1, p2 := m.state.Players[0], m.state.Players[1]
+	a1, a2 := len(p1.Awards)+p1.Scopas, len(p2.Awards)+p2.Scopas
+	switch {
+	case a1 > a2:
+		return 1
+	case a1 < a2:
+		return 0
+	}
+	return 0.5
+}
+
+// playerID returns the ID that nick's results are kept under.
+func (s *server) playerID(nick string) string {
+	s.accounts.Lock()
+	defer s.accounts.Unlock()
+	if acct := s.accounts.byUsername(nick); acct != nil {
+		return acct.ID
+	}
+	return nick
+}
+
+// rating serves /ratings/{nickname}, the player's rating and how it changed with every game.
+func (s *server) rating(w http.ResponseWriter, r *http.Request) {
+	nick := strings.TrimPrefix(r.URL.Path, "/ratings/")
+	id := s.playerID(nick)
+	if s.m.ratings == nil {
+		w.WriteHeader(404)
+		io.WriteString(w, errorJSON("Ratings aren't kept on this server."))
+		return
+	}
+
+	rs := s.m.ratings
+	rs.Lock()
+	defer rs.Unlock()
+	p, ok := rs.Players[id]
+	if !ok {
+		w.WriteHeader(404)
+		io.WriteString(w, errorJSON(fmt.Sprintf("%s hasn't finished a game yet.", nick)))
+		return
+	}
+
+	type change struct {
+		Time      time.Time
+		Opponent  string // Nickname.
+		Score     float64
+		Rating    float64
+		Deviation float64
+	}
+	resp := struct {
+		Nickname string
+		glicko.Rating
+		Games   int
+		History []change
+	}{Nickname: p.Nickname, Rating: p.Rating, Games: p.Games, History: make([]change, 0)}
+	for _, h := range p.History {
+		opp := h.Opponent
+		if o, ok := rs.Players[h.Opponent]; ok {
+			opp = o.Nickname
+		}
+		resp.History = append(resp.History, change{h.Time, opp, h.Score, h.Rating, h.Deviation})
+	}
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/sbadame/scopa/glicko"
+	"net/http/httptest"
+	"testing"
+)
+
+// tempRatings returns ratings that are saved to a temp file, seeded from sb.
+func tempRatings(t *testing.T, sb *scoreboard) *ratings {
+	return loadRatings(tempStore(t, "ratings", ratingsMigrations...), sb)
+}
+
+func TestSeedRatings(t *testing.T) {
+	sb := tempScoreboard(t)
+	sb.record("a", "b", 10, 5, "")
+	r := tempRatings(t, sb)
+
+	a, b := r.Players["a"], r.Players["b"]
+	if a == nil || b == nil {
+		t.Fatalf("Expected a and b to be seeded, got %v", r.Players)
+	}
+	if a.Rating.Rating <= glicko.DefaultRating || b.Rating.Rating >= glicko.DefaultRating {
+		t.Errorf("Expected a to be seeded above b, got %v and %v", a.Rating, b.Rating)
+	}
+	if a.Deviation >= glicko.DefaultDeviation {
+		t.Errorf("Expected the seeded deviation to shrink, got %v", a.Deviation)
+	}
+}
+
+func TestRatings(t *testing.T) {
+	s := &server{m: Match{ID: 1}, sb: tempScoreboard(t), accounts: tempAccounts(t)}
+	s.m.ratings = tempRatings(t, s.sb)
+	if _, err := s.m.addPlayer(1, "a", "a", "", s.sb); err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.m.addPlayer(1, "b", "b", "", s.sb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.m.Lock()
+	if err := s.m.resign(b.token, s.sb); err != nil {
+		t.Fatalf("Couldn't resign: %v", err)
+	}
+	s.m.Unlock()
+
+	if a, b := s.m.ratings.view("a"), s.m.ratings.view("b"); a == nil || b == nil || a.Rating <= b.Rating {
+		t.Errorf("Expected a to be rated above b after b resigned, got %v and %v", a, b)
+	}
+	if again := loadRatings(s.m.ratings.store, nil); len(again.Players["b"].History) != 1 {
+		t.Errorf("Expected the game to be saved in b's history, got %#v", again.Players["b"])
+	}
+
+	w := httptest.NewRecorder()
+	s.rating(w, httptest.NewRequest("GET", "/ratings/b", nil))
+	var got struct {
+		Games   int
+		History []struct {
+			Opponent string
+			Score    float64
+		}
+	}
+	json.Unmarshal(w.Body.Bytes(), &got)
+	if w.Code != 200 || got.Games != 1 || len(got.History) != 1 || got.History[0].Opponent != "a" || got.History[0].Score != 0 {
+		t.Errorf("Unexpected /ratings/b: %d %s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	s.rating(w, httptest.NewRequest("GET", "/ratings/nobody", nil))
+	if w.Code != 404 {
+		t.Errorf("Expected a 404 for a player without a rating, got %d", w.Code)
+	}
+}
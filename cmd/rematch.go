@@ -0,0 +1,188 @@
+package main
+
+import (
+	"github.com/sbadame/scopa/protocol"
+	"github.com/sbadame/scopa/scopa"
+	"time"
+)
+
+// rematchTimeout is how long the players have to offer a rematch once a game is over, and then to accept it.
+var rematchTimeout = 2 * time.Minute
+
+// over reports whether the game is over and the players won't play again. Callers must hold the lock.
+func (m *Match) over() bool {
+	return m.rematch != nil && (m.rematch.Status == protocol.RematchDeclined || m.rematch.Status == protocol.RematchExpired)
+}
+
+// setRematch records where the rematch stands, and tells the players. Callers must hold the lock.
+func (m *Match) setRematch(r protocol.Rematch) {
+	m.rematch = &r
+	m.appendEvent(event{rematch: &r})
+	if m.over() && m.onOver != nil {
+		m.onOver(m)
+	}
+}
+
+// timeRematch gives the players rematchTimeout to offer a rematch, or to accept the one that was offered, before
+// the match is over. Callers must hold the lock.
+func (m *Match) timeRematch() {
+	m.stopRematchTimer()
+	id, turn := m.ID, m.rematchTurns
+	m.rematchTimer = m.afterFunc(rematchTimeout, func() {
+		m.Lock()
+		defer m.Unlock()
+		// Offers, answers and resets that raced with the timer win.
+		if m.ID != id || m.rematchTurns != turn {
+			return
+		}
+		r := protocol.Rematch{Status: protocol.RematchExpired}
+		if m.rematch != nil {
+			r.From, r.Options = m.rematch.From, m.rematch.Options
+		}
+		m.setRematch(r)
+	})
+}
+
+// stopRematchTimer stops the rematch timer, callers must hold the lock.
+func (m *Match) stopRematchTimer() {
+	m.rematchTurns++
+	if m.rematchTimer != nil {
+		m.rematchTimer.Stop()
+		m.rematchTimer = nil
+	}
+}
+
+// offerRematch offers to play again once the game is over, for the player holding token. Callers must hold the
+// lock.
+func (m *Match) offerRematch(token string, o protocol.RematchOptions, sb *scoreboard) error {
+	p := m.seat(token)
+	if p == nil {
+		return matchErrorf(403, "You don't have a seat in this match.")
+	}
+	if len(m.players) < 2 || !m.state.Ended() {
+		return matchErrorf(400, "The game isn't over yet.")
+	}
+	if r := m.rematch; r != nil {
+		switch {
+		case r.Status != protocol.RematchOffered:
+			return matchErrorf(400, "This match is over, join again to play.")
+		case r.From == p.nick:
+			return matchErrorf(400, "You've already offered a rematch.")
+		default:
+			return matchErrorf(400, "%s has already offered a rematch, accept or decline it.", r.From)
+		}
+	}
+
+	switch o.Variant {
+	case "", scopa.Scopa, scopa.AssoPigliaTutto:
+	default:
+		return matchErrorf(400, "Variant should be %s or %s.", scopa.Scopa, scopa.AssoPigliaTutto)
+	}
+	if o.TimeControl != nil {
+		if _, err := parseTimeControl(*o.TimeControl); err != nil {
+			return matchErrorf(400, "%v", err)
+		}
+	}
+	if o.Partita != 0 {
+		if to := sb.partitaTo(m.players[0].id, m.players[1].id); o.Partita <= to {
+			return matchErrorf(400, "The partita is already played to %d, it can only be extended.", to)
+		}
+	}
+
+	m.timeRematch()
+	m.setRematch(protocol.Rematch{
+		Status:  protocol.RematchOffered,
+		From:    p.nick,
+		Options: o,
+		Expires: m.now().Add(rematchTimeout).UnixNano() / int64(time.Millisecond),
+	})
+	if m.bot != "" && m.onEnd == nil {
+		// Practicing against the bot, which always wants to play again.
+		return m.acceptRematch(m.bot, sb)
+	}
+	return nil
+}
+
+// offered returns the rematch offer that the player holding token can answer. Callers must hold the lock.
+func (m *Match) offered(token string) (*protocol.Rematch, error) {
+	p := m.seat(token)
+	if p == nil {
+		return nil, matchErrorf(403, "You don't have a seat in this match.")
+	}
+	r := m.rematch
+	if r == nil || r.Status != protocol.RematchOffered {
+		return nil, matchErrorf(400, "There's no rematch offer to answer.")
+	}
+	if r.From == p.nick {
+		return nil, matchErrorf(400, "You can't answer your own offer.")
+	}
+	return r, nil
+}
+
+// acceptRematch starts the rematch that the other player offered. Callers must hold the lock.
+func (m *Match) acceptRematch(token string, sb *scoreboard) error {
+	r, err := m.offered(token)
+	if err != nil {
+		return err
+	}
+	m.stopRematchTimer()
+	m.rematch = nil
+
+	o := r.Options
+	if o.TimeControl != nil {
+		m.control, _ = parseTimeControl(*o.TimeControl)
+	}
+	if o.Variant != "" {
+		m.variant = o.Variant
+		if o.Variant == scopa.Scopa {
+			m.variant = ""
+		}
+	}
+	if o.Partita != 0 {
+		sb.extend(m.players[0].id, m.players[1].id, o.Partita)
+	}
+
+	// The clock starts over.
+	m.stopClock()
+	m.remaining = nil
+	m.turnPlayer = ""
+	m.deal(sb, o.SwapSeats)
+	e := m.stateEvent()
+	e.rematch = &protocol.Rematch{Status: protocol.RematchAccepted, From: r.From, Options: o}
+	e.nicknames, e.scorecard = m.lineup(sb)
+	m.appendEvent(e)
+	m.moveBot(sb)
+	return nil
+}
+
+// declineRematch turns down the rematch that the other player offered, which is the end of the match. Callers must
+// hold the lock.
+func (m *Match) declineRematch(token string) error {
+	r, err := m.offered(token)
+	if err != nil {
+		return err
+	}
+	m.stopRematchTimer()
+	m.setRematch(protocol.Rematch{Status: protocol.RematchDeclined, From: r.From, Options: r.Options})
+	return nil
+}
+
+// leave walks away from a match once its game is over, which turns down the rematch. Callers must hold the lock.
+func (m *Match) leave(token string) error {
+	if m.seat(token) == nil {
+		return matchErrorf(403, "You don't have a seat in this match.")
+	}
+	if len(m.players) < 2 || !m.state.Ended() {
+		return matchErrorf(400, "This match isn't over yet.")
+	}
+	if m.over() {
+		return nil
+	}
+	r := protocol.Rematch{Status: protocol.RematchDeclined}
+	if m.rematch != nil {
+		r.From, r.Options = m.rematch.From, m.rematch.Options
+	}
+	m.stopRematchTimer()
+	m.setRematch(r)
+	return nil
+}
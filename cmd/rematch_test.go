@@ -0,0 +1,161 @@
+package main
+
+import (
+	"github.com/sbadame/scopa/protocol"
+	"github.com/sbadame/scopa/scopa"
+	"testing"
+	"time"
+)
+
+// endedMatch is a clocked match where the player moving second has won by resignation.
+func endedMatch(t *testing.T) (m *Match, c *fakeClock, sb *scoreboard, first, second player) {
+	m, c, sb = clockedMatch(t, "")
+	first, second = m.players[0], m.players[1]
+	if err := m.offerRematch(first.token, protocol.RematchOptions{}, sb); err == nil {
+		t.Errorf("Expected a rematch to be refused before the game is over.")
+	}
+	if err := m.resign(first.token, sb); err != nil {
+		t.Fatalf("Couldn't resign: %v", err)
+	}
+	return m, c, sb, first, second
+}
+
+func TestAcceptRematch(t *testing.T) {
+	m, _, sb, first, second := endedMatch(t)
+	id := m.ID
+
+	for _, o := range []protocol.RematchOptions{{Variant: "briscola"}, {Partita: partitaPoints}} {
+		if err := m.offerRematch(first.token, o, sb); err == nil {
+			t.Errorf("Expected the offer %+v to be refused.", o)
+		}
+	}
+	o := protocol.RematchOptions{SwapSeats: true, Variant: scopa.AssoPigliaTutto, Partita: 21}
+	if err := m.offerRematch(first.token, o, sb); err != nil {
+		t.Fatalf("Couldn't offer a rematch: %v", err)
+	}
+	if err := m.offerRematch(second.token, o, sb); err == nil {
+		t.Errorf("Expected a second offer to be refused.")
+	}
+	if err := m.acceptRematch(first.token, sb); err == nil {
+		t.Errorf("Expected players to be kept from accepting their own offer.")
+	}
+	if err := m.acceptRematch(second.token, sb); err != nil {
+		t.Fatalf("Couldn't accept the rematch: %v", err)
+	}
+
+	if m.ID != id || m.state.Ended() || m.rematch != nil {
+		t.Errorf("Expected a new game in match %d, got match %d with rematch %+v", id, m.ID, m.rematch)
+	}
+	// The scoreboard would have had second move first this time.
+	if m.state.NextPlayer != first.nick {
+		t.Errorf("Expected %s to move first again after swapping, got %s", first.nick, m.state.NextPlayer)
+	}
+	if m.state.Variant != scopa.AssoPigliaTutto {
+		t.Errorf("Expected %s, got %q", scopa.AssoPigliaTutto, m.state.Variant)
+	}
+	if to := sb.partitaTo(first.id, second.id); to != 21 {
+		t.Errorf("Expected the partita to go to 21, got %d", to)
+	}
+	e := m.events[len(m.events)-1]
+	if e.rematch == nil || e.rematch.Status != protocol.RematchAccepted || e.state == nil || len(e.nicknames) != 2 {
+		t.Errorf("Expected the new game to be announced, got %+v", e)
+	}
+}
+
+func TestSwapSeats(t *testing.T) {
+	// Who moves first in a rematch, with and without swapping, after first moved first and resigned.
+	for _, swap := range []bool{false, true} {
+		m, _, sb, first, second := endedMatch(t)
+		if err := m.offerRematch(first.token, protocol.RematchOptions{SwapSeats: swap}, sb); err != nil {
+			t.Fatalf("Couldn't offer a rematch: %v", err)
+		}
+		if err := m.acceptRematch(second.token, sb); err != nil {
+			t.Fatalf("Couldn't accept the rematch: %v", err)
+		}
+		want := sb.nextPlayer(first.id, second.id)
+		if swap {
+			want = first.id
+		}
+		if m.players[0].id != want || m.state.NextPlayer != m.players[0].nick {
+			t.Errorf("swap %v: expected %s to move first, got %s", swap, want, m.state.NextPlayer)
+		}
+	}
+}
+
+func TestRematchTimeControl(t *testing.T) {
+	m, _, sb, first, second := endedMatch(t)
+	tc := "move:30s"
+	if err := m.offerRematch(first.token, protocol.RematchOptions{TimeControl: &tc}, sb); err != nil {
+		t.Fatalf("Couldn't offer a rematch: %v", err)
+	}
+	if err := m.acceptRematch(second.token, sb); err != nil {
+		t.Fatalf("Couldn't accept the rematch: %v", err)
+	}
+	if v := m.clockView(); v == nil || v.Mode != moveClock {
+		t.Errorf("Expected the rematch to be played on a move clock, got %+v", v)
+	}
+
+	// The next pairing is back to the server's default, which is no clock.
+	m.Reset(m.ID + 1)
+	for _, n := range []string{"c", "d"} {
+		if _, err := m.addPlayer(m.ID, n, n, "", sb); err != nil {
+			t.Fatalf("Couldn't seat %s: %v", n, err)
+		}
+	}
+	if v := m.clockView(); v != nil {
+		t.Errorf("Expected no clock after the rematch, got %+v", v)
+	}
+}
+
+func TestDeclineRematch(t *testing.T) {
+	m, _, sb, first, second := endedMatch(t)
+	id := m.ID
+
+	if err := m.offerRematch(first.token, protocol.RematchOptions{}, sb); err != nil {
+		t.Fatalf("Couldn't offer a rematch: %v", err)
+	}
+	if err := m.declineRematch(second.token); err != nil {
+		t.Fatalf("Couldn't decline: %v", err)
+	}
+	if !m.over() {
+		t.Fatalf("Expected the match to be over.")
+	}
+	if err := m.offerRematch(second.token, protocol.RematchOptions{}, sb); err == nil {
+		t.Errorf("Expected a rematch to be refused once the match is over.")
+	}
+
+	// Players coming back with their old seat aren't dragged into a new match.
+	if _, err := m.addPlayer(id, first.id, first.nick, first.token, sb); err != nil {
+		t.Errorf("Expected %s to get their seat back while nobody else has joined: %v", first.nick, err)
+	}
+	if _, err := m.addPlayer(id, "c", "c", "", sb); err != nil {
+		t.Fatalf("Couldn't join the next match: %v", err)
+	}
+	if m.ID == id || len(m.players) != 1 || m.rematch != nil {
+		t.Errorf("Expected a newcomer to start a new match, got match %d with %d players", m.ID, len(m.players))
+	}
+	if _, err := m.addPlayer(id, second.id, second.nick, second.token, sb); err == nil {
+		t.Errorf("Expected %s's old seat to be refused.", second.nick)
+	}
+}
+
+func TestRematchExpires(t *testing.T) {
+	m, c, sb, first, second := endedMatch(t)
+	c.Advance(rematchTimeout - time.Second)
+	if err := m.offerRematch(second.token, protocol.RematchOptions{}, sb); err != nil {
+		t.Fatalf("Couldn't offer a rematch: %v", err)
+	}
+
+	// The offer gets its own time to be answered.
+	c.Advance(rematchTimeout - time.Second)
+	if m.over() {
+		t.Fatalf("Expected the offer to still stand.")
+	}
+	c.Advance(time.Second)
+	if !m.over() || m.rematch.Status != protocol.RematchExpired {
+		t.Errorf("Expected the offer to expire, got %+v", m.rematch)
+	}
+	if err := m.acceptRematch(first.token, sb); err == nil {
+		t.Errorf("Expected an expired offer to be refused.")
+	}
+}
@@ -0,0 +1,164 @@
+// Command scopa-term plays scopa from a terminal against a running server.
+//
+//	go run github.com/sbadame/scopa/cmd/scopa-term -server http://localhost:8080 -nickname sandro
+//
+// It prints the flags to get the seat back when it quits or loses the connection, like -match 1593 -token 1f2e...
+//
+// Cards are written as their value (A, 2-7, F, C, R) followed by the first letter of their suit, so "7D" is
+// the Settebello and "RB" is the Re di Bastoni. Type "help" once connected for the list of commands.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/sbadame/scopa/client"
+	"github.com/sbadame/scopa/protocol"
+	"github.com/sbadame/scopa/textui"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	serverURL = flag.String("server", "http://localhost:8080", "The scopa server to play on.")
+	nickname  = flag.String("nickname", os.Getenv("USER"), "The nickname to play as.")
+	matchID   = flag.Int64("match", 0, "The match to get a seat back in, with -token.")
+	token     = flag.String("token", "", "The seat token to get a seat back with.")
+	lastSeq   = flag.Int("last-seq", 0, "The last message that was shown before, the ones after it are shown again.")
+)
+
+// term prints everything the server sends, keeping the latest state around to show it again.
+type term struct {
+	sync.Mutex
+	w     io.Writer
+	me    string
+	state *protocol.State
+	clock *protocol.Clock
+
+	presence map[string]string // By nickname, as last shown.
+}
+
+func (t *term) show() {
+	t.Lock()
+	defer t.Unlock()
+	if t.state == nil {
+		fmt.Fprintln(t.w, "Waiting for another player to join...")
+		return
+	}
+	textui.Render(t.w, t.me, t.state, t.clock)
+}
+
+func (t *term) printf(format string, a ...interface{}) {
+	t.Lock()
+	defer t.Unlock()
+	fmt.Fprintf(t.w, format, a...)
+}
+
+func (t *term) receive(m protocol.ServerMessage) {
+	if len(m.Nicknames) > 0 {
+		var s []string
+		for _, n := range m.Nicknames {
+			if v, ok := m.Scorecard[n]; ok {
+				n = fmt.Sprintf("%s (%d)", n, v)
+			}
+			s = append(s, n)
+		}
+		sort.Strings(s)
+		t.printf("Playing: %s\n", strings.Join(s, " vs "))
+	}
+	if m.Chat != nil {
+		t.printf("<%s> %s\n", m.Chat.From, m.Chat.Text)
+	}
+	if m.Message != "" {
+		t.printf("%s\n", m.Message)
+	}
+	for n, p := range m.Presence {
+		t.Lock()
+		if t.presence == nil {
+			t.presence = make(map[string]string)
+		}
+		changed := n != t.me && t.presence[n] != p
+		t.presence[n] = p
+		t.Unlock()
+		if changed {
+			t.printf("%s\n", textui.Presence(n, p))
+		}
+	}
+	if m.Proposal != nil {
+		t.printf("%s\n", textui.Proposal(t.me, m.Proposal))
+	}
+	if m.Rematch != nil {
+		t.printf("%s\n", textui.Rematch(t.me, m.Rematch))
+	}
+	if m.State != nil {
+		t.Lock()
+		t.state, t.clock = m.State, m.Clock
+		t.Unlock()
+		t.show()
+	}
+}
+
+func main() {
+	flag.Parse()
+	if *nickname == "" {
+		log.Fatal("Pick a nickname with -nickname.")
+	}
+
+	c, err := client.Dial(*serverURL, *nickname, &client.Options{MatchID: *matchID, Token: *token, LastSeq: *lastSeq})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+
+	t := &term{w: os.Stdout, me: *nickname}
+	fmt.Printf("Joined match %d as %s with seat token %s, type help for the list of commands.\n",
+		c.MatchID, *nickname, c.Token)
+	t.show()
+	// The seat is kept for a while after leaving.
+	resume := func() {
+		t.printf("Get your seat back with: -nickname %s -match %d -token %s -last-seq %d\n",
+			*nickname, c.MatchID, c.Token, c.LastSeq())
+	}
+
+	go func() {
+		for {
+			m, err := c.Next()
+			if err != nil {
+				t.printf("Lost the connection to the server: %v\n", err)
+				resume()
+				os.Exit(1)
+			}
+			t.receive(m)
+		}
+	}()
+
+	in := bufio.NewScanner(os.Stdin)
+	for in.Scan() {
+		line := strings.TrimSpace(in.Text())
+		switch strings.ToLower(line) {
+		case "help", "?":
+			t.printf("%s\n", textui.Help)
+			continue
+		case "show", "":
+			t.show()
+			continue
+		}
+
+		cmd, err := textui.ParseCommand(line)
+		if err == textui.ErrQuit {
+			resume()
+			return
+		}
+		if err != nil {
+			t.printf("%v\n", err)
+			continue
+		}
+		if err := c.Send(cmd); err != nil {
+			t.printf("%v\n", err)
+		}
+	}
+}
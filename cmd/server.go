@@ -1,27 +1,55 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	_ "github.com/sbadame/scopa/autoreload"
+	"github.com/sbadame/scopa/protocol"
 	"github.com/sbadame/scopa/scopa"
+	"github.com/sbadame/scopa/store"
 	"golang.org/x/crypto/acme/autocert"
 	"golang.org/x/net/websocket"
 	"io"
 	"log"
 	"math/rand"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 )
 
 var (
-	httpPort       = flag.Int("http_port", 8080, "The port to listen on for http requests.")
-	random         = flag.Bool("random", false, "When set to true, actually uses a random seed.")
-	httpsPort      = flag.Int("https_port", 8081, "The port to listen on for https requests.")
-	httpsHost      = flag.String("https_host", "", "Set this to the hostname to get a Let's Encrypt SSL certificate for.")
-	scoreboardFile = flag.String("scoreboard_file", "scoreboard.json", "The file to read and write scopa scores to.")
+	httpPort        = flag.Int("http_port", 8080, "The port to listen on for http requests.")
+	random          = flag.Bool("random", false, "When set to true, actually uses a random seed.")
+	httpsPort       = flag.Int("https_port", 8081, "The port to listen on for https requests.")
+	httpsHost       = flag.String("https_host", "", "Set this to the hostname to get a Let's Encrypt SSL certificate for.")
+	tcpPort         = flag.Int("tcp_port", 0, "The port to listen on for players using the plain text line protocol, 0 to turn it off.")
+	scoreboardFile  = flag.String("scoreboard_file", "scoreboard.json", "The file to read and write scopa scores to.")
+	accountsFile    = flag.String("accounts_file", "accounts.json", "The file to read and write registered players to.")
+	statsFile       = flag.String("stats_file", "stats.json", "The file to read and write lifetime player stats to.")
+	archiveFile     = flag.String("archive_file", "archive.json", "The file to read and write every finished match to.")
+	tournamentsFile = flag.String("tournaments_file", "tournaments.json", "The file to read and write tournaments to.")
+	dailyFile       = flag.String("daily_file", "daily.json", "The file to read and write the daily challenges to.")
+	ratingsFile     = flag.String("ratings_file", "ratings.json", "The file to read and write player ratings to, seeded from -scoreboard_file the first time.")
+	backupDir       = flag.String("backup", "", "Copy the scoreboard, accounts, ratings, stats, archive, tournaments and daily files into this directory and exit. Safe while a server is running.")
+	restoreDir      = flag.String("restore", "", "Replace the scoreboard, accounts, ratings, stats, archive, tournaments and daily files with the ones that -backup put in this directory and exit. Stop the server first.")
+	timeControlF    = flag.String("time_control", "", `The default clock for matches: "move:30s", "total:5m+3s", "correspondence:72h" or "" for none.`)
+	resignPoints    = flag.String("resign_points", scopa.ForfeitAwards, `What the winner of a resigned, timed out or abandoned game scores: "awards" for every award, "counted" for the awards earned with the cards taken so far, or "scopas" for none.`)
+	abandonAfter    = flag.Duration("abandon_after", 2*time.Minute, "How long a game waits for a player that disconnected before they forfeit it, 0 waits forever.")
+	substituteAfter = flag.Duration("substitute_after", 0, "How long a game waits for a player that disconnected before a bot plays for them until they're back, instead of them forfeiting after -abandon_after. 0 turns it off.")
+
+	oidcIssuer       = flag.String("oidc_issuer", "", "Set this to an OpenID Connect issuer URL to allow logging in with it.")
+	oidcClientID     = flag.String("oidc_client_id", "", "The client ID registered with the OIDC issuer.")
+	oidcClientSecret = flag.String("oidc_client_secret", "", "The client secret registered with the OIDC issuer.")
+	oidcRedirectURL  = flag.String("oidc_redirect_url", "", "The /oidc/callback URL of this server, as registered with the OIDC issuer.")
+
+	// How often /events sends something to keep the connection open, tests shorten it.
+	sseHeartbeat = 15 * time.Second
+	// How often /join pings clients, and how long they have to send something back, tests shorten them.
+	wsHeartbeat, wsTimeout = protocol.HeartbeatInterval, protocol.HeartbeatTimeout
 
 	// Populated at compile time with `go build/run -ldflags "-X main.gitCommit=$(git rev-parse HEAD)"`
 	gitCommit string
@@ -33,26 +61,130 @@ func errorJSON(message string) string {
 }
 
 // /drop request content body json is marsheled into this struct.
+// The HTTP move endpoints are kept for clients that don't send commands over the websocket.
 type drop struct {
-	Player string
-	Card   scopa.Card
+	Token string
+	Card  scopa.Card
 }
 
 // /take request content body json is marshaled into this struct.
 type take struct {
-	Player string
-	Card   scopa.Card
-	Table  []scopa.Card
+	Token string
+	Card  scopa.Card
+	Table []scopa.Card
 }
 
 type player struct {
-	client chan struct{}
-	nick   string
+	conn  *connection
+	id    string // Account ID, or the nickname for guests.
+	nick  string
+	token string // Secret handed only to the client that holds this seat.
 }
 
 type server struct {
-	m  Match
-	sb scoreboard
+	m        Match // The open match, that anyone can join.
+	sb       *scoreboard
+	accounts *accounts
+	oidc     *oidcProvider // nil when OIDC login isn't configured.
+
+	tablesLock  sync.Mutex
+	tables      map[int64]*Match // Matches with reserved seats, by ID.
+	tokens      map[string]int64 // The IDs of the tables that seat tokens were handed out at, by token.
+	practicing  map[string]int64 // The IDs of the tables where players practice against the bot, by player ID.
+	tournaments *tournaments     // nil when tournaments aren't run.
+	dailies     *dailies         // nil when there are no daily challenges.
+}
+
+// guestID checks that a guest can play as nick, and returns the ID they play under.
+func (s *server) guestID(nick string) (string, error) {
+	switch {
+	case nick == "":
+		return "", fmt.Errorf("Nickname field needs to be set.")
+	case nick == botName || isAccountID(nick) || s.accounts.registered(nick):
+		return "", fmt.Errorf("%s is a registered player, log in to play as them.", nick)
+	}
+	return nick, nil
+}
+
+// match returns the match with id, which is the open match unless id is a table.
+func (s *server) match(id int64) *Match {
+	s.tablesLock.Lock()
+	defer s.tablesLock.Unlock()
+	if m, ok := s.tables[id]; ok {
+		return m
+	}
+	return &s.m
+}
+
+// matchFor returns the match that token has a seat in, which is the open match if it's no table's.
+func (s *server) matchFor(token string) *Match {
+	s.tablesLock.Lock()
+	defer s.tablesLock.Unlock()
+	if m, ok := s.tables[s.tokens[token]]; ok {
+		return m
+	}
+	return &s.m
+}
+
+// addTable starts a match that only the players with the given IDs can sit at, set up like m.
+// Callers must hold m's lock.
+func (s *server) addTable(m *Match, ids ...string) *Match {
+	s.tablesLock.Lock()
+	defer s.tablesLock.Unlock()
+	if s.tables == nil {
+		s.tables = make(map[int64]*Match)
+	}
+
+	// Table IDs can't collide with the open match's, which are in seconds.
+	id := time.Now().UnixNano() / int64(time.Millisecond)
+	for s.tables[id] != nil {
+		id++
+	}
+	t := &Match{
+		ID:       id,
+		reserved: ids,
+		clock:    m.clock,
+		ratings:  m.ratings,
+		stats:    m.stats,
+		archive:  m.archive,
+
+		control:        m.defaultControl,
+		defaultControl: m.defaultControl,
+
+		forfeitPoints:   m.forfeitPoints,
+		abandonAfter:    m.abandonAfter,
+		substituteAfter: m.substituteAfter,
+	}
+	t.onSeat = func(token string) {
+		s.tablesLock.Lock()
+		defer s.tablesLock.Unlock()
+		if s.tables[id] == nil {
+			return
+		}
+		if s.tokens == nil {
+			s.tokens = make(map[string]int64)
+		}
+		s.tokens[token] = id
+	}
+	s.tables[id] = t
+	return t
+}
+
+// removeTable forgets a table, players that are still connected to it can keep watching it.
+func (s *server) removeTable(id int64) {
+	s.tablesLock.Lock()
+	defer s.tablesLock.Unlock()
+	s.deleteTable(id)
+}
+
+// deleteTable forgets a table and the seat tokens handed out at it. Callers must hold the tables lock.
+func (s *server) deleteTable(id int64) {
+	delete(s.tables, id)
+	for token, t := range s.tokens {
+		if t == id {
+			delete(s.tokens, token)
+		}
+	}
 }
 
 func (s *server) debug(w http.ResponseWriter, r *http.Request) {
@@ -66,180 +198,339 @@ func (s *server) debug(w http.ResponseWriter, r *http.Request) {
 	defer s.m.Unlock()
 
 	io.WriteString(w, fmt.Sprintf("MatchID: %d\n", s.m.ID))
-	io.WriteString(w, fmt.Sprintf("Players: %#v\n", s.m.players))
+	for _, p := range s.m.players {
+		// Don't print the whole player, the seat token must stay secret.
+		io.WriteString(w, fmt.Sprintf("Player: %s\n", p.nick))
+	}
 	for _, n := range s.m.logs {
 		io.WriteString(w, n)
 		io.WriteString(w, "\n")
 	}
 }
 
-func (s *server) join(ws *websocket.Conn) {
-	match := &(s.m)
-	errorf := func(format string, a ...interface{}) {
-		io.WriteString(ws, errorJSON(fmt.Sprintf(format, a...)))
-		ws.Close()
+// seat takes the parameters of /join and /events from r, and seats the player. It returns the protocol
+// version to speak, and the last event that the client has seen or -1 if it's new.
+func (s *server) seat(r *http.Request) (p player, version, lastSeq int, err error) {
+	if version, err = protocol.Negotiate(r.FormValue("Version")); err != nil {
+		return player{}, 0, 0, err
 	}
 
-	var err error
-
 	matchID := int64(0)
-	if mid := ws.Request().FormValue("MatchID"); mid != "" && mid != "null" && mid != "undefined" {
+	if mid := r.FormValue("MatchID"); mid != "" && mid != "null" && mid != "undefined" {
 		if matchID, err = strconv.ParseInt(mid, 10, 64); err != nil {
-			errorf("MatchID has an invalid value: %s", err)
-			return
+			return player{}, 0, 0, fmt.Errorf("MatchID has an invalid value: %s", err)
 		}
 	}
 
-	nick := ws.Request().FormValue("Nickname")
-	if nick == "" {
-		errorf("Nickname field needs to be set.")
-		return
+	// Registered players always play under their username, guests pick a nickname.
+	var id, nick string
+	if acct := s.accounts.fromRequest(r); acct != nil {
+		id, nick = acct.ID, acct.Username
+	} else {
+		nick = r.FormValue("Nickname")
+		if id, err = s.guestID(nick); err != nil {
+			return player{}, 0, 0, err
+		}
 	}
 
-	updateChan, err := match.addPlayer(matchID, nick, s.sb)
-	if err != nil {
-		errorf("%s", err)
-		return
+	token := r.FormValue("Token")
+	if p, err = s.match(matchID).addPlayer(matchID, id, nick, token, s.sb); err != nil {
+		return player{}, 0, 0, err
 	}
 
-	m := struct {
-		MatchID int64
-	}{
-		match.ID,
-	}
-	if err := websocket.JSON.Send(ws, m); err != nil {
-		io.WriteString(ws, errorJSON("Failed to send the MatchID message."))
-		return
+	// Clients that resume their seat tell us the last event they saw, so they can be sent everything they missed.
+	lastSeq = -1
+	if p.token == token {
+		seq := r.FormValue("LastSeq")
+		if seq == "" {
+			seq = r.Header.Get("Last-Event-ID") // Set by browsers when an EventSource reconnects.
+		}
+		if lastSeq, err = strconv.Atoi(seq); err != nil {
+			lastSeq = -1
+		}
 	}
+	return p, version, lastSeq, nil
+}
+
+// stream sends everything that the player p should see with send: the game start message, and then every
+// event after lastSeq, with states encoded for the protocol version. It returns when send fails, quit is closed
+// or the seat is taken over.
+func (s *server) stream(p player, version, lastSeq int, quit <-chan struct{}, send func(protocol.ServerMessage) error) {
+	match := s.matchFor(p.token)
+	match.Lock()
+	gameStart := match.gameStart
+	match.Unlock()
+	defer func() {
+		match.Lock()
+		match.disconnected(p, s.sb)
+		match.Unlock()
+	}()
 
 	// Block until all players have joined and the game is ready to start.
-	<-match.gameStart
+	select {
+	case <-gameStart:
+	case <-p.conn.done:
+		return
+	case <-quit:
+		return
+	}
 
-	init := struct {
-		Nicknames map[int]string
-		Scorecard map[string]int
-	}{
-		make(map[int]string),
-		s.sb.scores(match.players[0].nick, match.players[1].nick),
+	match.Lock()
+	if seat := match.seat(p.token); seat != nil && seat.conn == p.conn {
+		match.setPresence(p.nick, protocol.Online)
 	}
-	for i, p := range match.players {
-		init.Nicknames[i+1] = p.nick
+	init := protocol.ServerMessage{ChatPresets: chatPresets}
+	init.Nicknames, init.Scorecard = match.lineup(s.sb)
+	presence := 0 // The presenceTurns that the client has seen.
+	if version >= 3 {
+		init.Presence, presence = match.presenceView(), match.presenceTurns
+	}
+	if match.ratings != nil {
+		init.Ratings = make(map[string]protocol.Rating)
+		for _, p := range match.players {
+			if r := match.ratings.view(p.id); r != nil {
+				init.Ratings[p.nick] = *r
+			}
+		}
 	}
-	if err := websocket.JSON.Send(ws, init); err != nil {
-		io.WriteString(ws, errorJSON("Failed to send the Nicknames/Scorecard messages."))
+	if lastSeq < 0 {
+		// New clients only need the latest state, and what happened after it.
+		lastSeq = match.latestState() - 1
+	}
+	match.Unlock()
+	if err := send(init); err != nil {
 		return
 	}
 
-	// Push the initial state, then keep pushing the full state with every change.
-	for {
+	var d deltas
+	sendEvent := func(e event) error {
+		msg := protocol.ServerMessage{Seq: e.Seq, Clock: e.clock, Chat: e.chat, Rematch: e.rematch, Proposal: e.proposal, Nicknames: e.nicknames, Scorecard: e.scorecard}
 		// Push the match state with nick's and redacted info.
-		if b, err := match.state.JSONForPlayer(nick); err == nil {
-			io.WriteString(ws, fmt.Sprintf(`{"State": %s}`, b))
-		} else {
-			io.WriteString(ws, errorJSON(fmt.Sprintf("state json send error: %#v", err)))
-			return
+		if st := e.state[p.nick]; st != nil {
+			if version < 2 {
+				msg.State = st
+			} else if err := d.encode(&msg, st); err != nil {
+				return err
+			}
 		}
+		return send(msg)
+	}
 
-		// Wait for an update...
-		<-updateChan
+	// Push every event that the client hasn't seen, then wait for more.
+	for {
+		match.Lock()
+		events := match.eventsSince(lastSeq)
+		var changed map[string]string
+		if version >= 3 && match.presenceTurns != presence {
+			changed, presence = match.presenceView(), match.presenceTurns
+		}
+		match.Unlock()
+
+		for _, e := range events {
+			if err := sendEvent(e); err != nil {
+				return
+			}
+			lastSeq = e.Seq
+		}
+		if changed != nil {
+			if err := send(protocol.ServerMessage{Presence: changed}); err != nil {
+				return
+			}
+		}
+
+		// Wait for an update, or for a newer connection to take over the seat.
+		select {
+		case <-p.conn.updates:
+		case <-p.conn.resync:
+			// Send the latest state again, in full.
+			d = deltas{}
+			match.Lock()
+			latest := match.latestState()
+			events := match.eventsSince(latest - 1)
+			match.Unlock()
+			if len(events) > 0 {
+				if err := sendEvent(event{Seq: latest, state: events[0].state, clock: events[0].clock}); err != nil {
+					return
+				}
+			}
+		case <-p.conn.done:
+			return
+		case <-quit:
+			return
+		}
 	}
 }
 
-func (s *server) drop(w http.ResponseWriter, r *http.Request) {
-	match := &(s.m)
+func (s *server) join(ws *websocket.Conn) {
+	p, version, lastSeq, err := s.seat(ws.Request())
+	if err != nil {
+		io.WriteString(ws, errorJSON(err.Error()))
+		ws.Close()
+		return
+	}
+
+	match := s.matchFor(p.token)
 	match.Lock()
-	defer match.Unlock()
+	m := protocol.ServerMessage{Version: version, MatchID: match.ID, Token: p.token}
+	match.Unlock()
+	if err := websocket.JSON.Send(ws, m); err != nil {
+		io.WriteString(ws, errorJSON("Failed to send the MatchID message."))
+		return
+	}
 
-	var d drop
-	if !parseRequestJSON(w, r, &d) {
+	// Stop streaming as soon as the client stops reading, or goes quiet.
+	quit := make(chan struct{})
+	go func() {
+		s.readMessages(ws, p.token, version)
+		close(quit)
+	}()
+	if version >= 3 {
+		go heartbeat(ws, quit)
+	}
+	s.stream(p, version, lastSeq, quit, func(m protocol.ServerMessage) error { return websocket.JSON.Send(ws, m) })
+}
+
+// events streams the match as Server-Sent Events, for clients behind proxies that break websockets. It takes the
+// same parameters as /join and sends the same messages. Events carry their Seq as the SSE id, so browsers resume
+// with Last-Event-ID. Moves and other commands go through /drop, /take and /command instead.
+func (s *server) events(w http.ResponseWriter, r *http.Request) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(500)
+		io.WriteString(w, errorJSON("Streaming isn't supported."))
 		return
 	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Asks proxies like nginx not to buffer the stream.
+
+	// The heartbeat and the stream both write to w.
+	var mu sync.Mutex
+	write := func(format string, a ...interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, err := fmt.Fprintf(w, format, a...); err != nil {
+			return err
+		}
+		f.Flush()
+		return nil
+	}
+	send := func(m protocol.ServerMessage) error {
+		b, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		if m.Seq > 0 {
+			return write("id: %d\ndata: %s\n\n", m.Seq, b)
+		}
+		return write("data: %s\n\n", b)
+	}
 
-	if d.Player != match.state.NextPlayer {
-		w.WriteHeader(400)
-		io.WriteString(w, errorJSON("Not your turn!"))
+	p, version, lastSeq, err := s.seat(r)
+	if err != nil {
+		// EventSource can't read error responses, so errors are sent as a message too.
+		send(protocol.ServerMessage{Message: err.Error()})
 		return
 	}
 
-	state := &match.state
-	match.logs = append(match.logs, fmt.Sprintf("state: %#v\n", state))
-	if err := state.Drop(d.Card); err != nil {
-		switch err.(type) {
-		case scopa.MoveError:
-			w.WriteHeader(400)
-		default:
-			w.WriteHeader(500)
-		}
-		io.WriteString(w, errorJSON(err.Error()))
-		match.logs = append(match.logs, fmt.Sprintf("FAIL drop: %#v, %#v\n", d.Card, err))
+	match := s.matchFor(p.token)
+	match.Lock()
+	m := protocol.ServerMessage{Version: version, MatchID: match.ID, Token: p.token}
+	match.Unlock()
+	if err := send(m); err != nil {
 		return
 	}
-	match.logs = append(match.logs, fmt.Sprintf("drop: %#v\n", d.Card))
-	match.endTurn(s.sb)
-	s.sb.save(*scoreboardFile)
+
+	// Comments keep idle proxies from hanging up.
+	quit := r.Context().Done()
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-heartbeat.C:
+				write(": ping\n\n")
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	s.stream(p, version, lastSeq, quit, send)
+	close(stop)
+	wg.Wait()
 }
 
-// Reset the match, no qustions asked, power users only...
-func (s *server) reset(w http.ResponseWriter, r *http.Request) {
-	s.m.Reset(time.Now().Unix())
+// command is the HTTP version of sending a protocol.Command over the websocket, for clients streaming /events.
+func (s *server) command(w http.ResponseWriter, r *http.Request) {
+	var c struct {
+		Token   string
+		Command protocol.Command
+	}
+	if !parseRequestJSON(w, r, &c) {
+		return
+	}
+
+	a, err := s.handle(c.Token, c.Command)
+	if err != nil {
+		w.WriteHeader(statusCode(err))
+		io.WriteString(w, errorJSON(err.Error()))
+		return
+	}
+	json.NewEncoder(w).Encode(a)
 }
 
-// This will create a new match if one hasn't already been created.
-func (s *server) newMatch(w http.ResponseWriter, r *http.Request) {
-	match := &(s.m)
-	p := struct{ OldMatchID int64 }{}
-	if !parseRequestJSON(w, r, &p) {
+// drop is the HTTP version of the "drop" command.
+func (s *server) drop(w http.ResponseWriter, r *http.Request) {
+	var d drop
+	if !parseRequestJSON(w, r, &d) {
 		return
 	}
 
+	match := s.matchFor(d.Token)
 	match.Lock()
 	defer match.Unlock()
-
-	if p.OldMatchID == match.ID {
-		match.Reset(time.Now().Unix())
+	if err := match.drop(d.Token, d.Card, s.sb); err != nil {
+		w.WriteHeader(statusCode(err))
+		io.WriteString(w, errorJSON(err.Error()))
 	}
 }
 
+// Reset the match, no qustions asked, power users only...
+func (s *server) reset(w http.ResponseWriter, r *http.Request) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.m.Reset(time.Now().Unix())
+}
+
+// matchID serves /matchID?MatchID=123, the ID of the match to join: the one asked for if it's a table, and the open
+// match otherwise.
 func (s *server) matchID(w http.ResponseWriter, r *http.Request) {
-	match := &(s.m)
+	id, _ := strconv.ParseInt(r.FormValue("MatchID"), 10, 64)
+	match := s.match(id)
 	match.Lock()
 	defer match.Unlock()
 	io.WriteString(w, fmt.Sprintf(`{"MatchID": %d}`, match.ID))
 }
 
+// take is the HTTP version of the "take" command.
 func (s *server) take(w http.ResponseWriter, r *http.Request) {
-	match := &(s.m)
-	match.Lock()
-	defer match.Unlock()
-
 	var t take
 	if !parseRequestJSON(w, r, &t) {
 		return
 	}
 
-	if t.Player != match.state.NextPlayer {
-		w.WriteHeader(400)
-		io.WriteString(w, errorJSON("Not your turn!"))
-		return
-	}
-
-	state := &match.state
-	match.logs = append(match.logs, fmt.Sprintf("state: %#v\n", state))
-	if err := state.Take(t.Card, t.Table); err != nil {
-		switch err.(type) {
-		case scopa.MoveError:
-			w.WriteHeader(400)
-		default:
-			w.WriteHeader(500)
-		}
+	match := s.matchFor(t.Token)
+	match.Lock()
+	defer match.Unlock()
+	if err := match.take(t.Token, t.Card, t.Table, s.sb); err != nil {
+		w.WriteHeader(statusCode(err))
 		io.WriteString(w, errorJSON(err.Error()))
-		match.logs = append(match.logs, fmt.Sprintf("FAIL take: %#v, %#v, %#v\n", t.Card, t.Table, err))
-		match.logs = append(match.logs, fmt.Sprintf("state: %#v\n", match.state))
-		return
 	}
-
-	match.logs = append(match.logs, fmt.Sprintf("take: %#v, %#v\n", t.Card, t.Table))
-	match.endTurn(s.sb)
 }
 
 func main() {
@@ -248,24 +539,102 @@ func main() {
 		flag.PrintDefaults()
 	}
 	flag.Parse()
+	tc, err := parseTimeControl(*timeControlF)
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch *resignPoints {
+	case scopa.ForfeitAwards, scopa.ForfeitCounted, scopa.ForfeitScopas:
+	default:
+		log.Fatalf("-resign_points should be %s, %s or %s, not %q", scopa.ForfeitAwards, scopa.ForfeitCounted, scopa.ForfeitScopas, *resignPoints)
+	}
 	if *random {
 		rand.Seed(time.Now().Unix())
 	}
 
+	scoreboardStore := store.Open(*scoreboardFile, 0644, scoreboardMigrations...)
+	accountsStore := store.Open(*accountsFile, 0600, accountsMigrations...)
+	ratingsStore := store.Open(*ratingsFile, 0644, ratingsMigrations...)
+	statsStore := store.Open(*statsFile, 0644, statsMigrations...)
+	archiveStore := store.Open(*archiveFile, 0644, archiveMigrations...)
+	tournamentsStore := store.Open(*tournamentsFile, 0644, tournamentsMigrations...)
+	dailyStore := store.Open(*dailyFile, 0644, dailiesMigrations...)
+	if *backupDir != "" {
+		if err := store.Backup(*backupDir, scoreboardStore, accountsStore, ratingsStore, statsStore, archiveStore, tournamentsStore, dailyStore); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *restoreDir != "" {
+		if err := store.Restore(*restoreDir, scoreboardStore, accountsStore, ratingsStore, statsStore, archiveStore, tournamentsStore, dailyStore); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	s := server{
-		m:  Match{ID: time.Now().Unix()},
-		sb: loadScoreboard(*scoreboardFile),
+		m: Match{
+			ID:              time.Now().Unix(),
+			control:         tc,
+			defaultControl:  tc,
+			forfeitPoints:   *resignPoints,
+			abandonAfter:    *abandonAfter,
+			substituteAfter: *substituteAfter,
+		},
+		sb:       loadScoreboard(scoreboardStore),
+		accounts: loadAccounts(accountsStore),
+	}
+	s.m.ratings = loadRatings(ratingsStore, s.sb)
+	s.m.stats = loadStats(statsStore)
+	s.m.archive = loadArchive(archiveStore)
+	s.tournaments = loadTournaments(tournamentsStore)
+	s.dailies = loadDailies(dailyStore)
+	s.m.Lock()
+	s.resumeTournaments(&s.m)
+	s.m.Unlock()
+	if *oidcIssuer != "" {
+		o, err := newOIDCProvider(http.DefaultClient, *oidcIssuer, *oidcClientID, *oidcClientSecret, *oidcRedirectURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.oidc = o
 	}
 
 	// Serve resources.
 	http.Handle("/", http.FileServer(http.Dir("./web")))
 	http.Handle("/join", websocket.Handler(s.join))
+	http.HandleFunc("/events", s.events)
+	http.HandleFunc("/command", s.command)
 	http.HandleFunc("/debug", s.debug)
 	http.HandleFunc("/drop", s.drop)
 	http.HandleFunc("/take", s.take)
 	http.HandleFunc("/matchID", s.matchID)
-	http.HandleFunc("/newMatch", s.newMatch)
 	http.HandleFunc("/reset", s.reset)
+	http.HandleFunc("/register", s.register)
+	http.HandleFunc("/login", s.login)
+	http.HandleFunc("/logout", s.logout)
+	http.HandleFunc("/account", s.account)
+	http.HandleFunc("/ratings/", s.rating)
+	http.HandleFunc("/leaderboard", s.leaderboard)
+	http.HandleFunc("/players/", s.player)
+	http.HandleFunc("/stats/", s.lifetimeStats)
+	http.HandleFunc("/archive", s.archived)
+	http.HandleFunc("/archive/", s.archived)
+	http.HandleFunc("/tournaments", s.tournament)
+	http.HandleFunc("/tournaments/", s.tournament)
+	http.HandleFunc("/daily", s.daily)
+	http.HandleFunc("/daily/", s.daily)
+	http.HandleFunc("/bot", s.practice)
+	http.HandleFunc("/oidc/login", s.oidcLogin)
+	http.HandleFunc("/oidc/callback", s.oidcCallback)
+
+	if *tcpPort != 0 {
+		l, err := net.Listen("tcp", ":"+strconv.Itoa(*tcpPort))
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() { log.Fatal(s.serveTCP(l)) }()
+	}
 
 	if *httpsHost != "" {
 		// Still create an http server, but make it always redirect to https
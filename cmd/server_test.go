@@ -1,52 +1,478 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
 	"github.com/google/go-cmp/cmp"
+	"github.com/sbadame/scopa/client"
+	"github.com/sbadame/scopa/protocol"
+	"github.com/sbadame/scopa/scopa"
+	"github.com/sbadame/scopa/store"
+	"golang.org/x/net/websocket"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 )
 
+// tempStore returns a store for a document called name in a temp directory, which is removed when the test is done.
+func tempStore(t *testing.T, name string, migrations ...store.Migration) *store.File {
+	dir, err := ioutil.TempDir("", "test"+name)
+	if err != nil {
+		t.Fatalf("Couldn't create a temp directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return store.Open(filepath.Join(dir, name+".json"), 0600, migrations...)
+}
+
+// tempScoreboard returns an empty scoreboard that is saved to a temp file.
+func tempScoreboard(t *testing.T) *scoreboard {
+	return loadScoreboard(tempStore(t, "scoreboard", scoreboardMigrations...))
+}
+
 func TestMatch(t *testing.T) {
 	m := Match{}
-	sb := make(scoreboard)
-	if _, err := m.addPlayer(-1, "a", sb); err != nil {
+	sb := tempScoreboard(t)
+	if _, err := m.addPlayer(-1, "a", "a", "", sb); err != nil {
 		t.Errorf("Couldn't join a match: %v", err)
 	}
 
-	if _, err := m.addPlayer(-1, "b", sb); err != nil {
+	if _, err := m.addPlayer(-1, "b", "b", "", sb); err != nil {
 		t.Errorf("Couldn't join a match: %v", err)
 	}
 
 }
 
-func TestScoreboard(t *testing.T) {
-	f, err := ioutil.TempFile("", "testscoreboard")
+func TestSeatTokens(t *testing.T) {
+	s := server{m: Match{ID: 1}, sb: tempScoreboard(t)}
+	a, err := s.m.addPlayer(1, "a", "a", "", s.sb)
+	if err != nil {
+		t.Fatalf("Couldn't join a match: %v", err)
+	}
+	b, err := s.m.addPlayer(1, "b", "b", "", s.sb)
 	if err != nil {
-		t.Errorf("Couldn't create a tempfile.")
+		t.Fatalf("Couldn't join a match: %v", err)
+	}
+	if a.token == "" || a.token == b.token {
+		t.Fatalf("Expected distinct seat tokens but got %q and %q", a.token, b.token)
+	}
+
+	// Reconnecting needs the token, the nickname alone isn't enough.
+	if _, err := s.m.addPlayer(1, "a", "a", "guess", s.sb); err == nil {
+		t.Errorf("Expected a reconnect with the wrong token to fail.")
+	}
+	if p, err := s.m.addPlayer(1, "ignored", "ignored", a.token, s.sb); err != nil || p.nick != "a" {
+		t.Errorf("Expected to reconnect as 'a' but got %q, %v", p.nick, err)
 	}
 
-	sb := make(scoreboard)
+	post := func(token string) int {
+		card := s.m.state.Players[0].Hand[0]
+		body := `{"Token": "` + token + `", "Card": {"Suit": "` + string(card.Suit) + `", "Value": ` + strconv.Itoa(card.Value) + `}}`
+		r := httptest.NewRequest("POST", "/drop", strings.NewReader(body))
+		r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		w := httptest.NewRecorder()
+		s.drop(w, r)
+		return w.Code
+	}
 
-	sb.record("a", "b", 2, 5)
+	first, second := a, b
+	if s.m.state.NextPlayer != a.nick {
+		first, second = b, a
+	}
+	if c := post("not-a-token"); c != 403 {
+		t.Errorf("Expected a drop with an unknown token to be forbidden, got %d", c)
+	}
+	if c := post(second.token); c != 400 {
+		t.Errorf("Expected a drop out of turn to be rejected, got %d", c)
+	}
+	if c := post(first.token); c != 200 {
+		t.Errorf("Expected a drop with the right token to succeed, got %d", c)
+	}
+}
+
+func TestScoreboard(t *testing.T) {
+	sb := tempScoreboard(t)
+
+	sb.record("a", "b", 2, 5, "")
 	if np := sb.nextPlayer("a", "b"); np != "b" {
 		t.Errorf("1 Expected the nextPlayer to be 'b' but was '%s'.", np)
 	}
 
-	sb.record("a", "b", 2, 5)
+	sb.record("a", "b", 2, 5, "")
 	if np := sb.nextPlayer("a", "b"); np != "a" {
 		t.Errorf("2 Expected the nextPlayer to be 'a' but was '%s'.", np)
 	}
 
-	sb.save(f.Name())
-
-	loaded := loadScoreboard(f.Name())
-
 	if d := cmp.Diff(sb.scores("a", "b"), map[string]int{"a": 4, "b": 10}); d != "" {
 		t.Errorf("mismatch (-got, +wanted):\n%s", d)
 	}
 
-	if d := cmp.Diff(sb, loaded); d != "" {
+	// The games are in the journal until the whole scoreboard is saved.
+	journaled := loadScoreboard(sb.store)
+	if d := cmp.Diff(sb.cards, journaled.cards); d != "" {
+		t.Errorf("mismatch (-recorded, +journaled):\n%s", d)
+	}
+
+	sb.Lock()
+	sb.save()
+	sb.Unlock()
+	sb.record("c", "a", 1, 0, "")
+	loaded := loadScoreboard(sb.store)
+	if d := cmp.Diff(sb.cards, loaded.cards); d != "" {
 		t.Errorf("mismatch (-saved, +loaded):\n%s", d)
 	}
+}
+
+func TestReconnect(t *testing.T) {
+	m := Match{ID: 1}
+	sb := tempScoreboard(t)
+	a, err := m.addPlayer(1, "a", "a", "", sb)
+	if err != nil {
+		t.Fatalf("Couldn't join a match: %v", err)
+	}
+	if _, err := m.addPlayer(1, "b", "b", "", sb); err != nil {
+		t.Fatalf("Couldn't join a match: %v", err)
+	}
+
+	// Starting the game is the first event.
+	if got := len(m.eventsSince(0)); got != 1 {
+		t.Fatalf("Expected 1 event after the game started, got %d", got)
+	}
+
+	r, err := m.addPlayer(1, "a", "a", a.token, sb)
+	if err != nil {
+		t.Fatalf("Couldn't reconnect: %v", err)
+	}
+	select {
+	case <-a.conn.done:
+	default:
+		t.Errorf("Expected the old connection to be closed when reconnecting.")
+	}
+
+	// The new connection gets updates, and can catch up on what it missed.
+	m.state.Drop(m.state.Players[0].Hand[0])
+	m.endTurn(sb)
+	select {
+	case <-r.conn.updates:
+	default:
+		t.Errorf("Expected the new connection to be notified.")
+	}
+	events := m.eventsSince(1)
+	if len(events) != 1 || events[0].Seq != 2 {
+		t.Fatalf("Expected to catch up on event 2, but got %v", events)
+	}
+	if events[0].state["a"].LastMove.Drop == nil {
+		t.Errorf("Expected the missed event to contain the drop: %#v", events[0].state["a"])
+	}
+
+	m.Reset(2)
+	select {
+	case <-r.conn.done:
+	default:
+		t.Errorf("Expected resetting the match to close the connections.")
+	}
+}
+
+// dial joins the server as nick over the websocket.
+func dial(t *testing.T, ts *httptest.Server, nick string) *websocket.Conn {
+	u := "ws" + strings.TrimPrefix(ts.URL, "http") + "/join?Nickname=" + nick
+	ws, err := websocket.Dial(u, "", ts.URL)
+	if err != nil {
+		t.Fatalf("Couldn't join as %s: %v", nick, err)
+	}
+	return ws
+}
+
+// receive reads messages from ws until one has the key.
+func receive(t *testing.T, ws *websocket.Conn, key string) json.RawMessage {
+	for {
+		var msg map[string]json.RawMessage
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			t.Fatalf("Waiting for %s: %v", key, err)
+		}
+		if v, ok := msg[key]; ok {
+			return v
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	s := &server{m: Match{ID: 1}, sb: tempScoreboard(t), accounts: tempAccounts(t)}
+	ts := httptest.NewServer(websocket.Handler(s.join))
+	defer ts.Close()
+
+	a, b := dial(t, ts, "a"), dial(t, ts, "b")
+	defer a.Close()
+	defer b.Close()
+	receive(t, a, "State")
+	receive(t, b, "State")
+
+	s.m.Lock()
+	first, card := a, s.m.state.Players[0].Hand[0]
+	if s.m.state.NextPlayer != "a" {
+		first = b
+	}
+	s.m.Unlock()
+
+	websocket.JSON.Send(first, protocol.Command{ID: "bad", Type: "take", Card: &card, Table: []scopa.Card{{Suit: scopa.Spade, Value: 11}}})
+	var e protocol.Error
+	json.Unmarshal(receive(t, first, "Error"), &e)
+	if e.ID != "bad" || e.Code != 400 {
+		t.Errorf("Expected a 400 error for the request 'bad', got %#v", e)
+	}
+
+	websocket.JSON.Send(first, protocol.Command{ID: "good", Type: "drop", Card: &card})
+	var got protocol.Ack
+	json.Unmarshal(receive(t, first, "Ack"), &got)
+	if d := cmp.Diff(protocol.Ack{ID: "good", Seq: 2}, got); d != "" {
+		t.Errorf("mismatch ack (-want +got):\n%s", d)
+	}
+
+	websocket.JSON.Send(first, protocol.Command{ID: "p", Type: "ping"})
+	if json.Unmarshal(receive(t, first, "Ack"), &got); got.ID != "p" {
+		t.Errorf("Expected the ping to be acknowledged, got %#v", got)
+	}
+}
+
+func TestClient(t *testing.T) {
+	s := &server{m: Match{ID: 1}, sb: tempScoreboard(t), accounts: tempAccounts(t)}
+	ts := httptest.NewServer(websocket.Handler(s.join))
+	defer ts.Close()
+
+	a, err := client.Dial(ts.URL, "a", nil)
+	if err != nil {
+		t.Fatalf("Couldn't join as a: %v", err)
+	}
+	defer a.Close()
+	b, err := client.Dial(ts.URL, "b", nil)
+	if err != nil {
+		t.Fatalf("Couldn't join as b: %v", err)
+	}
+	defer b.Close()
+	if a.Version != protocol.Version || a.MatchID != 1 || a.Token == "" {
+		t.Errorf("Unexpected seat: %#v", a)
+	}
 
+	// Wait for the first state.
+	var state *protocol.State
+	for state == nil {
+		m, err := a.Next()
+		if err != nil {
+			t.Fatalf("Waiting for the state: %v", err)
+		}
+		state = m.State
+	}
+	if len(state.Player.Hand) != 3 {
+		t.Fatalf("Expected a hand of 3, got %v", state.Player.Hand)
+	}
+
+	first, second := a, b
+	if state.NextPlayer != "a" {
+		first, second = b, a
+	}
+	s.m.Lock()
+	card := s.m.state.Players[0].Hand[0]
+	if s.m.state.Players[0].Name != first.Nickname {
+		card = s.m.state.Players[1].Hand[0]
+	}
+	s.m.Unlock()
+
+	var e *protocol.Error
+	if err := second.Drop(card); !errors.As(err, &e) || e.Code != 400 {
+		t.Errorf("Expected a 400 error for moving out of turn, got %v", err)
+	}
+	if err := first.Drop(card); err != nil {
+		t.Errorf("Couldn't drop %v: %v", card, err)
+	}
+	if err := first.Ping(); err != nil {
+		t.Errorf("Ping failed: %v", err)
+	}
+
+	for {
+		m, err := second.Next()
+		if err != nil {
+			t.Fatalf("Waiting for the drop: %v", err)
+		}
+		if m.State != nil && m.State.LastMove.Drop != nil {
+			if d := cmp.Diff(card, m.State.LastMove.Drop.Card); d != "" {
+				t.Errorf("mismatch dropped card (-want +got):\n%s", d)
+			}
+			break
+		}
+	}
+	if second.LastSeq() != 2 {
+		t.Errorf("Expected to have seen event 2, got %d", second.LastSeq())
+	}
+}
+
+// sse reads Server-Sent Events from a response.
+type sse struct {
+	t *testing.T
+	r *bufio.Reader
+}
+
+// next returns the next event's id (0 if it has none) and message, skipping comments.
+func (s sse) next() (int, protocol.ServerMessage) {
+	id := 0
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			s.t.Fatalf("Reading the event stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id, _ = strconv.Atoi(strings.TrimPrefix(line, "id: "))
+		case strings.HasPrefix(line, "data: "):
+			var m protocol.ServerMessage
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &m); err != nil {
+				s.t.Fatalf("Couldn't parse %q: %v", line, err)
+			}
+			return id, m
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	s := &server{m: Match{ID: 1}, sb: tempScoreboard(t), accounts: tempAccounts(t)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", s.events)
+	mux.HandleFunc("/command", s.command)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	listen := func(query string, header http.Header) (sse, func()) {
+		req, err := http.NewRequest("GET", ts.URL+"/events?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET /events?%s failed: %v", query, err)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Errorf("Expected an event stream, got %s", ct)
+		}
+		return sse{t, bufio.NewReader(resp.Body)}, func() { resp.Body.Close() }
+	}
+
+	a, closeA := listen("Nickname=a", nil)
+	_, seat := a.next()
+	if seat.Token == "" || seat.MatchID != 1 || seat.Version != 1 {
+		t.Fatalf("Expected a seat, got %#v", seat)
+	}
+	b, closeB := listen("Nickname=b", nil)
+	defer closeB()
+	b.next()
+
+	if _, init := a.next(); init.Nicknames[1] == "" {
+		t.Errorf("Expected the nicknames once the game started, got %#v", init)
+	}
+	if id, m := a.next(); id != 1 || m.State == nil {
+		t.Errorf("Expected the state as event 1, got %d: %#v", id, m)
+	}
+
+	// a hangs up and chats over HTTP, then catches up from where they left off.
+	closeA()
+	body := `{"Token": "` + seat.Token + `", "Command": {"Type": "chat", "Text": "ciao"}}`
+	req := httptest.NewRequest("POST", "/command", strings.NewReader(body))
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	w := httptest.NewRecorder()
+	s.command(w, req)
+	var ack protocol.Ack
+	json.Unmarshal(w.Body.Bytes(), &ack)
+	if w.Code != 200 || ack.Seq != 2 {
+		t.Errorf("Expected chatting to be acknowledged as event 2, got %d: %s", w.Code, w.Body)
+	}
+
+	body = `{"Token": "` + seat.Token + `", "Command": {"Type": "resign"}}`
+	req = httptest.NewRequest("POST", "/command", strings.NewReader(body))
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	w = httptest.NewRecorder()
+	s.command(w, req)
+	if w.Code != 200 {
+		t.Errorf("Expected resigning to work, got %d: %s", w.Code, w.Body)
+	}
+
+	a, closeA = listen("Nickname=a&MatchID=1&Token="+seat.Token, http.Header{"Last-Event-ID": {"1"}})
+	defer closeA()
+	a.next() // The seat.
+	a.next() // The nicknames.
+	if id, m := a.next(); id != 2 || m.Chat == nil || m.Chat.Text != "ciao" {
+		t.Errorf("Expected to catch up on the chat as event 2, got %d: %#v", id, m)
+	}
+	if id, m := a.next(); id != 3 || m.State == nil || m.State.Forfeited != "a" {
+		t.Errorf("Expected to catch up on the resignation as event 3, got %d: %#v", id, m)
+	}
+}
+
+func TestDeltas(t *testing.T) {
+	s := &server{m: Match{ID: 1}, sb: tempScoreboard(t), accounts: tempAccounts(t)}
+	ts := httptest.NewServer(websocket.Handler(s.join))
+	defer ts.Close()
+
+	u := "ws" + strings.TrimPrefix(ts.URL, "http") + "/join?Version=2&Nickname="
+	a, err := websocket.Dial(u+"a", "", ts.URL)
+	if err != nil {
+		t.Fatalf("Couldn't join as a: %v", err)
+	}
+	defer a.Close()
+	b := dial(t, ts, "b") // Speaks version 1.
+	defer b.Close()
+
+	var first protocol.State
+	json.Unmarshal(receive(t, a, "State"), &first)
+
+	s.m.Lock()
+	card, token := s.m.state.Players[0].Hand[0], s.m.players[0].token
+	if s.m.state.NextPlayer != s.m.state.Players[0].Name {
+		card = s.m.state.Players[1].Hand[0]
+	}
+	if s.m.state.NextPlayer != s.m.players[0].nick {
+		token = s.m.players[1].token
+	}
+	if err := s.m.drop(token, card, s.sb); err != nil {
+		t.Fatalf("Couldn't drop: %v", err)
+	}
+	s.m.Unlock()
+
+	// The drop arrives as a patch against the first state.
+	var m protocol.ServerMessage
+	if err := websocket.JSON.Receive(a, &m); err != nil {
+		t.Fatalf("Waiting for the drop: %v", err)
+	}
+	if m.State != nil || m.Base != 1 || len(m.Patch) == 0 {
+		t.Fatalf("Expected a patch against event 1, got %#v", m)
+	}
+	before, _ := json.Marshal(first)
+	after, err := protocol.Apply(before, m.Patch)
+	if err != nil {
+		t.Fatalf("Couldn't apply %v: %v", m.Patch, err)
+	}
+	var got protocol.State
+	json.Unmarshal(after, &got)
+	if got.LastMove.Drop == nil || got.LastMove.Drop.Card != card {
+		t.Errorf("Expected the patched state to have dropped %v, got %#v", card, got.LastMove.Drop)
+	}
+
+	// Version 1 clients get it in full.
+	if !strings.Contains(string(receive(t, b, "State")), "Drop") {
+		t.Errorf("Expected the version 1 client to get the drop in full.")
+	}
+
+	// Asking to resync sends the latest state in full.
+	websocket.JSON.Send(a, protocol.Command{ID: "r", Type: protocol.ResyncCommand})
+	var resync protocol.State
+	json.Unmarshal(receive(t, a, "State"), &resync)
+	if d := cmp.Diff(got, resync); d != "" {
+		t.Errorf("mismatch resync (-want +got):\n%s", d)
+	}
 }
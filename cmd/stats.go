@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"github.com/sbadame/scopa/store"
+	"sync"
+	"time"
+)
+
+// maxRecent is how many of a player's games are kept in their recent games.
+const maxRecent = 20
+
+// stats are the lifetime totals of every player that has finished a game, by account ID or guest nickname.
+type stats struct {
+	sync.Mutex
+	store   store.Store
+	Players map[string]*playerStats
+}
+
+type playerStats struct {
+	Games        int
+	Wins         int
+	Losses       int
+	Ties         int
+	PartitasWon  int
+	PartitasLost int
+	Points       int
+	Scopas       int
+
+	// Games played to the last card, which the awards are counted in. Whoever forfeits gives every award away
+	// without any cards being taken, so forfeited and stopped games say nothing about who takes what.
+	PlayedOut int
+
+	// Games played out in which they won each of the awards.
+	Settebellos int
+	Primieras   int
+	Cards       int
+	Denari      int
+
+	// Games and wins when they moved first, and when they moved second.
+	FirstGames  int
+	FirstWins   int
+	SecondGames int
+	SecondWins  int
+
+	Recent []gameResult // Newest last.
+}
+
+// gameResult is a finished game from one player's point of view.
+type gameResult struct {
+	Time           time.Time
+	Opponent       string  // Account ID or guest nickname.
+	Score          float64 // 1 for a win, 0.5 for a tie and 0 for a loss.
+	Points         int
+	OpponentPoints int
+	Forfeited      bool   `json:",omitempty"` // Whether the game ended early because either player forfeited.
+	Ending         string `json:",omitempty"` // How the game ended early, see protocol.State.Ending.
+}
+
+// statsMigrations bring older stats files up to date, see store.Open.
+var statsMigrations = []store.Migration{store.Unchanged}
+
+func loadStats(st store.Store) *stats {
+	s := &stats{store: st, Players: make(map[string]*playerStats)}
+	if err := st.Load(s, nil); err != nil {
+		fmt.Printf("Couldn't read the stats, %v\n", err)
+	}
+	return s
+}
+
+// save writes the stats to disk, callers must hold the lock.
+func (s *stats) save() {
+	if err := s.store.Save(s); err != nil {
+		fmt.Printf("Couldn't save the stats: %v\n", err)
+	}
+}
+
+// record adds the game that just ended in m to the stats of both players, along with the partita if it finished
+// one. partita is the ID of its winner, or "". Callers must hold the match lock.
+func (s *stats) record(m *Match, partita string) {
+	s.Lock()
+	defer s.Unlock()
+
+	score := m.outcome()
+	for i, p := range m.players {
+		me, opp := m.state.Players[i], m.state.Players[1-i]
+		ps, ok := s.Players[p.id]
+		if !ok {
+			ps = &playerStats{}
+			s.Players[p.id] = ps
+		}
+
+		r := gameResult{
+			Time:           m.now(),
+			Opponent:       m.players[1-i].id,
+			Score:          score,
+			Points:         len(me.Awards) + me.Scopas,
+			OpponentPoints: len(opp.Awards) + opp.Scopas,
+			Forfeited:      m.state.Forfeited != "",
+			Ending:         m.ending,
+		}
+		if i == 1 {
+			r.Score = 1 - score
+		}
+
+		ps.Games++
+		switch r.Score {
+		case 1:
+			ps.Wins++
+		case 0:
+			ps.Losses++
+		default:
+			ps.Ties++
+		}
+		ps.Points += r.Points
+		ps.Scopas += me.Scopas
+		if m.state.Forfeited == "" && m.state.Stopped == "" {
+			ps.PlayedOut++
+			for _, a := range me.Awards {
+				switch a {
+				case "SetteBello":
+					ps.Settebellos++
+				case "Primera":
+					ps.Primieras++
+				case "Cards":
+					ps.Cards++
+				case "Denari":
+					ps.Denari++
+				}
+			}
+		}
+
+		// The first player to join the match is the first to move.
+		won := 0
+		if r.Score == 1 {
+			won = 1
+		}
+		if i == 0 {
+			ps.FirstGames++
+			ps.FirstWins += won
+		} else {
+			ps.SecondGames++
+			ps.SecondWins += won
+		}
+
+		switch partita {
+		case "":
+		case p.id:
+			ps.PartitasWon++
+		default:
+			ps.PartitasLost++
+		}
+		ps.Recent = append(ps.Recent, r)
+		if len(ps.Recent) > maxRecent {
+			ps.Recent = ps.Recent[len(ps.Recent)-maxRecent:]
+		}
+	}
+	s.save()
+}
+
+// lifetime is a player's stats as shown by the API, as averages and rates.
+type lifetime struct {
+	Games        int
+	Wins         int
+	Losses       int
+	Ties         int
+	PartitasWon  int
+	PartitasLost int
+
+	PointsPerGame float64
+	ScopasPerGame float64
+
+	// The share of games played to the last card in which they won each of the awards.
+	SettebelloRate float64
+	PrimieraRate   float64
+	CardsRate      float64
+	DenariRate     float64
+
+	// The share of games won when moving first, and when moving second.
+	FirstPlayerWinRate  float64
+	SecondPlayerWinRate float64
+}
+
+func rate(n, d int) float64 {
+	if d == 0 {
+		return 0
+	}
+	return float64(n) / float64(d)
+}
+
+func (p *playerStats) lifetime() lifetime {
+	return lifetime{
+		Games:               p.Games,
+		Wins:                p.Wins,
+		Losses:              p.Losses,
+		Ties:                p.Ties,
+		PartitasWon:         p.PartitasWon,
+		PartitasLost:        p.PartitasLost,
+		PointsPerGame:       rate(p.Points, p.Games),
+		ScopasPerGame:       rate(p.Scopas, p.Games),
+		SettebelloRate:      rate(p.Settebellos, p.PlayedOut),
+		PrimieraRate:        rate(p.Primieras, p.PlayedOut),
+		CardsRate:           rate(p.Cards, p.PlayedOut),
+		DenariRate:          rate(p.Denari, p.PlayedOut),
+		FirstPlayerWinRate:  rate(p.FirstWins, p.FirstGames),
+		SecondPlayerWinRate: rate(p.SecondWins, p.SecondGames),
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/google/go-cmp/cmp"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPartita(t *testing.T) {
+	sb := tempScoreboard(t)
+	for i, want := range []string{"", "", "a"} {
+		if got := sb.record("a", "b", 5, 3, ""); got != want {
+			t.Errorf("Game %d: expected the partita winner to be %q, got %q", i, want, got)
+		}
+	}
+	if got := sb.record("a", "b", 11, 11, ""); got != "" {
+		t.Errorf("Expected a tie at 11 to keep the partita going, got %q", got)
+	}
+	if got := sb.record("b", "a", 1, 0, ""); got != "b" {
+		t.Errorf("Expected b to win the partita 12 to 11, got %q", got)
+	}
+}
+
+func TestLifetimeStats(t *testing.T) {
+	s := &server{m: Match{ID: 1}, sb: tempScoreboard(t), accounts: tempAccounts(t)}
+	s.m.stats = tempStats(t)
+	// The scoreboard takes turns at who moves first. Whoever forfeits gives every award to the other, but nobody took
+	// the settebello so it doesn't count.
+	resignGame(t, s, "a", "b", "b") // a moves first and wins.
+	resignGame(t, s, "a", "b", "b") // a moves second and wins.
+	resignGame(t, s, "a", "b", "a") // a moves first and loses.
+
+	get := func(url string, v interface{}) int {
+		w := httptest.NewRecorder()
+		s.lifetimeStats(w, httptest.NewRequest("GET", url, nil))
+		json.Unmarshal(w.Body.Bytes(), v)
+		return w.Code
+	}
+
+	var got lifetime
+	if code := get("/stats/a", &got); code != 200 {
+		t.Fatalf("Couldn't get a's stats: %d", code)
+	}
+	want := lifetime{
+		Games:               3,
+		Wins:                2,
+		Losses:              1,
+		PointsPerGame:       8.0 / 3,
+		FirstPlayerWinRate:  0.5,
+		SecondPlayerWinRate: 1,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("lifetime stats mismatch (-want +got):\n%s", diff)
+	}
+	if code := get("/stats/nobody", &got); code != 404 {
+		t.Errorf("Expected a 404 for a player without games, got %d", code)
+	}
+
+	// The awards of a game played to the end do count.
+	playGame(t, s, "a", "b")
+	awards := make(map[string]float64)
+	for _, p := range s.m.state.Players {
+		if p.Name == "a" {
+			for _, a := range p.Awards {
+				awards[a] = 1
+			}
+		}
+	}
+	if code := get("/stats/a", &got); code != 200 {
+		t.Fatalf("Couldn't get a's stats: %d", code)
+	}
+	gotAwards := []float64{got.SettebelloRate, got.PrimieraRate, got.CardsRate, got.DenariRate}
+	wantAwards := []float64{awards["SetteBello"], awards["Primera"], awards["Cards"], awards["Denari"]}
+	if diff := cmp.Diff(wantAwards, gotAwards); diff != "" {
+		t.Errorf("award rates mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/sbadame/scopa/protocol"
+	"github.com/sbadame/scopa/textui"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// serveTCP offers the match over a plain line protocol, for telnet, netcat and the simplest of bots.
+// It accepts players on l until it fails.
+func (s *server) serveTCP(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.playTCP(conn)
+	}
+}
+
+// tcpPlayer is a player connected over the line protocol.
+type tcpPlayer struct {
+	s    *server
+	conn net.Conn
+	nick string
+	quit chan struct{} // Closed when the player disconnects.
+
+	sync.Mutex       // Guards writes to conn and the fields below.
+	matchID    int64 // 0 once the player has left the match.
+	token      string
+	state      *protocol.State
+	clock      *protocol.Clock
+}
+
+func (t *tcpPlayer) printf(format string, a ...interface{}) {
+	t.Lock()
+	defer t.Unlock()
+	fmt.Fprintf(t.conn, format, a...)
+}
+
+func (t *tcpPlayer) show() {
+	t.Lock()
+	defer t.Unlock()
+	if t.state == nil {
+		fmt.Fprintln(t.conn, "Waiting for another player to join...")
+		return
+	}
+	textui.Render(t.conn, t.nick, t.state, t.clock)
+}
+
+// playTCP talks to the player on conn until they leave.
+// The first line is their nickname, optionally followed by the seat token from before to resume it.
+func (s *server) playTCP(conn net.Conn) {
+	t := &tcpPlayer{s: s, conn: conn, quit: make(chan struct{})}
+	defer close(t.quit)
+	defer conn.Close()
+	in := bufio.NewScanner(conn)
+
+	t.printf("Benvenuti a scopa! Type your nickname: ")
+	if !in.Scan() {
+		return
+	}
+	login := strings.Fields(in.Text())
+	if len(login) == 0 || len(login) > 2 {
+		t.printf("Log in with your nickname, and the seat token to get a seat back.\n")
+		return
+	}
+	t.nick = login[0]
+	if _, err := s.guestID(t.nick); err != nil {
+		t.printf("%v\n", err)
+		return
+	}
+	token := ""
+	if len(login) == 2 {
+		token = login[1]
+	}
+	if err := t.join(token); err != nil {
+		t.printf("Couldn't join: %v\n", err)
+		return
+	}
+
+	for in.Scan() {
+		line := strings.TrimSpace(in.Text())
+		switch strings.ToLower(line) {
+		case "help", "?":
+			t.printf("%s\n  new                Leave a match that's over, and join the next one.\n", textui.Help)
+			continue
+		case "show", "":
+			t.show()
+			continue
+		case "new":
+			if err := t.next(); err != nil {
+				t.printf("%v\n", err)
+			}
+			continue
+		}
+
+		cmd, err := textui.ParseCommand(line)
+		if err == textui.ErrQuit {
+			t.printf("Ciao!\n")
+			return
+		}
+		if err != nil {
+			t.printf("%v\n", err)
+			continue
+		}
+		t.Lock()
+		token := t.token
+		t.Unlock()
+		if _, err := s.handle(token, cmd); err != nil {
+			t.printf("%v\n", err)
+		}
+	}
+}
+
+// join seats the player in the current match, and starts streaming it to them.
+func (t *tcpPlayer) join(token string) error {
+	match := &t.s.m
+	match.Lock()
+	matchID := match.ID
+	match.Unlock()
+
+	p, err := match.addPlayer(matchID, t.nick, t.nick, token, t.s.sb)
+	if err != nil {
+		return err
+	}
+
+	t.Lock()
+	t.matchID, t.token, t.state, t.clock = matchID, p.token, nil, nil
+	t.Unlock()
+	if p.token != token {
+		t.printf("Your seat token is %s, log in with \"%s %s\" to get your seat back.\n", p.token, t.nick, p.token)
+	}
+	t.show()
+	go func() {
+		t.s.stream(p, 1, -1, t.quit, t.receive)
+		t.left(matchID)
+	}()
+	return nil
+}
+
+// next leaves a match that's over, turning down any rematch, and joins the next one.
+func (t *tcpPlayer) next() error {
+	match := &t.s.m
+	t.Lock()
+	match.Lock()
+	if match.ID == t.matchID {
+		if err := match.leave(t.token); err != nil {
+			match.Unlock()
+			t.Unlock()
+			return err
+		}
+	}
+	match.Unlock()
+	t.matchID = 0 // Leave quietly, stream doesn't need to announce the reset.
+	t.Unlock()
+	return t.join("")
+}
+
+// receive prints a message that the server would send over the websocket.
+func (t *tcpPlayer) receive(m protocol.ServerMessage) error {
+	if len(m.Nicknames) > 0 {
+		var lineup []string
+		for _, n := range m.Nicknames {
+			if v, ok := m.Scorecard[n]; ok {
+				n = fmt.Sprintf("%s (%d)", n, v)
+			}
+			lineup = append(lineup, n)
+		}
+		sort.Strings(lineup)
+		t.printf("Playing: %s\n", strings.Join(lineup, " vs "))
+	}
+	if m.Chat != nil {
+		t.printf("<%s> %s\n", m.Chat.From, m.Chat.Text)
+	}
+	if m.Proposal != nil {
+		t.printf("%s\n", textui.Proposal(t.nick, m.Proposal))
+	}
+	if m.Rematch != nil {
+		t.printf("%s\n", textui.Rematch(t.nick, m.Rematch))
+		if m.Rematch.Status == protocol.RematchDeclined || m.Rematch.Status == protocol.RematchExpired {
+			t.printf("Type new to join the next match.\n")
+		}
+	}
+	if m.State != nil {
+		t.Lock()
+		t.state, t.clock = m.State, m.Clock
+		t.Unlock()
+		t.show()
+		if m.State.Ended {
+			t.printf("Type rematch to play again, or new to join the next match.\n")
+		}
+	}
+	return nil
+}
+
+// left tells the player why they're no longer streaming matchID.
+func (t *tcpPlayer) left(matchID int64) {
+	select {
+	case <-t.quit:
+		return // They hung up.
+	default:
+	}
+
+	t.Lock()
+	current := t.matchID
+	t.Unlock()
+	if current != matchID {
+		return // They left on their own.
+	}
+
+	match := &t.s.m
+	match.Lock()
+	reset := match.ID != matchID
+	match.Unlock()
+	if reset {
+		t.printf("The match is over, type new to join the next one.\n")
+		return
+	}
+	t.printf("Your seat was taken over by another connection.\n")
+	t.conn.Close()
+}
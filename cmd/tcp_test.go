@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lineConn is a player on the line protocol.
+type lineConn struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialTCP(t *testing.T, l net.Listener, login string) *lineConn {
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Couldn't connect: %v", err)
+	}
+	c := &lineConn{t, conn, bufio.NewReader(conn)}
+	c.send(login)
+	return c
+}
+
+func (c *lineConn) send(line string) {
+	if _, err := c.conn.Write([]byte(line + "\n")); err != nil {
+		c.t.Fatalf("Couldn't send %q: %v", line, err)
+	}
+}
+
+// expect reads lines until one contains want, and returns it.
+func (c *lineConn) expect(want string) string {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("Waiting for %q: %v", want, err)
+		}
+		if strings.Contains(line, want) {
+			return line
+		}
+	}
+}
+
+func TestTCP(t *testing.T) {
+	s := &server{m: Match{ID: 1}, sb: tempScoreboard(t), accounts: tempAccounts(t)}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Couldn't listen: %v", err)
+	}
+	defer l.Close()
+	go s.serveTCP(l)
+
+	a := dialTCP(t, l, "a")
+	defer a.conn.Close()
+	token := strings.TrimSuffix(strings.Fields(strings.SplitN(a.expect("Your seat token is"), "token is ", 2)[1])[0], ",")
+	b := dialTCP(t, l, "b")
+	defer b.conn.Close()
+	a.expect("Playing: a vs b")
+	b.expect("Playing: a vs b")
+
+	s.m.Lock()
+	first, second, card := a, b, s.m.state.Players[0].Hand[0]
+	if s.m.state.NextPlayer != "a" {
+		first, second = b, a
+	}
+	nick := s.m.state.NextPlayer
+	s.m.Unlock()
+
+	second.send("drop " + card.Short())
+	second.expect("Not your turn!")
+	first.send("drop " + card.Short())
+	second.expect("dropped " + card.Short())
+	first.send("say Ciao")
+	second.expect("<" + nick + "> Ciao")
+
+	// Taking the seat back over a new connection hangs up on the old one.
+	a2 := dialTCP(t, l, "a "+token)
+	defer a2.conn.Close()
+	a2.expect("Playing: a vs b")
+	a.expect("taken over")
+
+	b.send("new")
+	b.expect("isn't over yet")
+}
+
+func TestTCPReservedNicknames(t *testing.T) {
+	s := &server{m: Match{ID: 1}, sb: tempScoreboard(t), accounts: tempAccounts(t)}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Couldn't listen: %v", err)
+	}
+	defer l.Close()
+	go s.serveTCP(l)
+
+	for _, nick := range []string{botName, "acct:1"} {
+		c := dialTCP(t, l, nick)
+		c.expect(nick + " is a registered player")
+		c.conn.Close()
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	if len(s.m.players) != 0 {
+		t.Errorf("Expected nobody to sit down, got %+v", s.m.players)
+	}
+}
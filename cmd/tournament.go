@@ -0,0 +1,757 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sbadame/scopa/store"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The formats that a tournament can be played in.
+const (
+	roundRobin        = "round-robin"        // Everyone plays everyone once.
+	singleElimination = "single-elimination" // Out after the first loss.
+	doubleElimination = "double-elimination" // Out after the second loss.
+	duplicate         = "duplicate"          // Every table plays the same deals, see matchpoints.
+)
+
+// The brackets of an elimination tournament.
+const (
+	winnersBracket = "winners" // Unbeaten.
+	losersBracket  = "losers"  // Beaten once, in double elimination.
+	finalBracket   = "final"   // The last two players.
+)
+
+// tournaments are every tournament run on the server, by ID.
+type tournaments struct {
+	sync.Mutex
+	store  store.Store
+	NextID int
+	ByID   map[int]*tournament
+}
+
+// tournament pairs its players up round by round, and plays every pairing at a table reserved for the two of
+// them. A round starts once every game of the round before it is over.
+type tournament struct {
+	ID        int
+	Name      string
+	Format    string
+	Organiser string   // Account ID.
+	Players   []string // IDs, best seed first.
+	Created   time.Time
+	Rounds    [][]*pairing   // Only once it has started, the last round is the one being played.
+	Deals     int            `json:",omitempty"` // In duplicate, each deal is played twice with the seats swapped.
+	Seeds     []int64        `json:",omitempty"` // That each deal is dealt from, in duplicate.
+	Losses    map[string]int `json:",omitempty"` // By ID, in elimination tournaments.
+	Champion  string         `json:",omitempty"` // ID, once it's over.
+}
+
+// pairing is a game between two players in a round, or a bye when B is empty.
+type pairing struct {
+	A, B    string // IDs.
+	Bracket string `json:",omitempty"` // In elimination tournaments.
+	MatchID int64  `json:",omitempty"` // The table it's being played at, 0 when it isn't.
+	Games   int    // Played so far, knockout games that end in a tie are played again.
+	AScore  float64
+	APoints int // Points won in the last game.
+	BPoints int
+	Decided bool
+}
+
+// tournamentsMigrations bring older tournament files up to date, see store.Open.
+var tournamentsMigrations = []store.Migration{store.Unchanged}
+
+func loadTournaments(st store.Store) *tournaments {
+	ts := &tournaments{store: st, NextID: 1, ByID: make(map[int]*tournament)}
+	if err := st.Load(ts, nil); err != nil {
+		fmt.Printf("Couldn't read the tournaments, %v\n", err)
+	}
+	return ts
+}
+
+// save writes the tournaments to disk, callers must hold the lock.
+func (ts *tournaments) save() {
+	if err := ts.store.Save(ts); err != nil {
+		fmt.Printf("Couldn't save the tournaments: %v\n", err)
+	}
+}
+
+// knockout reports whether players are knocked out of the tournament, rather than playing everyone.
+func (t *tournament) knockout() bool {
+	return t.Format == singleElimination || t.Format == doubleElimination
+}
+
+// lives is how many losses knock a player out.
+func (t *tournament) lives() int {
+	if t.Format == doubleElimination {
+		return 2
+	}
+	return 1
+}
+
+// round returns the round being played, or nil before the tournament starts.
+func (t *tournament) round() []*pairing {
+	if len(t.Rounds) == 0 {
+		return nil
+	}
+	return t.Rounds[len(t.Rounds)-1]
+}
+
+// seed returns what round r is dealt from, 0 for a new deal at every table.
+func (t *tournament) seed(r int) int64 {
+	if t.Format != duplicate {
+		return 0
+	}
+	return t.Seeds[r/2]
+}
+
+// start pairs up the first round.
+func (t *tournament) start() error {
+	if len(t.Rounds) > 0 {
+		return fmt.Errorf("%s has already started", t.Name)
+	}
+	if len(t.Players) < 2 {
+		return fmt.Errorf("%s needs at least 2 players to start", t.Name)
+	}
+	if t.Format == duplicate {
+		if len(t.Players) < 4 {
+			return fmt.Errorf("%s needs at least 4 players, to play each deal at 2 tables", t.Name)
+		}
+		if t.Deals == 0 {
+			t.Deals = roundRobinRounds(len(t.Players))
+		}
+		for i := 0; i < t.Deals; i++ {
+			t.Seeds = append(t.Seeds, newSeed())
+		}
+		t.Rounds = append(t.Rounds, duplicateRound(t.Players, 0))
+		return nil
+	}
+	if !t.knockout() {
+		t.Rounds = append(t.Rounds, roundRobinRound(t.Players, 0))
+		return nil
+	}
+
+	t.Losses = make(map[string]int)
+	var round []*pairing
+	for _, seeds := range bracket(len(t.Players)) {
+		b := ""
+		if seeds[1] < len(t.Players) {
+			b = t.Players[seeds[1]]
+		}
+		round = append(round, newPairing(t.Players[seeds[0]], b, winnersBracket))
+	}
+	t.Rounds = append(t.Rounds, round)
+	return nil
+}
+
+// newPairing pairs a with b, or gives a a bye when b is empty.
+func newPairing(a, b, bracket string) *pairing {
+	return &pairing{A: a, B: b, Bracket: bracket, Decided: b == ""}
+}
+
+// bracket returns the first round pairings of n seeds, 0 being the best, in the order that the winners meet in.
+// The field is rounded up to a power of 2, and the best seeds are paired with the missing ones for byes.
+func bracket(n int) [][2]int {
+	order := []int{0}
+	for len(order) < n {
+		// Each seed is paired with the seed that's as far from the bottom as it is from the top.
+		size := len(order) * 2
+		next := make([]int, 0, size)
+		for _, s := range order {
+			next = append(next, s, size-1-s)
+		}
+		order = next
+	}
+	var pairs [][2]int
+	for i := 0; i+1 < len(order); i += 2 {
+		pairs = append(pairs, [2]int{order[i], order[i+1]})
+	}
+	return pairs
+}
+
+// roundRobinRound returns round r of everyone playing everyone, using the circle method: the first player stays
+// put, and the others rotate around them. With an odd number of players, whoever would play nobody has a bye.
+func roundRobinRound(players []string, r int) []*pairing {
+	ps := append([]string(nil), players...)
+	if len(ps)%2 == 1 {
+		ps = append(ps, "")
+	}
+	n := len(ps)
+	circle := []string{ps[0]}
+	for i := 1; i < n; i++ {
+		circle = append(circle, ps[1+(i-1+r)%(n-1)])
+	}
+
+	var round []*pairing
+	for i := 0; i < n/2; i++ {
+		a, b := circle[i], circle[n-1-i]
+		if a == "" {
+			a, b = b, a
+		}
+		round = append(round, newPairing(a, b, ""))
+	}
+	return round
+}
+
+// roundRobinRounds is how many rounds it takes n players to play everyone.
+func roundRobinRounds(n int) int {
+	if n%2 == 1 {
+		n++
+	}
+	return n - 1
+}
+
+// duplicateRound returns round r of a duplicate tournament. The opponents change with each deal like in round
+// robin, and each deal is played twice: A moves first the first time, and B the second.
+func duplicateRound(players []string, r int) []*pairing {
+	round := roundRobinRound(players, r/2)
+	if r%2 == 1 {
+		for _, p := range round {
+			if p.B != "" {
+				p.A, p.B = p.B, p.A
+			}
+		}
+	}
+	return round
+}
+
+// matchpoints compares every result in a duplicate round with the others from the same seat, which were played
+// with the same cards. A player gets 2 matchpoints for each of them that they won by more, or lost by less, and 1
+// for each that went the same. It returns the matchpoints by ID, and the most that anyone could have had.
+func matchpoints(round []*pairing) (map[string]float64, float64) {
+	var played []*pairing
+	for _, p := range round {
+		if p.Decided && p.B != "" {
+			played = append(played, p)
+		}
+	}
+	mps := make(map[string]float64)
+	for _, p := range played {
+		mps[p.A], mps[p.B] = 0, 0
+		for _, q := range played {
+			if p == q {
+				continue
+			}
+			// A is always in the first seat, so B's margin is minus A's.
+			d, e := p.APoints-p.BPoints, q.APoints-q.BPoints
+			switch {
+			case d > e:
+				mps[p.A] += 2
+			case d < e:
+				mps[p.B] += 2
+			default:
+				mps[p.A]++
+				mps[p.B]++
+			}
+		}
+	}
+	top := 0.0
+	if len(played) > 1 {
+		top = float64(2 * (len(played) - 1))
+	}
+	return mps, top
+}
+
+// decide records the result of a game in p, and reports whether the round is over. A tied knockout game
+// leaves p undecided, to be played again.
+func (t *tournament) decide(p *pairing, aScore float64, aPoints, bPoints int) bool {
+	p.Games++
+	p.AScore, p.APoints, p.BPoints = aScore, aPoints, bPoints
+	p.MatchID = 0
+	if aScore == 0.5 && t.knockout() {
+		return false
+	}
+	p.Decided = true
+
+	for _, q := range t.round() {
+		if !q.Decided {
+			return false
+		}
+	}
+	return true
+}
+
+// advance pairs up the next round once the current one is over, or crowns the champion.
+func (t *tournament) advance() {
+	if t.Format == duplicate {
+		if len(t.Rounds) < 2*t.Deals {
+			t.Rounds = append(t.Rounds, duplicateRound(t.Players, len(t.Rounds)))
+			return
+		}
+		t.Champion = t.standings()[0].ID
+		return
+	}
+	if !t.knockout() {
+		if len(t.Rounds) < roundRobinRounds(len(t.Players)) {
+			t.Rounds = append(t.Rounds, roundRobinRound(t.Players, len(t.Rounds)))
+			return
+		}
+		t.Champion = t.standings()[0].ID
+		return
+	}
+
+	// Keep the order of the bracket. In the losers bracket the players that were already there go first, and
+	// those that just dropped into it go behind them.
+	var winners, losers, dropped []string
+	for _, p := range t.round() {
+		loser := ""
+		if p.B != "" {
+			loser = p.A
+			if p.AScore == 1 {
+				loser = p.B
+			}
+			t.Losses[loser]++
+		}
+		for _, id := range []string{p.A, p.B} {
+			switch {
+			case id == "" || t.Losses[id] >= t.lives():
+			case t.Losses[id] == 0:
+				winners = append(winners, id)
+			case id == loser:
+				dropped = append(dropped, id)
+			default:
+				losers = append(losers, id)
+			}
+		}
+	}
+	losers = append(losers, dropped...)
+
+	if len(winners)+len(losers) == 1 {
+		t.Champion = append(winners, losers...)[0]
+		return
+	}
+	if len(winners)+len(losers) == 2 {
+		left := append(winners, losers...)
+		t.Rounds = append(t.Rounds, []*pairing{newPairing(left[0], left[1], finalBracket)})
+		return
+	}
+	var round []*pairing
+	for _, b := range []struct {
+		name string
+		ids  []string
+	}{{winnersBracket, winners}, {losersBracket, losers}} {
+		for i := 0; i < len(b.ids); i += 2 {
+			opp := ""
+			if i+1 < len(b.ids) {
+				opp = b.ids[i+1]
+			}
+			round = append(round, newPairing(b.ids[i], opp, b.name))
+		}
+	}
+	t.Rounds = append(t.Rounds, round)
+}
+
+// standing is a player's results in a tournament so far.
+type standing struct {
+	ID            string `json:"-"`
+	Nickname      string
+	Played        int
+	Wins          int
+	Ties          int
+	Losses        int
+	Byes          int
+	Score         float64 // 1 for a win and 0.5 for a tie.
+	PointsFor     int
+	PointsAgainst int
+	Eliminated    int     `json:",omitempty"` // The round they were knocked out in, starting at 1.
+	Matchpoints   float64 `json:",omitempty"` // In duplicate.
+	Percent       float64 `json:",omitempty"` // Matchpoints out of the most they could have had, in duplicate.
+	tops          float64 // The most matchpoints they could have had.
+	headToHead    float64
+	seed          int
+}
+
+// standings ranks the players. Round robin goes by score, and ties are broken by the score in the games between
+// the tied players, then by points won minus points lost, then by points won and then by seed. Knockouts go by how
+// long a player lasted, and then by score. Duplicate goes by the percentage of matchpoints, and then like round
+// robin.
+func (t *tournament) standings() []*standing {
+	byID := make(map[string]*standing)
+	var all []*standing
+	for i, id := range t.Players {
+		s := &standing{ID: id, seed: i}
+		byID[id] = s
+		all = append(all, s)
+	}
+
+	for r, round := range t.Rounds {
+		for _, p := range round {
+			if p.B == "" {
+				byID[p.A].Byes++
+				continue
+			}
+			if !p.Decided {
+				continue
+			}
+			a, b := byID[p.A], byID[p.B]
+			for _, u := range []struct {
+				s, opp       *standing
+				score        float64
+				points, lost int
+			}{{a, b, p.AScore, p.APoints, p.BPoints}, {b, a, 1 - p.AScore, p.BPoints, p.APoints}} {
+				u.s.Played++
+				u.s.Score += u.score
+				u.s.PointsFor += u.points
+				u.s.PointsAgainst += u.lost
+				switch u.score {
+				case 1:
+					u.s.Wins++
+				case 0:
+					u.s.Losses++
+					if t.knockout() && u.s.Losses >= t.lives() {
+						u.s.Eliminated = r + 1
+					}
+				default:
+					u.s.Ties++
+				}
+			}
+		}
+	}
+
+	if t.Format == duplicate {
+		for _, round := range t.Rounds {
+			mps, top := matchpoints(round)
+			for id, mp := range mps {
+				byID[id].Matchpoints += mp
+				byID[id].tops += top
+			}
+		}
+		for _, s := range all {
+			if s.tops > 0 {
+				s.Percent = 100 * s.Matchpoints / s.tops
+			}
+		}
+	}
+
+	// Head to head scores between players on the same score.
+	for _, round := range t.Rounds {
+		for _, p := range round {
+			if p.Decided && p.B != "" && byID[p.A].Score == byID[p.B].Score {
+				byID[p.A].headToHead += p.AScore
+				byID[p.B].headToHead += 1 - p.AScore
+			}
+		}
+	}
+
+	sort.SliceStable(all, func(i, j int) bool {
+		a, b := all[i], all[j]
+		if t.knockout() && a.Eliminated != b.Eliminated {
+			return a.Eliminated == 0 || (b.Eliminated != 0 && a.Eliminated > b.Eliminated)
+		}
+		switch {
+		case a.ID == t.Champion || b.ID == t.Champion:
+			return a.ID == t.Champion
+		case a.Percent != b.Percent:
+			return a.Percent > b.Percent
+		case a.Score != b.Score:
+			return a.Score > b.Score
+		case a.headToHead != b.headToHead:
+			return a.headToHead > b.headToHead
+		case a.PointsFor-a.PointsAgainst != b.PointsFor-b.PointsAgainst:
+			return a.PointsFor-a.PointsAgainst > b.PointsFor-b.PointsAgainst
+		case a.PointsFor != b.PointsFor:
+			return a.PointsFor > b.PointsFor
+		}
+		return a.seed < b.seed
+	})
+	return all
+}
+
+// openTables reserves a table for every pairing in the round that still has to be played, set up like m.
+// Callers must hold the tournaments lock and m's lock.
+func (s *server) openTables(t *tournament, m *Match) {
+	for _, p := range t.round() {
+		if p.Decided || p.MatchID != 0 {
+			continue
+		}
+		table := s.addTable(m, p.A, p.B)
+		if t.Format == duplicate {
+			// Games aren't archived, since the archive would give the deal away to the tables still playing it.
+			table.seats = []string{p.A, p.B}
+			table.dealSeed = t.seed(len(t.Rounds) - 1)
+			table.archive = nil
+		}
+		tid := t.ID
+		table.onEnd = func(m *Match) { s.tournamentGameOver(tid, m) }
+		p.MatchID = table.ID
+	}
+}
+
+// tournamentGameOver records the game that just ended in m, and starts the next round if that was the last game
+// of the round. The table is taken down, players still connected to it can keep looking at the end of the game.
+// Callers must hold m's lock.
+func (s *server) tournamentGameOver(id int, m *Match) {
+	ts := s.tournaments
+	ts.Lock()
+	defer ts.Unlock()
+	t := ts.ByID[id]
+	if t == nil {
+		return
+	}
+
+	var p *pairing
+	for _, q := range t.round() {
+		if q.MatchID == m.ID {
+			p = q
+		}
+	}
+	if p == nil {
+		return
+	}
+
+	p1, p2 := m.state.Players[0], m.state.Players[1]
+	aScore, aPoints, bPoints := m.outcome(), len(p1.Awards)+p1.Scopas, len(p2.Awards)+p2.Scopas
+	if m.players[0].id != p.A {
+		aScore, aPoints, bPoints = 1-aScore, bPoints, aPoints
+	}
+	s.removeTable(m.ID)
+	if t.decide(p, aScore, aPoints, bPoints) {
+		t.advance()
+	}
+	s.openTables(t, m)
+	ts.save()
+}
+
+// resumeTournaments reserves tables again for the games that were being played when the server stopped.
+// Callers must hold m's lock.
+func (s *server) resumeTournaments(m *Match) {
+	ts := s.tournaments
+	ts.Lock()
+	defer ts.Unlock()
+	for _, t := range ts.ByID {
+		if t.Champion != "" {
+			continue
+		}
+		for _, p := range t.round() {
+			p.MatchID = 0
+		}
+		s.openTables(t, m)
+	}
+	ts.save()
+}
+
+// tournamentView is a tournament as the API shows it, with nicknames instead of IDs.
+type tournamentView struct {
+	ID        int
+	Name      string
+	Format    string
+	Organiser string
+	Players   []string
+	Created   time.Time
+	Deals     int `json:",omitempty"`
+	Rounds    [][]pairingView
+	Standings []*standing
+	Champion  string `json:",omitempty"`
+}
+
+type pairingView struct {
+	A, B    string // A moves first in duplicate.
+	Bracket string `json:",omitempty"`
+	MatchID int64  `json:",omitempty"` // Join this match to play the game.
+	Games   int
+	Result  string `json:",omitempty"` // "A" or "B" for the winner, "tie", or "bye".
+	APoints int
+	BPoints int
+	// A's and B's matchpoints, in duplicate once the game is over.
+	AMatchpoints, BMatchpoints float64 `json:",omitempty"`
+}
+
+// view shows t, callers must hold the tournaments lock.
+func (s *server) view(t *tournament) tournamentView {
+	v := tournamentView{
+		ID:        t.ID,
+		Name:      t.Name,
+		Format:    t.Format,
+		Organiser: s.nickname(t.Organiser),
+		Players:   make([]string, 0),
+		Created:   t.Created,
+		Deals:     t.Deals,
+		Rounds:    make([][]pairingView, 0),
+		Standings: t.standings(),
+	}
+	if t.Champion != "" {
+		v.Champion = s.nickname(t.Champion)
+	}
+	for _, id := range t.Players {
+		v.Players = append(v.Players, s.nickname(id))
+	}
+	for _, st := range v.Standings {
+		st.Nickname = s.nickname(st.ID)
+	}
+	for _, round := range t.Rounds {
+		var r []pairingView
+		mps, _ := matchpoints(round)
+		for _, p := range round {
+			pv := pairingView{A: s.nickname(p.A), Bracket: p.Bracket, MatchID: p.MatchID, Games: p.Games, APoints: p.APoints, BPoints: p.BPoints}
+			if t.Format == duplicate && p.Decided {
+				pv.AMatchpoints, pv.BMatchpoints = mps[p.A], mps[p.B]
+			}
+			switch {
+			case p.B == "":
+				pv.Result = "bye"
+			case !p.Decided:
+			case p.AScore == 1:
+				pv.Result = "A"
+			case p.AScore == 0:
+				pv.Result = "B"
+			default:
+				pv.Result = "tie"
+			}
+			if p.B != "" {
+				pv.B = s.nickname(p.B)
+			}
+			r = append(r, pv)
+		}
+		v.Rounds = append(v.Rounds, r)
+	}
+	return v
+}
+
+// tournament serves the tournaments:
+//
+//	GET /tournaments lists them.
+//	POST /tournaments {"Name": "Spring", "Format": "round-robin"} creates one, organised by whoever is logged in.
+//	  Duplicate tournaments can set "Deals", which is how many rounds it takes everyone to play everyone otherwise.
+//	GET /tournaments/{id} has the players, the pairings of every round, and the standings.
+//	POST /tournaments/{id}/players {"Nickname": "marco"} registers a player, in order of seeding.
+//	POST /tournaments/{id}/start pairs up the first round. Each pairing has the MatchID to play at.
+func (s *server) tournament(w http.ResponseWriter, r *http.Request) {
+	ts := s.tournaments
+	if ts == nil {
+		w.WriteHeader(404)
+		io.WriteString(w, errorJSON("Tournaments aren't run on this server."))
+		return
+	}
+	path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/tournaments"), "/"), "/")
+
+	if path[0] == "" {
+		if r.Method == "POST" {
+			s.createTournament(w, r)
+			return
+		}
+		ts.Lock()
+		defer ts.Unlock()
+		list := make([]tournamentView, 0)
+		for _, t := range ts.ByID {
+			list = append(list, s.view(t))
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].ID > list[j].ID })
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+
+	id, err := strconv.Atoi(path[0])
+	ts.Lock()
+	t := ts.ByID[id]
+	ts.Unlock()
+	if err != nil || t == nil || len(path) > 2 {
+		w.WriteHeader(404)
+		io.WriteString(w, errorJSON(fmt.Sprintf("There's no tournament %s.", path[0])))
+		return
+	}
+	if len(path) == 1 {
+		ts.Lock()
+		defer ts.Unlock()
+		json.NewEncoder(w).Encode(s.view(t))
+		return
+	}
+
+	acct := s.accounts.fromRequest(r)
+	if r.Method != "POST" || acct == nil || acct.ID != t.Organiser {
+		w.WriteHeader(403)
+		io.WriteString(w, errorJSON("Only the organiser can change the tournament."))
+		return
+	}
+	switch path[1] {
+	case "players":
+		var p struct{ Nickname string }
+		if !parseRequestJSON(w, r, &p) {
+			return
+		}
+		if p.Nickname == "" {
+			w.WriteHeader(400)
+			io.WriteString(w, errorJSON("Nickname field needs to be set."))
+			return
+		}
+		pid := s.playerID(p.Nickname)
+		ts.Lock()
+		defer ts.Unlock()
+		if len(t.Rounds) > 0 {
+			w.WriteHeader(400)
+			io.WriteString(w, errorJSON(fmt.Sprintf("%s has already started.", t.Name)))
+			return
+		}
+		for _, id := range t.Players {
+			if id == pid {
+				w.WriteHeader(400)
+				io.WriteString(w, errorJSON(fmt.Sprintf("%s is already playing.", p.Nickname)))
+				return
+			}
+		}
+		t.Players = append(t.Players, pid)
+		ts.save()
+		json.NewEncoder(w).Encode(s.view(t))
+	case "start":
+		// The tables are set up like the open match, whose lock comes before the tournaments'.
+		s.m.Lock()
+		defer s.m.Unlock()
+		ts.Lock()
+		defer ts.Unlock()
+		if err := t.start(); err != nil {
+			w.WriteHeader(400)
+			io.WriteString(w, errorJSON(err.Error()))
+			return
+		}
+		s.openTables(t, &s.m)
+		ts.save()
+		json.NewEncoder(w).Encode(s.view(t))
+	default:
+		w.WriteHeader(404)
+		io.WriteString(w, errorJSON(fmt.Sprintf("Tournaments can't %s.", path[1])))
+	}
+}
+
+func (s *server) createTournament(w http.ResponseWriter, r *http.Request) {
+	acct := s.accounts.fromRequest(r)
+	if acct == nil {
+		w.WriteHeader(401)
+		io.WriteString(w, errorJSON("Log in to organise a tournament."))
+		return
+	}
+	var c struct {
+		Name, Format string
+		Deals        int
+	}
+	if !parseRequestJSON(w, r, &c) {
+		return
+	}
+	switch {
+	case c.Name == "":
+		w.WriteHeader(400)
+		io.WriteString(w, errorJSON("Name field needs to be set."))
+		return
+	case c.Format != roundRobin && c.Format != singleElimination && c.Format != doubleElimination && c.Format != duplicate:
+		w.WriteHeader(400)
+		io.WriteString(w, errorJSON(fmt.Sprintf("Format should be %s, %s, %s or %s.", roundRobin, singleElimination, doubleElimination, duplicate)))
+		return
+	case c.Deals < 0 || (c.Deals > 0 && c.Format != duplicate):
+		w.WriteHeader(400)
+		io.WriteString(w, errorJSON("Deals can only be set to a positive number in duplicate tournaments."))
+		return
+	}
+
+	ts := s.tournaments
+	ts.Lock()
+	defer ts.Unlock()
+	t := &tournament{ID: ts.NextID, Name: c.Name, Format: c.Format, Organiser: acct.ID, Created: time.Now(), Deals: c.Deals}
+	ts.NextID++
+	ts.ByID[t.ID] = t
+	ts.save()
+	json.NewEncoder(w).Encode(s.view(t))
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/google/go-cmp/cmp"
+	"github.com/sbadame/scopa/protocol"
+	"github.com/sbadame/scopa/scopa"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func tempTournaments(t *testing.T) *tournaments {
+	return loadTournaments(tempStore(t, "tournaments", tournamentsMigrations...))
+}
+
+// playOut plays every round of tour, with winner picking who wins each game.
+func playOut(t *testing.T, tour *tournament, winner func(a, b string) string) {
+	if err := tour.start(); err != nil {
+		t.Fatalf("Couldn't start: %v", err)
+	}
+	for i := 0; tour.Champion == "" && i < 100; i++ {
+		for _, p := range tour.round() {
+			if p.Decided {
+				continue
+			}
+			score := 0.0
+			if winner(p.A, p.B) == p.A {
+				score = 1
+			}
+			if tour.decide(p, score, 6, 3) {
+				tour.advance()
+				break
+			}
+		}
+	}
+	if tour.Champion == "" {
+		t.Fatalf("Expected the tournament to be over, got %+v", tour.Rounds)
+	}
+}
+
+func TestBracket(t *testing.T) {
+	want := [][2]int{{0, 7}, {3, 4}, {1, 6}, {2, 5}}
+	if d := cmp.Diff(want, bracket(5)); d != "" {
+		t.Errorf("mismatch (-want +got):\n%s", d)
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	tour := &tournament{Format: roundRobin, Players: []string{"a", "b", "c", "d", "e"}}
+	// Everyone beats those registered after them, except that e beats a.
+	playOut(t, tour, func(a, b string) string {
+		if (a < b) != (a == "a" && b == "e" || a == "e" && b == "a") {
+			return a
+		}
+		return b
+	})
+
+	played := make(map[string]int)
+	for _, round := range tour.Rounds {
+		for _, p := range round {
+			played[p.A+p.B]++
+			if p.B != "" {
+				played[p.B+p.A]++
+			}
+		}
+	}
+	for _, a := range tour.Players {
+		if played[a] != 1 {
+			t.Errorf("Expected %s to have 1 bye, got %d", a, played[a])
+		}
+		for _, b := range tour.Players {
+			if a != b && played[a+b] != 1 {
+				t.Errorf("Expected %s to play %s once, got %d", a, b, played[a+b])
+			}
+		}
+	}
+
+	// a and b are both on 3 wins and a beat b, d and e are both on 1 and d beat e.
+	var got []string
+	for _, s := range tour.standings() {
+		got = append(got, s.ID)
+	}
+	if d := cmp.Diff([]string{"a", "b", "c", "d", "e"}, got); d != "" {
+		t.Errorf("standings mismatch (-want +got):\n%s", d)
+	}
+	if tour.Champion != "a" {
+		t.Errorf("Expected a to win, got %s", tour.Champion)
+	}
+}
+
+func TestElimination(t *testing.T) {
+	players := []string{"a", "b", "c", "d", "e"}
+	better := func(a, b string) string {
+		if a < b {
+			return a
+		}
+		return b
+	}
+
+	single := &tournament{Format: singleElimination, Players: players}
+	playOut(t, single, better)
+	if single.Champion != "a" || len(single.Rounds) != 3 {
+		t.Errorf("Expected a to win in 3 rounds, got %s in %d", single.Champion, len(single.Rounds))
+	}
+	if byes := single.standings()[0].Byes; byes != 1 {
+		t.Errorf("Expected the top seed to have a bye, got %d", byes)
+	}
+
+	// In double elimination b beats a once they meet in the final, so there's a second final.
+	double := &tournament{Format: doubleElimination, Players: players}
+	lost := false
+	playOut(t, double, func(a, b string) string {
+		if a == "a" && b == "b" && !lost {
+			lost = true
+			return b
+		}
+		return better(a, b)
+	})
+	last := double.Rounds[len(double.Rounds)-2:]
+	if double.Champion != "a" || last[0][0].Bracket != finalBracket || last[1][0].Bracket != finalBracket {
+		t.Errorf("Expected a to win after two finals, got %s: %+v", double.Champion, last)
+	}
+	for _, p := range players[1:] {
+		if double.Losses[p] != 2 {
+			t.Errorf("Expected %s to be knocked out with 2 losses, got %d", p, double.Losses[p])
+		}
+	}
+
+	// Ties in knockouts are played again.
+	tied := &tournament{Format: singleElimination, Players: []string{"a", "b"}}
+	tied.start()
+	p := tied.round()[0]
+	if tied.decide(p, 0.5, 5, 5) || p.Decided {
+		t.Errorf("Expected a tie to be played again.")
+	}
+	if !tied.decide(p, 0, 4, 5) || p.Games != 2 {
+		t.Errorf("Expected the replay to decide it, got %+v", p)
+	}
+}
+
+func TestMatchpoints(t *testing.T) {
+	round := []*pairing{
+		{A: "a", B: "b", APoints: 5, BPoints: 3, Decided: true},
+		{A: "c", B: "d", APoints: 4, BPoints: 4, Decided: true},
+		{A: "e", B: "f", APoints: 6, BPoints: 4, Decided: true},
+		newPairing("g", "", ""),
+	}
+	mps, top := matchpoints(round)
+	want := map[string]float64{"a": 3, "b": 1, "c": 0, "d": 4, "e": 3, "f": 1}
+	if d := cmp.Diff(want, mps); d != "" || top != 4 {
+		t.Errorf("top %v, mismatch (-want +got):\n%s", top, d)
+	}
+}
+
+func TestDuplicate(t *testing.T) {
+	tour := &tournament{Format: duplicate, Players: []string{"a", "b", "c", "d"}}
+	if err := tour.start(); err != nil {
+		t.Fatalf("Couldn't start: %v", err)
+	}
+	// Whoever moves first wins 6-3, except that a always wins 7-2.
+	for tour.Champion == "" {
+		for _, p := range tour.round() {
+			switch "a" {
+			case p.A:
+				tour.decide(p, 1, 7, 2)
+			case p.B:
+				tour.decide(p, 0, 2, 7)
+			default:
+				tour.decide(p, 1, 6, 3)
+			}
+		}
+		tour.advance()
+	}
+
+	if len(tour.Seeds) != 3 || len(tour.Rounds) != 6 {
+		t.Fatalf("Expected 3 deals played twice each, got %d seeds and %d rounds", len(tour.Seeds), len(tour.Rounds))
+	}
+	for r := 0; r < 6; r += 2 {
+		first, second := tour.Rounds[r], tour.Rounds[r+1]
+		for i := range first {
+			if first[i].A != second[i].B || first[i].B != second[i].A {
+				t.Errorf("Expected round %d to swap the seats of round %d: %+v vs %+v", r+2, r+1, second[i], first[i])
+			}
+		}
+		if tour.seed(r) != tour.seed(r+1) {
+			t.Errorf("Expected rounds %d and %d to be the same deal.", r+1, r+2)
+		}
+	}
+
+	// a beats everyone who had the same cards.
+	st := tour.standings()
+	if st[0].ID != "a" || st[0].Percent != 100 || tour.Champion != "a" {
+		t.Errorf("Expected a to win on matchpoints, got %+v", st[0])
+	}
+
+	// Tables deal every game from the seed, with the players in the seats they were given.
+	var hands [][]scopa.Card
+	for _, ids := range [][]string{{"a", "b"}, {"c", "d"}} {
+		m := &Match{ID: 1, seats: ids, dealSeed: 7}
+		for _, id := range []string{ids[1], ids[0]} {
+			if _, err := m.addPlayer(1, id, id, "", tempScoreboard(t)); err != nil {
+				t.Fatalf("Couldn't seat %s: %v", id, err)
+			}
+		}
+		if m.state.NextPlayer != ids[0] {
+			t.Errorf("Expected %s to move first, got %s", ids[0], m.state.NextPlayer)
+		}
+		hands = append(hands, append(m.state.Players[0].Hand, m.state.Table...))
+	}
+	if d := cmp.Diff(hands[0], hands[1]); d != "" {
+		t.Errorf("Expected both tables to be dealt the same cards, mismatch (-first +second):\n%s", d)
+	}
+}
+
+func TestDuplicateArchive(t *testing.T) {
+	s := &server{m: Match{ID: 1}, sb: tempScoreboard(t), accounts: tempAccounts(t), tournaments: tempTournaments(t)}
+	s.m.archive = tempArchive(t)
+	tour := &tournament{ID: 1, Format: duplicate, Players: []string{"a", "b", "c", "d"}}
+	if err := tour.start(); err != nil {
+		t.Fatalf("Couldn't start: %v", err)
+	}
+	s.tournaments.ByID[tour.ID] = tour
+	s.m.Lock()
+	s.resumeTournaments(&s.m)
+	s.m.Unlock()
+
+	// The first table to finish can't give the deal away to the other one.
+	p := tour.round()[0]
+	table := s.match(p.MatchID)
+	tokens := make(map[string]string)
+	for _, n := range []string{p.A, p.B} {
+		pl, err := table.addPlayer(p.MatchID, n, n, "", s.sb)
+		if err != nil {
+			t.Fatalf("Couldn't seat %s: %v", n, err)
+		}
+		tokens[n] = pl.token
+	}
+	if _, err := s.handle(tokens[p.B], protocol.Command{Type: protocol.ResignCommand}); err != nil {
+		t.Fatalf("%s couldn't resign: %v", p.B, err)
+	}
+	if !tour.round()[0].Decided {
+		t.Fatalf("Expected the game to be recorded, got %+v", tour.round()[0])
+	}
+
+	w := httptest.NewRecorder()
+	s.archived(w, httptest.NewRequest("GET", "/archive", nil))
+	var found []archivedMatch
+	if err := json.Unmarshal(w.Body.Bytes(), &found); err != nil || len(found) != 0 {
+		t.Errorf("Expected nothing to be archived while the deal is being played, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestTournament(t *testing.T) {
+	s := &server{m: Match{ID: 1}, sb: tempScoreboard(t), accounts: tempAccounts(t), tournaments: tempTournaments(t)}
+	acct, err := s.accounts.register("marco", "polo1234")
+	if err != nil {
+		t.Fatalf("Couldn't register: %v", err)
+	}
+	w := httptest.NewRecorder()
+	if err := s.accounts.startSession(w, httptest.NewRequest("POST", "/login", nil), acct); err != nil {
+		t.Fatalf("Couldn't start a session: %v", err)
+	}
+	session := w.Result().Cookies()[0]
+
+	do := func(method, url, body string, v interface{}) int {
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		r.AddCookie(session)
+		w := httptest.NewRecorder()
+		s.tournament(w, r)
+		if v != nil {
+			json.Unmarshal(w.Body.Bytes(), v)
+		}
+		return w.Code
+	}
+
+	var tv tournamentView
+	if code := do("POST", "/tournaments", `{"Name": "Spring", "Format": "knockout"}`, nil); code != 400 {
+		t.Errorf("Expected an unknown format to fail, got %d", code)
+	}
+	if code := do("POST", "/tournaments", `{"Name": "Spring", "Format": "round-robin"}`, &tv); code != 200 || tv.Organiser != "marco" {
+		t.Fatalf("Couldn't create a tournament: %d %+v", code, tv)
+	}
+	url := "/tournaments/" + strconv.Itoa(tv.ID)
+	for _, n := range []string{"a", "b", "c"} {
+		if code := do("POST", url+"/players", `{"Nickname": "`+n+`"}`, &tv); code != 200 {
+			t.Fatalf("Couldn't register %s: %d", n, code)
+		}
+	}
+	if code := do("POST", url+"/start", "", &tv); code != 200 || len(tv.Rounds) != 1 {
+		t.Fatalf("Couldn't start: %d %+v", code, tv)
+	}
+	if code := do("POST", url+"/players", `{"Nickname": "d"}`, nil); code != 400 {
+		t.Errorf("Expected registering after the start to fail, got %d", code)
+	}
+
+	// Round 1 is b against c at a reserved table, a has a bye.
+	var game pairingView
+	for _, p := range tv.Rounds[0] {
+		if p.Result != "bye" {
+			game = p
+		}
+	}
+	if game.MatchID == 0 || game.A+game.B != "bc" {
+		t.Fatalf("Expected b to play c at a table, got %+v", tv.Rounds[0])
+	}
+	table := s.match(game.MatchID)
+	if _, err := table.addPlayer(game.MatchID, "a", "a", "", s.sb); err == nil {
+		t.Errorf("Expected a to be kept out of b and c's table.")
+	}
+	tokens := make(map[string]string)
+	for _, n := range []string{"b", "c"} {
+		p, err := table.addPlayer(game.MatchID, n, n, "", s.sb)
+		if err != nil {
+			t.Fatalf("Couldn't seat %s: %v", n, err)
+		}
+		tokens[n] = p.token
+	}
+	if _, err := s.handle(tokens["c"], protocol.Command{Type: protocol.ResignCommand}); err != nil {
+		t.Fatalf("c couldn't resign: %v", err)
+	}
+	if s.match(game.MatchID) == table {
+		t.Errorf("Expected the table to be taken down after the game.")
+	}
+
+	if code := do("GET", url, "", &tv); code != 200 || len(tv.Rounds) != 2 {
+		t.Fatalf("Expected round 2 to start, got %d %+v", code, tv)
+	}
+	if r := tv.Rounds[0]; r[0].Result != "bye" || r[1].Result != "A" {
+		t.Errorf("Expected b to win round 1, got %+v", r)
+	}
+	if st := tv.Standings[0]; st.Nickname != "b" || st.Wins != 1 {
+		t.Errorf("Expected b to lead, got %+v", st)
+	}
+
+	// Only the organiser can run it.
+	r := httptest.NewRequest("POST", url+"/start", nil)
+	w = httptest.NewRecorder()
+	s.tournament(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected a stranger to be forbidden, got %d", w.Code)
+	}
+}
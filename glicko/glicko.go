@@ -0,0 +1,101 @@
+// Package glicko implements the Glicko-2 rating system, as described in
+// http://www.glicko.net/glicko/glicko2.pdf
+package glicko
+
+import "math"
+
+// The rating of a new player.
+const (
+	DefaultRating     = 1500
+	DefaultDeviation  = 350
+	DefaultVolatility = 0.06
+)
+
+const (
+	scale = 173.7178 // Between the Glicko and Glicko-2 scales.
+	tau   = 0.5      // Constrains how fast the volatility changes.
+	eps   = 0.000001 // When to stop iterating on the volatility.
+)
+
+// Rating is a player's skill. The true rating is within two Deviations of Rating with 95% confidence.
+// Volatility is how erratic the player's results are.
+type Rating struct {
+	Rating     float64
+	Deviation  float64
+	Volatility float64
+}
+
+// New returns the rating of a player that hasn't played yet.
+func New() Rating {
+	return Rating{DefaultRating, DefaultDeviation, DefaultVolatility}
+}
+
+// Result is the outcome of a game against Opponent: 1 for a win, 0.5 for a tie and 0 for a loss.
+type Result struct {
+	Opponent Rating
+	Score    float64
+}
+
+func g(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
+
+func expected(mu, muj, phij float64) float64 {
+	return 1 / (1 + math.Exp(-g(phij)*(mu-muj)))
+}
+
+// Update returns the rating after a rating period with results, all of which should be against the opponents'
+// ratings from before the period. A period with no results only makes the rating less certain.
+func (r Rating) Update(results []Result) Rating {
+	mu, phi, sigma := (r.Rating-DefaultRating)/scale, r.Deviation/scale, r.Volatility
+	if len(results) == 0 {
+		return Rating{r.Rating, scale * math.Sqrt(phi*phi+sigma*sigma), sigma}
+	}
+
+	// The estimated variance and improvement based on the game outcomes alone.
+	var vInv, sum float64
+	for _, res := range results {
+		muj, phij := (res.Opponent.Rating-DefaultRating)/scale, res.Opponent.Deviation/scale
+		e := expected(mu, muj, phij)
+		vInv += g(phij) * g(phij) * e * (1 - e)
+		sum += g(phij) * (res.Score - e)
+	}
+	v := 1 / vInv
+	delta := v * sum
+
+	// The new volatility, found with the Illinois algorithm.
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-phi*phi-v-ex)/(2*d*d) - (x-a)/(tau*tau)
+	}
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*tau) < 0 {
+			k++
+		}
+		B = a - k*tau
+	}
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > eps {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+	sigma = math.Exp(A / 2)
+
+	phiStar := math.Sqrt(phi*phi + sigma*sigma)
+	phi = 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	mu += phi * phi * sum
+	return Rating{scale*mu + DefaultRating, scale * phi, sigma}
+}
@@ -0,0 +1,39 @@
+package glicko
+
+import (
+	"math"
+	"testing"
+)
+
+// The example from the paper.
+func TestUpdate(t *testing.T) {
+	r := Rating{1500, 200, 0.06}.Update([]Result{
+		{Rating{1400, 30, 0.06}, 1},
+		{Rating{1550, 100, 0.06}, 0},
+		{Rating{1700, 300, 0.06}, 0},
+	})
+	want := Rating{1464.06, 151.52, 0.05999}
+	if math.Abs(r.Rating-want.Rating) > 0.01 || math.Abs(r.Deviation-want.Deviation) > 0.01 ||
+		math.Abs(r.Volatility-want.Volatility) > 0.00001 {
+		t.Errorf("Update() = %+v, want %+v", r, want)
+	}
+}
+
+func TestNoGames(t *testing.T) {
+	r := New().Update(nil)
+	if r.Rating != DefaultRating || r.Deviation <= DefaultDeviation {
+		t.Errorf("Expected sitting out to only raise the deviation, got %+v", r)
+	}
+}
+
+func TestWinnerGoesUp(t *testing.T) {
+	a, b := New(), New()
+	a2 := a.Update([]Result{{b, 1}})
+	b2 := b.Update([]Result{{a, 0}})
+	if a2.Rating <= a.Rating || b2.Rating >= b.Rating {
+		t.Errorf("Expected the winner to go up and the loser down, got %+v and %+v", a2, b2)
+	}
+	if math.Abs((a2.Rating-DefaultRating)+(b2.Rating-DefaultRating)) > 0.01 {
+		t.Errorf("Expected equal players to trade the same number of points, got %+v and %+v", a2, b2)
+	}
+}
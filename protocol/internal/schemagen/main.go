@@ -0,0 +1,22 @@
+// Command schemagen writes the JSON Schema of the scopa protocol, run it with `go generate`.
+package main
+
+import (
+	"flag"
+	"github.com/sbadame/scopa/protocol"
+	"io/ioutil"
+	"log"
+)
+
+var out = flag.String("o", "schema.json", "The file to write the schema to.")
+
+func main() {
+	flag.Parse()
+	b, err := protocol.JSONSchema()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(*out, append(b, '\n'), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
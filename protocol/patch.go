@@ -0,0 +1,175 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PatchOp is an operation of a JSON Patch (RFC 6902). Diff only uses "add", "remove" and "replace".
+type PatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"` // A JSON Pointer (RFC 6901).
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Diff returns the patch that turns the JSON document from into to.
+// Arrays that change length are replaced as a whole, which keeps the patches simple to apply.
+func Diff(from, to []byte) ([]PatchOp, error) {
+	var a, b interface{}
+	if err := json.Unmarshal(from, &a); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(to, &b); err != nil {
+		return nil, err
+	}
+	var ops []PatchOp
+	if err := diff("", a, b, &ops); err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+func diff(path string, a, b interface{}, ops *[]PatchOp) error {
+	switch a := a.(type) {
+	case map[string]interface{}:
+		if b, ok := b.(map[string]interface{}); ok {
+			// Sorted, so that the same change always makes the same patch.
+			for _, k := range sortedKeys(a) {
+				bv, ok := b[k]
+				if !ok {
+					*ops = append(*ops, PatchOp{Op: "remove", Path: path + "/" + escape(k)})
+					continue
+				}
+				if err := diff(path+"/"+escape(k), a[k], bv, ops); err != nil {
+					return err
+				}
+			}
+			for _, k := range sortedKeys(b) {
+				if _, ok := a[k]; !ok {
+					if err := add(ops, "add", path+"/"+escape(k), b[k]); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}
+	case []interface{}:
+		if b, ok := b.([]interface{}); ok && len(a) == len(b) {
+			for i := range a {
+				if err := diff(path+"/"+strconv.Itoa(i), a[i], b[i], ops); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	if reflect.DeepEqual(a, b) {
+		return nil
+	}
+	return add(ops, "replace", path, b)
+}
+
+func add(ops *[]PatchOp, op, path string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	*ops = append(*ops, PatchOp{Op: op, Path: path, Value: b})
+	return nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// escape escapes a key for use in a JSON Pointer.
+func escape(k string) string {
+	return strings.Replace(strings.Replace(k, "~", "~0", -1), "/", "~1", -1)
+}
+
+func unescape(k string) string {
+	return strings.Replace(strings.Replace(k, "~1", "/", -1), "~0", "~", -1)
+}
+
+// Apply applies the operations made by Diff to the JSON document doc, and returns the patched document.
+func Apply(doc []byte, ops []PatchOp) ([]byte, error) {
+	var root interface{}
+	if err := json.Unmarshal(doc, &root); err != nil {
+		return nil, err
+	}
+
+	for _, op := range ops {
+		var v interface{}
+		if op.Op != "remove" {
+			if err := json.Unmarshal(op.Value, &v); err != nil {
+				return nil, fmt.Errorf("%s %s has a bad value: %v", op.Op, op.Path, err)
+			}
+		}
+		if op.Path == "" {
+			if op.Op != "replace" {
+				return nil, fmt.Errorf("can only replace the whole document, not %s it", op.Op)
+			}
+			root = v
+			continue
+		}
+
+		// Find the parent of the value that the operation is on.
+		keys := strings.Split(op.Path, "/")[1:]
+		parent := root
+		for _, k := range keys[:len(keys)-1] {
+			next, err := child(parent, unescape(k))
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %v", op.Op, op.Path, err)
+			}
+			parent = next
+		}
+
+		last := unescape(keys[len(keys)-1])
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			_, exists := p[last]
+			switch {
+			case op.Op == "add":
+				p[last] = v
+			case op.Op == "replace" && exists:
+				p[last] = v
+			case op.Op == "remove" && exists:
+				delete(p, last)
+			default:
+				return nil, fmt.Errorf("can't %s %s", op.Op, op.Path)
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(last)
+			if err != nil || i < 0 || i >= len(p) || op.Op != "replace" {
+				return nil, fmt.Errorf("can't %s %s", op.Op, op.Path)
+			}
+			p[i] = v
+		default:
+			return nil, fmt.Errorf("%s %s isn't in an object or array", op.Op, op.Path)
+		}
+	}
+	return json.Marshal(root)
+}
+
+func child(v interface{}, k string) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if c, ok := v[k]; ok {
+			return c, nil
+		}
+	case []interface{}:
+		if i, err := strconv.Atoi(k); err == nil && i >= 0 && i < len(v) {
+			return v[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%q doesn't exist", k)
+}
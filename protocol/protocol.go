@@ -0,0 +1,264 @@
+// Package protocol defines the messages that the scopa server and its clients exchange over the /join websocket.
+//
+// Clients pass the versions they understand in the Version query parameter of /join, e.g. "/join?Version=1".
+// The server picks the highest one that it also supports, and reports it in the first message.
+// Clients that don't pass a version get version 1.
+//
+// Changes by version:
+//
+//	1: Every event with a State has it in full.
+//	2: Events usually carry a Patch against the previous State instead, see ServerMessage.
+//	3: The server sends a Ping every so often, and clients answer it with a pong command. It also tells the
+//	   players whether eachother are still there with Presence.
+package protocol
+
+//go:generate go run ./internal/schemagen -o schema.json
+
+import (
+	"fmt"
+	"github.com/sbadame/scopa/scopa"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version is the newest version of the protocol.
+const Version = 3
+
+// SupportedVersions are all of the versions that this package can speak, oldest first.
+var SupportedVersions = []int{1, 2, 3}
+
+// Negotiate picks the newest version that is in both offered (a comma separated list, as sent to /join) and
+// SupportedVersions. Clients that don't offer anything get version 1.
+func Negotiate(offered string) (int, error) {
+	if offered == "" {
+		return 1, nil
+	}
+
+	best := 0
+	for _, o := range strings.Split(offered, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(o))
+		if err != nil {
+			return 0, fmt.Errorf("bad protocol version %q", o)
+		}
+		for _, s := range SupportedVersions {
+			if v == s && v > best {
+				best = v
+			}
+		}
+	}
+	if best == 0 {
+		return 0, fmt.Errorf("none of the protocol versions %s are supported, try one of %v", offered, SupportedVersions)
+	}
+	return best, nil
+}
+
+// ServerMessage is every message that the server sends over the websocket, only some of the fields are set in
+// each one:
+//
+//  1. Version, MatchID and Token as soon as the player has a seat.
+//  2. Nicknames, Ratings, Scorecard and ChatPresets once the game has started.
+//  3. Events numbered by Seq, with either State (and Clock) or Chat. From version 2, most states are sent as a
+//     Patch to apply to the State of event Base. Clients that don't have that state should send a resync
+//     command, the next state is then sent in full. Full states are also sent every so often, and first
+//     thing after connecting.
+//     Once the game is over, events with Rematch follow the rematch offer. When one is accepted, the event with
+//     the new game's State also has the Nicknames and Scorecard again.
+//     While the game is being played, events with Proposal tell the players that the other one wants to end it
+//     early. Proposals are withdrawn by the next move.
+//  4. Ack or Error in reply to every Command.
+//  5. Message when the server has something to say that isn't tied to a command, usually before hanging up.
+//  6. From version 3, Ping every HeartbeatInterval, and Presence whenever a player comes and goes. Neither has a
+//     Seq, and the latest Presence is also sent with the Nicknames.
+type ServerMessage struct {
+	Version int    `json:",omitempty"`
+	MatchID int64  `json:",omitempty"`
+	Token   string `json:",omitempty"` // Secret that holds the seat, send it back to reconnect.
+
+	Nicknames   map[int]string    `json:",omitempty"` // By seat, starting at 1.
+	Ratings     map[string]Rating `json:",omitempty"` // By nickname, left out for players without a rating.
+	Scorecard   map[string]int    `json:",omitempty"` // Points won against eachother by nickname.
+	ChatPresets map[string]string `json:",omitempty"` // Quick phrases by key.
+
+	Seq      int       `json:",omitempty"` // Send the last one seen as LastSeq when reconnecting to catch up.
+	State    *State    `json:",omitempty"`
+	Base     int       `json:",omitempty"` // The Seq of the State that Patch applies to.
+	Patch    []PatchOp `json:",omitempty"` // Applied to the JSON of the State of Base, gives the JSON of this State.
+	Clock    *Clock    `json:",omitempty"`
+	Chat     *Chat     `json:",omitempty"`
+	Rematch  *Rematch  `json:",omitempty"`
+	Proposal *Proposal `json:",omitempty"`
+
+	Ack   *Ack   `json:",omitempty"`
+	Error *Error `json:",omitempty"`
+
+	Message string `json:",omitempty"`
+
+	Ping     int64             `json:",omitempty"` // Unix milliseconds, answer it with a pong command.
+	Presence map[string]string `json:",omitempty"` // Whether each player is there, by nickname.
+}
+
+// HeartbeatInterval is how often the server sends a Ping over the websocket. Connections that don't send anything
+// for HeartbeatTimeout, like a pong command, are closed.
+const (
+	HeartbeatInterval = 15 * time.Second
+	HeartbeatTimeout  = 3 * HeartbeatInterval
+)
+
+// Whether a player is there, see ServerMessage.Presence.
+const (
+	Online       = "online"
+	Away         = "away" // Connected, but not looking, e.g. the game is in a hidden browser tab.
+	Disconnected = "disconnected"
+	Substituted  = "substituted" // Disconnected for a while, so the server's bot is playing for them.
+)
+
+// State is the game as one player sees it.
+type State struct {
+	Variant              string `json:",omitempty"` // See the scopa package, "" is plain scopa.
+	NextPlayer           string
+	LastPlayerToTake     string
+	Table                []scopa.Card
+	Players              []scopa.Player // Everyone's hand but your own is left out.
+	Player               scopa.Player   // You.
+	LastMove             Move
+	Ended                bool
+	Forfeited            string
+	Ending               string `json:",omitempty"` // How the game ended early, "" when it was played out.
+	RemainingCardsInDeck int
+}
+
+// How a game can end early, see State.Ending.
+const (
+	Resigned  = "resigned"
+	TimedOut  = "timeout"
+	Abandoned = "abandoned" // The player disconnected and didn't come back in time.
+	Aborted   = "aborted"   // Both players agreed to throw the game away, it doesn't count.
+	Drawn     = "drawn"     // Both players agreed to a draw.
+)
+
+// Rating is a player's Glicko-2 rating, their true skill is within 2 Deviations of Rating with 95% confidence.
+type Rating struct {
+	Rating    int
+	Deviation int
+}
+
+// Move is the last move, only one of Drop or Take is set.
+type Move struct {
+	Drop *Drop
+	Take *Take
+}
+
+// Drop is a card played onto the table.
+type Drop struct {
+	Player string
+	Card   scopa.Card
+}
+
+// Take is a card played to capture Table.
+type Take struct {
+	Player string
+	Card   scopa.Card
+	Table  []scopa.Card
+}
+
+// The different kinds of time controls.
+const (
+	MoveClock      = "move"           // Every move has to be made in time, or a card is dropped for you.
+	TotalClock     = "total"          // Chess style, time for the whole game plus an increment per move.
+	Correspondence = "correspondence" // Every move has to be made in time (usually days), or you forfeit.
+)
+
+// Clock is the time that players have left.
+type Clock struct {
+	Mode      string
+	Remaining map[string]int64 // Milliseconds left by nickname, the time of the player to move is running.
+	Deadline  int64            // Unix milliseconds when the player to move runs out of time, 0 if nobody is.
+}
+
+// Chat is a message broadcast to the match.
+type Chat struct {
+	From string
+	Text string
+	Time int64 // Unix milliseconds.
+}
+
+// The statuses of a rematch offer.
+const (
+	RematchOffered  = "offered"
+	RematchAccepted = "accepted" // The new game has started.
+	RematchDeclined = "declined"
+	RematchExpired  = "expired" // Nobody accepted, or offered, in time.
+)
+
+// Rematch is where the offer to play again stands. Once it's declined or has expired the match is over for good,
+// and the players have to join again to play someone.
+type Rematch struct {
+	Status  string
+	From    string         `json:",omitempty"` // Nickname of who offered it, empty when nobody did in time.
+	Options RematchOptions // What the offer was.
+	Expires int64          `json:",omitempty"` // Unix milliseconds, while it's being offered.
+}
+
+// RematchOptions change how the rematch is played, the zero value plays it like the last game.
+type RematchOptions struct {
+	SwapSeats   bool    `json:",omitempty"` // The player that the scoreboard wouldn't pick moves first.
+	Variant     string  `json:",omitempty"` // See the scopa package, e.g. "scopa" or "asso-piglia-tutto".
+	Partita     int     `json:",omitempty"` // Extends the partita being played to this many points.
+	TimeControl *string `json:",omitempty"` // Like "move:30s", "" for no clock.
+}
+
+// Proposal is a player's offer to end the game early, which the other player accepts by proposing the same.
+type Proposal struct {
+	Type string // AbortCommand or DrawCommand.
+	From string // Nickname.
+}
+
+// The types of commands.
+const (
+	DropCommand     = "drop"
+	TakeCommand     = "take"
+	ChatCommand     = "chat"
+	ResignCommand   = "resign"
+	PingCommand     = "ping"
+	PongCommand     = "pong"     // Answers the server's Ping.
+	PresenceCommand = "presence" // Tells the other player whether you're Online or Away.
+	ResyncCommand   = "resync"   // Asks for the next State to be sent in full.
+	AbortCommand    = "abort"    // Proposes, or agrees, to throw the game away before the first hand is played out.
+	DrawCommand     = "draw"     // Proposes, or agrees, to end the game as a draw.
+
+	OfferRematchCommand   = "offer-rematch" // Once the game is over.
+	AcceptRematchCommand  = "accept-rematch"
+	DeclineRematchCommand = "decline-rematch"
+)
+
+// Command is a request from a client. ID is picked by the client, and is used to tie the Ack or Error to it.
+type Command struct {
+	ID     string
+	Type   string
+	Card   *scopa.Card  `json:",omitempty"` // drop and take
+	Table  []scopa.Card `json:",omitempty"` // take
+	Text   string       `json:",omitempty"` // chat
+	Preset string       `json:",omitempty"` // chat, the key of a quick phrase to send instead of Text.
+
+	Rematch  *RematchOptions `json:",omitempty"` // offer-rematch
+	Presence string          `json:",omitempty"` // presence
+}
+
+// Ack tells the client that the command with ID succeeded.
+// Seq is the last event at the time, so the client knows when its state includes the effects of the command.
+type Ack struct {
+	ID  string
+	Seq int
+}
+
+// Error tells the client why the command with ID failed. Code follows the HTTP status codes.
+type Error struct {
+	ID      string `json:",omitempty"`
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
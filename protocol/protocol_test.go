@@ -0,0 +1,116 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/google/go-cmp/cmp"
+	"github.com/sbadame/scopa/scopa"
+	"io/ioutil"
+	"testing"
+)
+
+func TestSchemaIsUpToDate(t *testing.T) {
+	want, err := JSONSchema()
+	if err != nil {
+		t.Fatalf("Couldn't generate the schema: %v", err)
+	}
+	got, err := ioutil.ReadFile("schema.json")
+	if err != nil {
+		t.Fatalf("Couldn't read schema.json: %v", err)
+	}
+	if !bytes.Equal(append(want, '\n'), got) {
+		t.Errorf("schema.json is out of date, run `go generate github.com/sbadame/scopa/protocol`")
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	for offered, want := range map[string]int{"": 1, "1": 1, "1, 7": 1, "1,2": 2, "2": 2} {
+		if got, err := Negotiate(offered); err != nil || got != want {
+			t.Errorf("Negotiate(%q) = %d, %v, wanted %d", offered, got, err, want)
+		}
+	}
+	for _, offered := range []string{"7", "one"} {
+		if _, err := Negotiate(offered); err == nil {
+			t.Errorf("Negotiate(%q) should fail", offered)
+		}
+	}
+}
+
+// The server sends scopa.Game.JSONForPlayer as the State, so they have to stay in sync.
+func TestStateMatchesEngine(t *testing.T) {
+	g := scopa.NewGame([]string{"a", "b"})
+	g.Drop(g.Players[0].Hand[0])
+	b, err := g.JSONForPlayer("b")
+	if err != nil {
+		t.Fatalf("Couldn't get the state: %v", err)
+	}
+
+	// Nothing is lost going through State.
+	var s State
+	if err := json.Unmarshal(b, &s); err != nil {
+		t.Fatalf("JSONForPlayer doesn't match State: %v", err)
+	}
+	again, err := json.Marshal(s)
+	if err != nil {
+		t.Fatalf("Couldn't marshal the state: %v", err)
+	}
+	var want, got interface{}
+	json.Unmarshal(b, &want)
+	json.Unmarshal(again, &got)
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("JSONForPlayer doesn't match State (-engine +protocol):\n%s", d)
+	}
+	if s.LastMove.Drop == nil || len(s.Player.Hand) != 3 || s.Players[0].Hand != nil {
+		t.Errorf("Expected b to see a's drop and only their own hand: %#v", s)
+	}
+}
+
+func TestPatch(t *testing.T) {
+	g := scopa.NewGame([]string{"a", "b"})
+	var states [][]byte
+	for !g.Ended() {
+		b, err := g.JSONForPlayer("a")
+		if err != nil {
+			t.Fatalf("Couldn't get the state: %v", err)
+		}
+		states = append(states, b)
+		for _, p := range g.Players {
+			if p.Name == g.NextPlayer {
+				g.Drop(p.Hand[0])
+			}
+		}
+	}
+
+	for i := 1; i < len(states); i++ {
+		ops, err := Diff(states[i-1], states[i])
+		if err != nil {
+			t.Fatalf("Diff failed: %v", err)
+		}
+		got, err := Apply(states[i-1], ops)
+		if err != nil {
+			t.Fatalf("Apply(%s) failed: %v", ops, err)
+		}
+		var want, gotState State
+		json.Unmarshal(states[i], &want)
+		json.Unmarshal(got, &gotState)
+		if d := cmp.Diff(want, gotState); d != "" {
+			t.Errorf("Move %d: patched state mismatch (-want +got):\n%s", i, d)
+		}
+		if b, _ := json.Marshal(ops); len(b) >= len(states[i]) {
+			t.Errorf("Move %d: the patch is no smaller than the state: %s", i, b)
+		}
+	}
+
+	for _, bad := range []struct {
+		doc string
+		op  PatchOp
+	}{
+		{`{"a": 1}`, PatchOp{Op: "replace", Path: "/b", Value: json.RawMessage("1")}},
+		{`{"a": []}`, PatchOp{Op: "replace", Path: "/a/0", Value: json.RawMessage("1")}},
+		{`{"a": 1}`, PatchOp{Op: "move", Path: "/a"}},
+	} {
+		if _, err := Apply([]byte(bad.doc), []PatchOp{bad.op}); err == nil {
+			t.Errorf("Apply(%s, %v) should fail", bad.doc, bad.op)
+		}
+	}
+}
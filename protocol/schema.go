@@ -0,0 +1,129 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sbadame/scopa/scopa"
+	"reflect"
+	"strings"
+)
+
+// JSONSchema returns a JSON Schema (draft 7) describing ServerMessage and Command. It's checked in as
+// schema.json for clients that aren't written in Go.
+func JSONSchema() ([]byte, error) {
+	g := schemaGen{defs: make(map[string]interface{})}
+	s := map[string]interface{}{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"$id":     fmt.Sprintf("https://github.com/sbadame/scopa/protocol/v%d/schema.json", Version),
+		"title":   fmt.Sprintf("Scopa protocol version %d", Version),
+		"description": "Messages on the /join websocket. The server sends ServerMessages, " +
+			"clients send Commands.",
+		"anyOf": []interface{}{
+			g.schema(reflect.TypeOf(ServerMessage{})),
+			g.schema(reflect.TypeOf(Command{})),
+		},
+	}
+	s["definitions"] = g.defs
+	return json.MarshalIndent(s, "", "  ")
+}
+
+type schemaGen struct {
+	defs map[string]interface{}
+}
+
+func ref(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/definitions/" + name}
+}
+
+func nullable(s map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"oneOf": []interface{}{map[string]interface{}{"type": "null"}, s}}
+}
+
+// schema returns the schema for values of type t, adding structs to the definitions as it goes.
+func (g *schemaGen) schema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case reflect.TypeOf(scopa.Card{}):
+		// Cards add a Name when marshaled.
+		if _, ok := g.defs["Card"]; !ok {
+			g.defs["Card"] = map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"Suit": map[string]interface{}{
+						"type": "string",
+						"enum": []string{scopa.Denari, scopa.Coppe, scopa.Bastoni, scopa.Spade},
+					},
+					"Value": map[string]interface{}{"type": "integer", "minimum": 1, "maximum": 10},
+					"Name":  map[string]interface{}{"type": "string", "description": "Only sent by the server."},
+				},
+				"required": []string{"Suit", "Value"},
+			}
+		}
+		return ref("Card")
+	case reflect.TypeOf(json.RawMessage{}):
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Ptr:
+		return nullable(g.schema(t.Elem()))
+	case reflect.Slice:
+		return nullable(map[string]interface{}{"type": "array", "items": g.schema(t.Elem())})
+	case reflect.Map:
+		m := map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+		if t.Key().Kind() != reflect.String {
+			m["propertyNames"] = map[string]interface{}{"pattern": "^-?[0-9]+$"}
+		}
+		return nullable(m)
+	case reflect.Struct:
+		if _, ok := g.defs[t.Name()]; ok {
+			return ref(t.Name())
+		}
+		g.defs[t.Name()] = nil // Reserve the name in case the type refers to itself.
+
+		props := make(map[string]interface{})
+		required := make([]string, 0)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue // Unexported.
+			}
+			name, opts := f.Name, ""
+			if tag, ok := f.Tag.Lookup("json"); ok {
+				parts := strings.SplitN(tag, ",", 2)
+				if parts[0] == "-" {
+					continue
+				}
+				if parts[0] != "" {
+					name = parts[0]
+				}
+				if len(parts) == 2 {
+					opts = parts[1]
+				}
+			}
+			fs := g.schema(f.Type)
+			if strings.Contains(opts, "omitempty") {
+				// Omitted rather than null.
+				if oneOf, ok := fs["oneOf"].([]interface{}); ok {
+					fs = oneOf[1].(map[string]interface{})
+				}
+			} else {
+				required = append(required, name)
+			}
+			props[name] = fs
+		}
+		g.defs[t.Name()] = map[string]interface{}{
+			"type":                 "object",
+			"properties":           props,
+			"required":             required,
+			"additionalProperties": false,
+		}
+		return ref(t.Name())
+	}
+	panic(fmt.Sprintf("no JSON schema for %v", t))
+}
@@ -1,3 +1,3 @@
 #!/bin/bash
 
-exec go run github.com/sbadame/scopa/cmd/...
+exec go run github.com/sbadame/scopa/cmd
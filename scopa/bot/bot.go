@@ -0,0 +1,262 @@
+// Package bot is a computer scopa player. Easy takes whatever it can, and Medium plays by the rules of thumb that
+// players learn at the table: it counts the cards, to keep from leaving scopas and to hold on to the primiera cards.
+// Hard searches for its moves by playing out the rest of the game like Medium would, with every hand the opponent
+// could be holding.
+package bot
+
+import (
+	"fmt"
+	"github.com/sbadame/scopa/scopa"
+	"math"
+	"math/rand"
+	"strings"
+)
+
+// Level is how well a Bot plays.
+type Level int
+
+// The levels that a Bot plays at, the zero value plays like Medium.
+const (
+	Easy   Level = iota + 1 // Takes something whenever it can, and otherwise drops any card.
+	Medium                  // Counts the cards, to take the most and leave the opponent the least.
+	Hard                    // Plays games out before every move to find the best one, see Analyze.
+)
+
+var levelNames = map[Level]string{Easy: "easy", Medium: "medium", Hard: "hard"}
+
+// String is the level's name, as ParseLevel takes it.
+func (l Level) String() string {
+	if n, ok := levelNames[l]; ok {
+		return n
+	}
+	return levelNames[Medium]
+}
+
+// ParseLevel parses the name of a level, like "hard".
+func ParseLevel(s string) (Level, error) {
+	for l, n := range levelNames {
+		if strings.EqualFold(s, n) {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("The level should be %s, %s or %s, not %q.", Easy, Medium, Hard, s)
+}
+
+// Moves returns the moves that the player whose turn it is in g can make, see scopa.Game.LegalMoves.
+func Moves(g scopa.Game) []scopa.Move {
+	return g.LegalMoves()
+}
+
+// hand is the hand of the player whose turn it is in g.
+func hand(g scopa.Game) []scopa.Card {
+	for _, p := range g.Players {
+		if p.Name == g.NextPlayer {
+			return p.Hand
+		}
+	}
+	return nil
+}
+
+// sweep is whether mv is an ace taking the whole table in asso piglia tutto, which isn't a scopa.
+func sweep(g scopa.Game, mv scopa.Move) bool {
+	return g.Variant == scopa.AssoPigliaTutto && mv.Card.Value == 1 && len(mv.Table) > 0 &&
+		len(mv.Table) == len(g.Table) && !(len(mv.Table) == 1 && mv.Table[0].Value == 1)
+}
+
+// Bot picks moves at its level. A Bot never looks at the opponent's hand, or at the deck.
+type Bot struct {
+	level      Level
+	rand       *rand.Rand
+	iterations int // The games that Hard plays out before every move.
+}
+
+// New returns a bot playing at level, with seed for its random choices.
+func New(level Level, seed int64) *Bot {
+	if _, ok := levelNames[level]; !ok {
+		level = Medium
+	}
+	return &Bot{level, rand.New(rand.NewSource(seed)), searchIterations}
+}
+
+// Move picks the move for the player whose turn it is in g. It returns the zero Move when they have nothing to play.
+func (b *Bot) Move(g scopa.Game) scopa.Move {
+	moves := Moves(g)
+	if len(moves) == 0 {
+		return scopa.Move{}
+	}
+	switch b.level {
+	case Easy:
+		var takes []scopa.Move
+		for _, mv := range moves {
+			if len(mv.Table) > 0 {
+				takes = append(takes, mv)
+			}
+		}
+		if len(takes) > 0 {
+			return takes[b.rand.Intn(len(takes))]
+		}
+		return moves[b.rand.Intn(len(moves))]
+	case Hard:
+		return searchMove(g, b.iterations, b.rand)
+	}
+	return countingMove(g, moves)
+}
+
+// worth is roughly how much a card helps towards the awards.
+func worth(c scopa.Card) int {
+	w := 1
+	if c.Suit == scopa.Denari {
+		w += 2
+	}
+	if c.Value == 7 {
+		w += 3
+		if c.Suit == scopa.Denari {
+			w += 10 // The sette bello.
+		}
+	}
+	return w
+}
+
+// scopaWorth is what the bots make of a scopa, in the same units as worth.
+const scopaWorth = 20
+
+// hardWorth is worth, with more for the sevens and then the sixes and aces that win the primiera.
+func hardWorth(c scopa.Card) float64 {
+	w := worth(c)
+	switch c.Value {
+	case 7:
+		w += 2
+	case 6, 1:
+		w++
+	}
+	return float64(w)
+}
+
+// countingMove is how Medium plays. It takes whenever it can, and scores every move by what it takes, less what the
+// opponent can expect to take from the table that it leaves: a scopa when the table adds up to 10 or less, or its
+// cards one at a time. Dropping a card gives it away, and the opponent's hand is guessed from the cards that haven't
+// been seen yet.
+func countingMove(g scopa.Game, moves []scopa.Move) scopa.Move {
+	takes := len(moves[0].Table) > 0 // LegalMoves lists the captures first.
+	u := newUnseen(g)
+	var best scopa.Move
+	bestScore := math.Inf(-1)
+	left := make([]scopa.Card, 0, len(g.Table)+1)
+	for _, mv := range moves {
+		var score float64
+		left = left[:0]
+		switch {
+		case len(mv.Table) > 0:
+			score += hardWorth(mv.Card)
+			for _, t := range mv.Table {
+				score += hardWorth(t)
+			}
+			if len(mv.Table) == len(g.Table) && !sweep(g, mv) {
+				score += scopaWorth
+			}
+			for _, t := range g.Table {
+				if !contains(mv.Table, t) {
+					left = append(left, t)
+				}
+			}
+		case takes:
+			continue
+		default:
+			score -= hardWorth(mv.Card)
+			left = append(append(left, g.Table...), mv.Card)
+		}
+		if score -= u.risk(g, left); score > bestScore {
+			best, bestScore = mv, score
+		}
+	}
+	return best
+}
+
+func contains(cs []scopa.Card, c scopa.Card) bool {
+	for _, x := range cs {
+		if x == c {
+			return true
+		}
+	}
+	return false
+}
+
+// unseen is what the player whose turn it is knows about the rest of the cards.
+type unseen struct {
+	byValue [11]int // How many cards of each value haven't been seen.
+	total   int
+	hand    int // How many cards the opponent holds.
+}
+
+func newUnseen(g scopa.Game) unseen {
+	var u unseen
+	seen := func(cs []scopa.Card) {
+		for _, c := range cs {
+			u.byValue[c.Value]--
+			u.total--
+		}
+	}
+	for _, c := range deck {
+		u.byValue[c.Value]++
+		u.total++
+	}
+	seen(g.Table)
+	for _, p := range g.Players {
+		seen(p.Grabbed)
+		if p.Name == g.NextPlayer {
+			seen(p.Hand)
+		} else {
+			u.hand = len(p.Hand) // Everyone can count the cards in a hand.
+		}
+	}
+	if u.hand == 0 && len(g.Deck) > 0 {
+		u.hand = 3 // They're dealt a new hand, and move first.
+	}
+	return u
+}
+
+// holds is the chance that the opponent holds at least one card of value.
+func (u unseen) holds(value int) float64 {
+	if value < 1 || value > 10 {
+		return 0
+	}
+	k := u.byValue[value]
+	if k <= 0 || u.hand == 0 {
+		return 0
+	}
+	none := 1.0
+	for i := 0; i < u.hand; i++ {
+		if u.total-i <= 0 {
+			return 1
+		}
+		none *= float64(u.total-k-i) / float64(u.total-i)
+		if none <= 0 {
+			return 1
+		}
+	}
+	return 1 - none
+}
+
+// risk is what the opponent can expect to take from table on their turn.
+func (u unseen) risk(g scopa.Game, table []scopa.Card) float64 {
+	if len(table) == 0 {
+		return 0
+	}
+	sum, all := 0, 0.0
+	for _, t := range table {
+		sum += t.Value
+		all += hardWorth(t)
+	}
+	var r float64
+	if sum <= 10 {
+		r = u.holds(sum) * (all + scopaWorth)
+	}
+	if g.Variant == scopa.AssoPigliaTutto {
+		r = math.Max(r, u.holds(1)*all)
+	}
+	// Otherwise they pick off the cards one at a time.
+	for _, t := range table {
+		r = math.Max(r, u.holds(t.Value)*hardWorth(t))
+	}
+	return r
+}
@@ -0,0 +1,201 @@
+package bot
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"github.com/sbadame/scopa/scopa"
+	"testing"
+)
+
+func cards(t *testing.T, ss ...string) []scopa.Card {
+	var cs []scopa.Card
+	for _, s := range ss {
+		c, err := scopa.ParseCard(s)
+		if err != nil {
+			t.Fatalf("Bad card %s: %v", s, err)
+		}
+		cs = append(cs, c)
+	}
+	return cs
+}
+
+func TestMove(t *testing.T) {
+	// The move of card, taking table.
+	mv := func(card string, table ...string) scopa.Move {
+		return scopa.Move{Card: cards(t, card)[0], Table: cards(t, table...)}
+	}
+	for _, tc := range []struct {
+		name        string
+		level       Level
+		hand, table []string
+		want        scopa.Move
+	}{
+		{"sette bello", Medium, []string{"7D", "3C"}, []string{"7C", "4B", "3S"}, mv("7D", "7C")},
+		{"face match first", Medium, []string{"RC"}, []string{"RB", "6B", "4S"}, mv("RC", "RB")},
+		{"scopa", Medium, []string{"5C", "2D"}, []string{"2B", "3S"}, mv("5C", "2B", "3S")},
+		// The 2 would leave 10 on the table for a scopa.
+		{"drop", Medium, []string{"7D", "2C", "9S"}, []string{"8C"}, mv("9S")},
+		// Dropping the 3 would leave 7 on the table, and there are plenty of sevens out there.
+		{"no scopa to leave", Medium, []string{"3C", "RS"}, []string{"4B"}, mv("RS")},
+		// Both leave a 4, but the 7 is worth more for the primiera.
+		{"hold the seven", Medium, []string{"7C", "AC"}, []string{"3B"}, mv("AC")},
+		{"zero value is medium", 0, []string{"3C", "RS"}, []string{"4B"}, mv("RS")},
+		{"hard scopa", Hard, []string{"5C", "2D"}, []string{"2B", "3S"}, mv("5C", "2B", "3S")},
+		{"hard sette bello", Hard, []string{"7D", "3C"}, []string{"7C", "4B", "3S"}, mv("7D", "7C")},
+		// Dropping the 3 would leave 7 on the table, and there are plenty of sevens out there.
+		{"no scopa to leave", Hard, []string{"3C", "RS"}, []string{"4B"}, mv("RS")},
+	} {
+		// b holds as many cards as a, and there are whole hands left in the deck. The bot doesn't know which.
+		g := scopa.Game{NextPlayer: "a", Players: []scopa.Player{{Name: "a"}, {Name: "b"}}}
+		g.Players[0].Hand = cards(t, tc.hand...)
+		g.Players[1].Hand = make([]scopa.Card, len(tc.hand))
+		g.Deck = make([]scopa.Card, 24)
+		g.Table = cards(t, tc.table...)
+		if d := cmp.Diff(tc.want, New(tc.level, 1).Move(g)); d != "" {
+			t.Errorf("%s: mismatch (-want +got):\n%s", tc.name, d)
+		}
+	}
+}
+
+func TestEasyTakes(t *testing.T) {
+	g := scopa.Game{NextPlayer: "a", Players: []scopa.Player{{Name: "a", Hand: cards(t, "2C", "9S", "RB")}}}
+	g.Table = cards(t, "5B", "4S")
+	b := New(Easy, 1)
+	for i := 0; i < 10; i++ {
+		if mv := b.Move(g); len(mv.Table) == 0 {
+			t.Fatalf("Expected the easy bot to take the 5 and 4 with the 9, got %+v", mv)
+		}
+	}
+}
+
+func TestMoves(t *testing.T) {
+	g := scopa.Game{NextPlayer: "a", Players: []scopa.Player{{Name: "a", Hand: cards(t, "AC")}}}
+	g.Table = cards(t, "AB", "4S")
+	ace := cards(t, "AC")[0]
+	want := []scopa.Move{{Card: ace, Table: cards(t, "AB")}, {Card: ace}}
+	if d := cmp.Diff(want, Moves(g)); d != "" {
+		t.Errorf("scopa mismatch (-want +got):\n%s", d)
+	}
+
+	g.Variant = scopa.AssoPigliaTutto
+	want = []scopa.Move{{Card: ace, Table: cards(t, "AB")}, {Card: ace, Table: cards(t, "AB", "4S")}, {Card: ace}}
+	if d := cmp.Diff(want, Moves(g)); d != "" {
+		t.Errorf("%s mismatch (-want +got):\n%s", scopa.AssoPigliaTutto, d)
+	}
+}
+
+// duel is how many points a wins by on average, playing b from both seats of every deal from seeds. Deals that
+// start with more than three cards in a hand are left out.
+func duel(t testing.TB, seeds int, a, b func(scopa.Game) scopa.Move) float64 {
+	points, deals := 0, 0
+	for seed := int64(0); seed < int64(seeds); seed++ {
+		if g := scopa.NewSeededGame([]string{"a", "b"}, seed); len(g.Players[0].Hand) != 3 {
+			continue
+		}
+		deals++
+		for _, first := range []string{"a", "b"} {
+			g := scopa.NewSeededGame([]string{"a", "b"}, seed)
+			for !g.Ended() {
+				mv := b(g)
+				if g.NextPlayer == first {
+					mv = a(g)
+				}
+				if err := g.Play(mv); err != nil {
+					t.Fatalf("%+v isn't legal: %v", mv, err)
+				}
+			}
+			for _, p := range g.Players {
+				if p.Name == first {
+					points += len(p.Awards) + p.Scopas
+				} else {
+					points -= len(p.Awards) + p.Scopas
+				}
+			}
+		}
+	}
+	return float64(points) / float64(deals)
+}
+
+func counting(g scopa.Game) scopa.Move { return countingMove(g, g.LegalMoves()) }
+
+// TestMediumWins checks that counting the cards beats taking whatever's there, by almost two points a deal.
+func TestMediumWins(t *testing.T) {
+	if m := duel(t, 300, New(Medium, 1).Move, New(Easy, 1).Move); m <= 0 {
+		t.Errorf("Expected medium to win more points than easy, won by %.2f a deal", m)
+	}
+}
+
+// BenchmarkHard reports how many points a deal Hard wins by against the rules of thumb that it plays its games out
+// with. Over the first 150 deals, -benchtime 150x, it won by 0.8 points a deal.
+func BenchmarkHard(b *testing.B) {
+	hard := New(Hard, 1)
+	m := duel(b, b.N, hard.Move, counting)
+	b.ReportMetric(m, "points/deal")
+}
+
+// TestWholeGames has every level play every other, and checks that all of their moves are legal.
+func TestWholeGames(t *testing.T) {
+	for _, variant := range []string{"", scopa.AssoPigliaTutto} {
+		for _, a := range []Level{Easy, Medium, Hard} {
+			for _, b := range []Level{Easy, Medium, Hard} {
+				g := scopa.NewSeededGame([]string{"a", "b"}, int64(a)*10+int64(b))
+				g.Variant = variant
+				bots := map[string]*Bot{"a": New(a, 1), "b": New(b, 2)}
+				for _, b := range bots {
+					b.iterations = 50
+				}
+				for !g.Ended() {
+					mv := bots[g.NextPlayer].Move(g)
+					var err error
+					if len(mv.Table) > 0 {
+						err = g.Take(mv.Card, mv.Table)
+					} else {
+						err = g.Drop(mv.Card)
+					}
+					if err != nil {
+						t.Fatalf("%s against %s in %q: %+v isn't legal: %v", a, b, variant, mv, err)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, l := range []Level{Easy, Medium, Hard} {
+		if got, err := ParseLevel(l.String()); err != nil || got != l {
+			t.Errorf("ParseLevel(%q) = %v, %v", l, got, err)
+		}
+	}
+	if _, err := ParseLevel("grandmaster"); err == nil {
+		t.Errorf("Expected an unknown level to fail.")
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	g := scopa.Game{NextPlayer: "a", Players: []scopa.Player{{Name: "a"}, {Name: "b"}}}
+	g.Players[0].Hand = cards(t, "5C", "2D", "RB")
+	g.Players[1].Hand = make([]scopa.Card, 3)
+	g.Deck = make([]scopa.Card, 24)
+	g.Table = cards(t, "2B", "3S")
+
+	as := Analyze(g, 500, 1)
+	if len(as) != len(g.LegalMoves()) {
+		t.Fatalf("Expected every one of the %d moves to be tried, got %+v", len(g.LegalMoves()), as)
+	}
+	visits := 0
+	for i, a := range as {
+		visits += a.Visits
+		if i > 0 && a.Visits > as[i-1].Visits {
+			t.Errorf("Expected the moves tried the most first, got %+v", as)
+		}
+	}
+	if visits != 500 {
+		t.Errorf("Expected 500 games played out, got %d", visits)
+	}
+	if d := cmp.Diff(scopa.Move{Card: cards(t, "5C")[0], Table: cards(t, "2B", "3S")}, as[0].Move); d != "" {
+		t.Errorf("Expected the scopa to be the best move, mismatch (-want +got):\n%s", d)
+	}
+	if as[0].Points <= as[len(as)-1].Points {
+		t.Errorf("Expected the scopa to win more points than %+v, got %v", as[len(as)-1], as[0].Points)
+	}
+}
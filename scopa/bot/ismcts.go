@@ -0,0 +1,202 @@
+package bot
+
+import (
+	"github.com/sbadame/scopa/scopa"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// searchIterations is how many games the Hard bot plays out before each move, which takes about a tenth of a second.
+const searchIterations = 1000
+
+// exploration is the UCB constant that balances trying moves again that did well against moves that haven't been
+// tried much, for results scaled to between 0 and 1.
+const exploration = 0.7
+
+// spread is how many points either way a result is scaled by, to bring it between 0 and 1.
+const spread = 10.0
+
+// Analysis is what the search made of one of the moves in a position.
+type Analysis struct {
+	scopa.Move
+	Visits int     // How many of the games played out started with the move.
+	Points float64 // How many points the move won by in those games on average, negative when it lost.
+}
+
+// Analyze searches g with iterations games played out from the point of view of the player whose turn it is, and
+// returns every move that it tried with the best first. It never looks at the opponent's hand or at the deck.
+func Analyze(g scopa.Game, iterations int, seed int64) []Analysis {
+	root := search(g, iterations, rand.New(rand.NewSource(seed)))
+	var as []Analysis
+	for _, n := range root.children {
+		as = append(as, Analysis{n.move, n.visits, n.points / float64(n.visits)})
+	}
+	sort.SliceStable(as, func(i, j int) bool { return as[i].Visits > as[j].Visits })
+	return as
+}
+
+// searchMove is the move that search tried the most.
+func searchMove(g scopa.Game, iterations int, r *rand.Rand) scopa.Move {
+	var best *node
+	for _, n := range search(g, iterations, r).children {
+		if best == nil || n.visits > best.visits {
+			best = n
+		}
+	}
+	if best == nil {
+		return scopa.Move{}
+	}
+	return best.move
+}
+
+// node is a move in the search tree. The tree is shared by every determinization, so a node's children are the
+// moves that were legal in any of them.
+type node struct {
+	move     scopa.Move
+	key      string
+	player   string // Who made the move.
+	parent   *node
+	children []*node
+
+	visits int     // Games played out through the move.
+	avail  int     // Times the move was legal when its parent was visited.
+	points float64 // Total points that player won by in those games.
+}
+
+func (n *node) child(key string) *node {
+	for _, c := range n.children {
+		if c.key == key {
+			return c
+		}
+	}
+	return nil
+}
+
+// ucb is the upper confidence bound of the node's results, that selection picks the highest of.
+func (n *node) ucb() float64 {
+	mean := 0.5 + n.points/float64(n.visits)/(2*spread)
+	return mean + exploration*math.Sqrt(math.Log(float64(n.avail))/float64(n.visits))
+}
+
+// moveKey is the same for the same move, whatever order its cards are in.
+func moveKey(mv scopa.Move) string {
+	var cs []string
+	for _, t := range mv.Table {
+		cs = append(cs, t.Short())
+	}
+	sort.Strings(cs)
+	return mv.Card.Short() + ":" + strings.Join(cs, ",")
+}
+
+// search runs information set Monte Carlo tree search, and returns the root of the tree. Each iteration deals the
+// cards that the player whose turn it is can't see at random, plays down the tree picking moves by ucb until there
+// is one that hasn't been tried, adds it to the tree, and plays the rest of the game out with countingMove.
+func search(g scopa.Game, iterations int, r *rand.Rand) *node {
+	root := &node{}
+	if g.Ended() {
+		return root
+	}
+	for i := 0; i < iterations; i++ {
+		d := determinize(g, r)
+		n := root
+		for !d.Ended() {
+			var tried []*node
+			var untried []scopa.Move
+			for _, mv := range d.LegalMoves() {
+				if c := n.child(moveKey(mv)); c != nil {
+					c.avail++
+					tried = append(tried, c)
+				} else {
+					untried = append(untried, mv)
+				}
+			}
+
+			if len(tried) == 0 && len(untried) == 0 {
+				break // Hands that were dealt out of turn, like in a puzzle.
+			}
+			if len(untried) > 0 {
+				mv := untried[r.Intn(len(untried))]
+				c := &node{move: mv, key: moveKey(mv), player: d.NextPlayer, parent: n, avail: 1}
+				n.children = append(n.children, c)
+				d.Play(mv)
+				n = c
+				break
+			}
+			best := tried[0]
+			for _, c := range tried[1:] {
+				if c.ucb() > best.ucb() {
+					best = c
+				}
+			}
+			d.Play(best.move)
+			n = best
+		}
+
+		for !d.Ended() {
+			if err := d.Play(countingMove(d, d.LegalMoves())); err != nil {
+				break
+			}
+		}
+		for ; n != root; n = n.parent {
+			n.visits++
+			n.points += margin(d, n.player)
+		}
+		root.visits++
+	}
+	return root
+}
+
+// deck is every card, in no particular order.
+var deck = scopa.NewSeededDeck(0)
+
+// determinize returns a copy of g where the cards that the player whose turn it is can't see, the opponent's hand and
+// the deck, are dealt at random from the cards that they haven't seen.
+func determinize(g scopa.Game, r *rand.Rand) scopa.Game {
+	d := g.Clone()
+	seen := make(map[scopa.Card]bool)
+	for _, c := range d.Table {
+		seen[c] = true
+	}
+	for _, p := range d.Players {
+		for _, c := range p.Grabbed {
+			seen[c] = true
+		}
+		if p.Name == d.NextPlayer {
+			for _, c := range p.Hand {
+				seen[c] = true
+			}
+		}
+	}
+	var unseen []scopa.Card
+	for _, c := range deck {
+		if !seen[c] {
+			unseen = append(unseen, c)
+		}
+	}
+	r.Shuffle(len(unseen), func(i, j int) { unseen[i], unseen[j] = unseen[j], unseen[i] })
+
+	for i, p := range d.Players {
+		if p.Name != d.NextPlayer {
+			n := copy(d.Players[i].Hand, unseen)
+			unseen = unseen[n:]
+		}
+	}
+	copy(d.Deck, unseen)
+	return d
+}
+
+// margin is how many points player won the ended game g by, negative when they lost.
+func margin(g scopa.Game, player string) float64 {
+	var m float64
+	for _, p := range g.Players {
+		points := float64(len(p.Awards) + p.Scopas)
+		if p.Name == player {
+			m += points
+		} else {
+			m -= points
+		}
+	}
+	return m
+}
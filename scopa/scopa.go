@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"math/rand"
 	"strconv"
+	"strings"
 )
 
 // Suit of a card.
@@ -46,6 +47,45 @@ func (c Card) String() string {
 	return fmt.Sprintf("%s di %s", v, c.Suit)
 }
 
+// The letters of the short card notation, e.g. "7D" or "RB", value first and then suit.
+var (
+	shortValues = map[string]int{"A": 1, "F": 8, "C": 9, "R": 10}
+	shortSuits  = map[string]Suit{"D": Denari, "C": Coppe, "B": Bastoni, "S": Spade}
+)
+
+// Short is the card in short notation: the value (A, 2-7, F for Fante, C for Cavallo or R for Re) followed by
+// the first letter of the suit. For example "7D" is the Settebello and "CC" is the Cavallo di Coppe.
+func (c Card) Short() string {
+	v := strconv.Itoa(c.Value)
+	for l, n := range shortValues {
+		if n == c.Value {
+			v = l
+		}
+	}
+	return v + string(c.Suit)[:1]
+}
+
+// ParseCard parses a card in the notation of Short, ignoring case. Values can also be given as numbers, e.g. "10B".
+func ParseCard(s string) (Card, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if len(s) < 2 {
+		return Card{}, fmt.Errorf("%q isn't a card, try something like 7D or RB", s)
+	}
+
+	suit, ok := shortSuits[s[len(s)-1:]]
+	if !ok {
+		return Card{}, fmt.Errorf("%q doesn't end in a suit: D, C, B or S", s)
+	}
+	v, ok := shortValues[s[:len(s)-1]]
+	if !ok {
+		var err error
+		if v, err = strconv.Atoi(s[:len(s)-1]); err != nil || v < 1 || v > 10 {
+			return Card{}, fmt.Errorf("%q doesn't start with a value: A, 2-7, F, C or R", s)
+		}
+	}
+	return Card{Suit: suit, Value: v}, nil
+}
+
 // MarshalJSON customizes the Card JSON representation to include a "Name" field.
 func (c Card) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
@@ -93,14 +133,31 @@ type move struct {
 	Take *take
 }
 
+// The variants of the game, the zero value of Game.Variant is Scopa.
+const (
+	Scopa           = "scopa"
+	AssoPigliaTutto = "asso-piglia-tutto" // An ace takes everything on the table, which isn't a scopa.
+)
+
+// What a forfeit scores, the zero value of Game.ForfeitPoints is ForfeitAwards.
+const (
+	ForfeitAwards  = "awards"  // The other player gets every award.
+	ForfeitCounted = "counted" // Awards go to whoever has earned them with the cards taken so far.
+	ForfeitScopas  = "scopas"  // Nobody gets any awards, only the scopas made so far count.
+)
+
 // Game is a struct that exposes all of the State related the current Scopa game.
 type Game struct {
+	Variant          string `json:",omitempty"` // "" is Scopa.
 	NextPlayer       string
 	LastPlayerToTake string
 	Deck             []Card
 	Table            []Card
 	Players          []Player
 	LastMove         move
+	Forfeited        string // Name of the player that forfeited, the game ends early when set.
+	ForfeitPoints    string `json:",omitempty"` // What a forfeit scores, "" is ForfeitAwards.
+	Stopped          string `json:",omitempty"` // Why the game was stopped early without a winner, it ends when set.
 }
 
 // JSONForPlayer customizes the JSON output to include a mapping of player name to Player.
@@ -110,6 +167,7 @@ func (g *Game) JSONForPlayer(name string) ([]byte, error) {
 		return nil, err
 	}
 	j := struct {
+		Variant              string `json:",omitempty"`
 		NextPlayer           string
 		LastPlayerToTake     string
 		Table                []Card
@@ -117,8 +175,10 @@ func (g *Game) JSONForPlayer(name string) ([]byte, error) {
 		Player               Player
 		LastMove             move
 		Ended                bool
+		Forfeited            string
 		RemainingCardsInDeck int
 	}{
+		g.Variant,
 		g.NextPlayer,
 		g.LastPlayerToTake,
 		g.Table,
@@ -126,9 +186,14 @@ func (g *Game) JSONForPlayer(name string) ([]byte, error) {
 		*p,
 		g.LastMove,
 		g.Ended(),
+		g.Forfeited,
 		len(g.Deck),
 	}
 	for _, p := range g.Players {
+		if p.Name != name {
+			// Don't let players peek at eachother's hands.
+			p.Hand = nil
+		}
 		j.Players = append(j.Players, p)
 	}
 	return json.Marshal(j)
@@ -188,6 +253,15 @@ func perroError(card Card) error {
 
 // NewDeck creates a newly shuffled deck.
 func NewDeck() []Card {
+	return newDeck(rand.Shuffle)
+}
+
+// NewSeededDeck is NewDeck shuffled by seed, the same seed always shuffles the deck the same way.
+func NewSeededDeck(seed int64) []Card {
+	return newDeck(rand.New(rand.NewSource(seed)).Shuffle)
+}
+
+func newDeck(shuffle func(n int, swap func(i, j int))) []Card {
 
 	// Construct a full deck of cards.
 	d := make([]Card, 0)
@@ -197,7 +271,7 @@ func NewDeck() []Card {
 		}
 	}
 
-	rand.Shuffle(len(d), func(i, j int) {
+	shuffle(len(d), func(i, j int) {
 		d[i], d[j] = d[j], d[i]
 	})
 	return d
@@ -206,6 +280,15 @@ func NewDeck() []Card {
 // NewGame creates a game with the given names as player names.
 // They will play in the order provided.
 func NewGame(names []string) Game {
+	return newGame(names, rand.Shuffle)
+}
+
+// NewSeededGame is NewGame with the deck shuffled by seed, the same seed always deals the same game.
+func NewSeededGame(names []string, seed int64) Game {
+	return newGame(names, rand.New(rand.NewSource(seed)).Shuffle)
+}
+
+func newGame(names []string, shuffle func(n int, swap func(i, j int))) Game {
 	// Create the game state with no cards
 	g := Game{NextPlayer: names[0]}
 	for _, n := range names {
@@ -214,7 +297,7 @@ func NewGame(names []string) Game {
 
 	// Keep shuffling and dealing until we don't see more than 2 Re's on the table
 	for {
-		cards := NewDeck()
+		cards := newDeck(shuffle)
 
 		deal := func(to *[]Card) {
 			moveCard(cards[0], &cards, to)
@@ -336,6 +419,11 @@ func max(a, b int) int {
 	return b
 }
 
+// Primiera is the player's score for the primiera award, from the best card they grabbed of each suit.
+func (p Player) Primiera() int {
+	return playerPrimera(p)
+}
+
 func playerPrimera(p Player) int {
 
 	points := map[int]int{
@@ -389,8 +477,8 @@ func (g *Game) endTurn() error {
 	if g.Ended() {
 		// Give the player that last took cards, the remaining cards on the table.
 
-		if p, err := g.player(g.LastPlayerToTake); err != nil {
-			(*p).Grabbed = append((*p).Grabbed, g.Table...)
+		// It's empty when nobody has taken anything yet.
+		if _, err := g.player(g.LastPlayerToTake); err != nil && g.LastPlayerToTake != "" {
 			panic(fmt.Errorf(`s.LastPlayerToTake is not in the list of players: %v`, err))
 		}
 
@@ -415,15 +503,73 @@ func (g *Game) endTurn() error {
 	return nil
 }
 
+func gameOverError() error {
+	return moveErrorf("The game is over")
+}
+
+// Forfeit ends the game early with name as the loser.
+// Scopas already made are kept, and the awards are given out as g.ForfeitPoints says.
+func (g *Game) Forfeit(name string) error {
+	if g.Ended() {
+		return gameOverError()
+	}
+	if _, err := g.player(name); err != nil {
+		return err
+	}
+
+	g.Forfeited = name
+	switch g.ForfeitPoints {
+	case ForfeitScopas:
+	case ForfeitCounted:
+		mostCards(&g.Players[0], &g.Players[1])
+		mostDenari(&g.Players[0], &g.Players[1])
+		for i := range g.Players {
+			// Nobody gets the sette bello while it's still to be played.
+			if contains(Card{Denari, 7}, g.Players[i].Grabbed) {
+				g.Players[i].Awards = append(g.Players[i].Awards, "SetteBello")
+			}
+		}
+		primera(&g.Players[0], &g.Players[1])
+	default:
+		for i := range g.Players {
+			if g.Players[i].Name != name {
+				g.Players[i].Awards = []string{"Cards", "Denari", "SetteBello", "Primera"}
+			}
+		}
+	}
+	return nil
+}
+
+// Stop ends the game early without a winner, e.g. when the players agree to. Nobody gets any awards, and why says
+// what happened.
+func (g *Game) Stop(why string) error {
+	if g.Ended() {
+		return gameOverError()
+	}
+	if why == "" {
+		return fmt.Errorf("a game can't be stopped without a reason")
+	}
+	g.Stopped = why
+	return nil
+}
+
 // Take performs a trick where the current place takes cards from the table whos values add up to a card in their hand.
 func (g *Game) Take(card Card, table []Card) error {
 	// Validating inputs...
+	if g.Ended() {
+		return gameOverError()
+	}
+
+	// In asso piglia tutto, an ace can take the whole table no matter what's on it.
+	sweep := g.Variant == AssoPigliaTutto && card.Value == 1 && len(table) == len(g.Table) &&
+		!(len(table) == 1 && table[0].Value == 1)
+
 	// Check that the math works out...
 	sum := 0
 	for _, t := range table {
 		sum += t.Value
 	}
-	if sum != card.Value {
+	if sum != card.Value && !sweep {
 		return badMathTake(card, table)
 	}
 
@@ -439,15 +585,8 @@ func (g *Game) Take(card Card, table []Card) error {
 	}
 
 	// Take the Face
-	v := card.Value
-	if (v > 7) && (len(table) > 1) {
-		// Check if there is a face match
-		for _, t := range g.Table {
-			// If there card in your hand direct equals a card in the pot and you're trying to take > 1.... no no no
-			if (v == t.Value) && (!contains(t, table)) {
-				return perroError(t)
-			}
-		}
+	if t, ok := faceMatch(card, g.Table); ok && len(table) > 1 {
+		return perroError(t)
 	}
 
 	// Looking good! Lets do the move!
@@ -464,7 +603,7 @@ func (g *Game) Take(card Card, table []Card) error {
 	}
 
 	// Check if that was a scopa...
-	if len(g.Table) == 0 {
+	if len(g.Table) == 0 && !sweep {
 		p.Scopas++
 	}
 
@@ -476,6 +615,9 @@ func (g *Game) Take(card Card, table []Card) error {
 // Drop performs a trick where the current player drops a card from their hand onto the table.
 func (g *Game) Drop(card Card) error {
 	// Validating inputs...
+	if g.Ended() {
+		return gameOverError()
+	}
 	if err := g.currentPlayer().holds(card); err != nil {
 		return err
 	}
@@ -491,5 +633,94 @@ func (g *Game) Drop(card Card) error {
 
 // Ended is true if the game has ended and there are no more moves.
 func (g Game) Ended() bool {
-	return len(g.Deck) == 0 && g.emptyHands()
+	return g.Forfeited != "" || g.Stopped != "" || (len(g.Deck) == 0 && g.emptyHands())
+}
+
+// Move is a move that the player whose turn it is can make: taking Table with Card, or dropping Card when Table is
+// empty.
+type Move struct {
+	Card  Card
+	Table []Card
+}
+
+// LegalMoves returns every move that the player whose turn it is can make, the takes first and then the drops, or
+// nothing once the game has ended.
+func (g Game) LegalMoves() []Move {
+	if g.Ended() {
+		return nil
+	}
+	hand := g.currentPlayer().Hand
+	var moves []Move
+	for _, c := range hand {
+		_, face := faceMatch(c, g.Table)
+		for _, table := range Sums(g.Table, c.Value) {
+			if !face || len(table) == 1 {
+				moves = append(moves, Move{c, table})
+			}
+		}
+		// The sweep, the only way to take the whole table when it doesn't add up.
+		if g.Variant == AssoPigliaTutto && c.Value == 1 && len(g.Table) > 0 &&
+			!(len(g.Table) == 1 && g.Table[0].Value == 1) {
+			moves = append(moves, Move{c, append([]Card(nil), g.Table...)})
+		}
+	}
+	for _, c := range hand {
+		moves = append(moves, Move{Card: c})
+	}
+	return moves
+}
+
+// faceMatch returns the card on table with the same value as card, when card is a face card. A face card with its
+// match on the table can only take that match.
+func faceMatch(card Card, table []Card) (Card, bool) {
+	if card.Value > 7 {
+		for _, t := range table {
+			if t.Value == card.Value {
+				return t, true
+			}
+		}
+	}
+	return Card{}, false
+}
+
+// Sums returns every set of cards in table that adds up to value.
+func Sums(table []Card, value int) [][]Card {
+	var sets [][]Card
+	var add func(from int, sum int, set []Card)
+	add = func(from int, sum int, set []Card) {
+		if sum == value {
+			sets = append(sets, append([]Card(nil), set...))
+			return
+		}
+		for i := from; i < len(table); i++ {
+			if sum+table[i].Value <= value {
+				add(i+1, sum+table[i].Value, append(set, table[i]))
+			}
+		}
+	}
+	add(0, 0, nil)
+	return sets
+}
+
+// Play makes mv for the player whose turn it is, with Take or Drop.
+func (g *Game) Play(mv Move) error {
+	if len(mv.Table) > 0 {
+		return g.Take(mv.Card, mv.Table)
+	}
+	return g.Drop(mv.Card)
+}
+
+// Clone returns a copy of g that moves can be tried out on without changing g.
+func (g Game) Clone() Game {
+	c := g
+	c.Deck = append([]Card(nil), g.Deck...)
+	c.Table = append([]Card(nil), g.Table...)
+	c.Players = make([]Player, len(g.Players))
+	for i, p := range g.Players {
+		p.Hand = append([]Card(nil), p.Hand...)
+		p.Grabbed = append([]Card(nil), p.Grabbed...)
+		p.Awards = append([]string(nil), p.Awards...)
+		c.Players[i] = p
+	}
+	return c
 }
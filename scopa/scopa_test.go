@@ -150,3 +150,173 @@ func TestTake(t *testing.T) {
 		}
 	}
 }
+
+func TestForfeit(t *testing.T) {
+	g := NewGame([]string{"1", "2"})
+	if err := g.Forfeit("3"); err == nil {
+		t.Errorf("Expected a forfeit by someone not playing to fail.")
+	}
+	if err := g.Forfeit("1"); err != nil {
+		t.Fatalf("Couldn't forfeit: %v", err)
+	}
+	if !g.Ended() {
+		t.Errorf("Expected the game to end after a forfeit.")
+	}
+	if d := cmp.Diff([]string{"Cards", "Denari", "SetteBello", "Primera"}, g.Players[1].Awards); d != "" {
+		t.Errorf("mismatch awards (-want +got):\n%s", d)
+	}
+	if err := g.Drop(g.Players[0].Hand[0]); err == nil {
+		t.Errorf("Expected moves after a forfeit to fail.")
+	}
+}
+
+func TestForfeitPoints(t *testing.T) {
+	settebello, re := Card{Denari, 7}, Card{Spade, 10}
+	for policy, want := range map[string][][]string{
+		ForfeitCounted: {{"Cards", "Denari", "SetteBello", "Primera"}, nil},
+		ForfeitScopas:  {nil, nil},
+	} {
+		g := Game{
+			ForfeitPoints: policy,
+			Players: []Player{
+				{Name: "1", Hand: []Card{{Coppe, 1}}, Grabbed: []Card{settebello, {Denari, 2}}, Scopas: 1},
+				{Name: "2", Hand: []Card{{Coppe, 2}}, Grabbed: []Card{re}},
+			},
+		}
+		if err := g.Forfeit("1"); err != nil {
+			t.Fatalf("Couldn't forfeit: %v", err)
+		}
+		got := [][]string{g.Players[0].Awards, g.Players[1].Awards}
+		if d := cmp.Diff(want, got); d != "" {
+			t.Errorf("%s: mismatch awards (-want +got):\n%s", policy, d)
+		}
+	}
+}
+
+func TestStop(t *testing.T) {
+	g := NewGame([]string{"1", "2"})
+	if err := g.Stop(""); err == nil {
+		t.Errorf("Expected stopping without a reason to fail.")
+	}
+	if err := g.Stop("aborted"); err != nil {
+		t.Fatalf("Couldn't stop: %v", err)
+	}
+	if !g.Ended() || g.Players[0].Awards != nil || g.Players[1].Awards != nil {
+		t.Errorf("Expected the game to end without awards, got %+v", g.Players)
+	}
+	if err := g.Forfeit("1"); err == nil {
+		t.Errorf("Expected a forfeit after the game was stopped to fail.")
+	}
+}
+
+func TestAssoPigliaTutto(t *testing.T) {
+	newGame := func(variant string) Game {
+		return Game{
+			Variant:    variant,
+			NextPlayer: "1",
+			Deck:       []Card{{Coppe, 2}},
+			Table:      []Card{{Coppe, 4}, {Spade, 9}},
+			Players:    []Player{{Name: "1", Hand: []Card{{Bastoni, 1}, {Spade, 5}}}, {Name: "2", Hand: []Card{{Denari, 3}}}},
+		}
+	}
+
+	g := newGame("")
+	if err := g.Take(Card{Bastoni, 1}, g.Table); err == nil {
+		t.Errorf("Expected an ace to only take what it adds up to in scopa.")
+	}
+
+	g = newGame(AssoPigliaTutto)
+	if err := g.Take(Card{Bastoni, 1}, []Card{{Coppe, 4}}); err == nil {
+		t.Errorf("Expected an ace to take the whole table or nothing.")
+	}
+	if err := g.Take(Card{Bastoni, 1}, g.Table); err != nil {
+		t.Fatalf("Expected an ace to take the whole table: %v", err)
+	}
+	if p := g.Players[0]; len(p.Grabbed) != 3 || p.Scopas != 0 {
+		t.Errorf("Expected 3 cards taken without a scopa, got %+v", p)
+	}
+}
+
+func TestLegalMoves(t *testing.T) {
+	g := Game{
+		NextPlayer: "1",
+		Deck:       []Card{{Coppe, 2}},
+		Table:      []Card{{Coppe, 4}, {Spade, 9}, {Bastoni, 5}, {Denari, 9}},
+		Players:    []Player{{Name: "1", Hand: []Card{{Bastoni, 9}, {Spade, 5}}}, {Name: "2", Hand: []Card{{Denari, 3}}}},
+	}
+	want := []Move{
+		// The cavallo can't take the 4 and 5 with another cavallo on the table.
+		{Card{Bastoni, 9}, []Card{{Spade, 9}}},
+		{Card{Bastoni, 9}, []Card{{Denari, 9}}},
+		{Card{Spade, 5}, []Card{{Bastoni, 5}}},
+		{Card: Card{Bastoni, 9}},
+		{Card: Card{Spade, 5}},
+	}
+	if d := cmp.Diff(want, g.LegalMoves()); d != "" {
+		t.Errorf("LegalMoves() mismatch (-want +got):\n%s", d)
+	}
+
+	before := g.Clone()
+	for _, mv := range g.LegalMoves() {
+		c := g.Clone()
+		if err := c.Play(mv); err != nil {
+			t.Errorf("Couldn't play %+v: %v", mv, err)
+		}
+	}
+	if d := cmp.Diff(before, g, cmp.AllowUnexported(move{})); d != "" {
+		t.Errorf("Expected playing on clones to leave the game alone (-want +got):\n%s", d)
+	}
+
+	g.Variant = AssoPigliaTutto
+	g.Players[0].Hand = []Card{{Denari, 1}}
+	want = []Move{{Card{Denari, 1}, g.Table}, {Card: Card{Denari, 1}}}
+	if d := cmp.Diff(want, g.LegalMoves()); d != "" {
+		t.Errorf("%s LegalMoves() mismatch (-want +got):\n%s", AssoPigliaTutto, d)
+	}
+}
+
+func TestParseCard(t *testing.T) {
+	for s, want := range map[string]Card{
+		"7D":  {Denari, 7},
+		"rb":  {Bastoni, 10},
+		"CC":  {Coppe, 9},
+		"FS":  {Spade, 8},
+		"AS":  {Spade, 1},
+		"1s":  {Spade, 1},
+		"10B": {Bastoni, 10},
+	} {
+		got, err := ParseCard(s)
+		if err != nil {
+			t.Errorf("ParseCard(%q) failed: %v", s, err)
+			continue
+		}
+		if d := cmp.Diff(want, got); d != "" {
+			t.Errorf("ParseCard(%q) mismatch (-want +got):\n%s", s, d)
+		}
+	}
+
+	for _, s := range []string{"", "7", "7X", "11D", "0C", "XD"} {
+		if c, err := ParseCard(s); err == nil {
+			t.Errorf("ParseCard(%q) = %v, expected an error", s, c)
+		}
+	}
+
+	for _, c := range NewDeck() {
+		if got, err := ParseCard(c.Short()); err != nil || got != c {
+			t.Errorf("ParseCard(%q) = %v, %v, expected %v", c.Short(), got, err, c)
+		}
+	}
+}
+
+func TestNewSeededGame(t *testing.T) {
+	a, b := NewSeededGame([]string{"1", "2"}, 42), NewSeededGame([]string{"1", "2"}, 42)
+	if d := cmp.Diff(a, b); d != "" {
+		t.Errorf("Expected the same seed to deal the same game, mismatch (-first +second):\n%s", d)
+	}
+	if c := NewSeededGame([]string{"1", "2"}, 43); cmp.Equal(a, c) {
+		t.Errorf("Expected another seed to deal another game, got %v", c)
+	}
+	if d := cmp.Diff(NewSeededDeck(42), NewSeededDeck(42)); d != "" {
+		t.Errorf("Expected the same seed to shuffle the same deck, mismatch (-first +second):\n%s", d)
+	}
+}
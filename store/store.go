@@ -0,0 +1,332 @@
+// Package store keeps JSON documents on disk so that a crash never leaves a half written file behind.
+//
+// A document is saved by writing a temporary file next to it and renaming that over the old one. Small changes
+// can be appended to a journal instead of saving the whole document every time, and are replayed on top of the
+// last saved document when it's loaded. Saved documents carry a schema version, and are migrated to the current
+// version as they're loaded.
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Store keeps a single document and the journal of changes made to it since it was last saved.
+type Store interface {
+	// Load reads the document into v, and calls replay with every entry appended to the journal since. replay can
+	// be nil if nothing is ever appended. If nothing was ever saved or appended an error that satisfies
+	// os.IsNotExist is returned.
+	Load(v interface{}, replay func(entry json.RawMessage) error) error
+
+	// Save replaces the document with v, and empties the journal.
+	Save(v interface{}) error
+
+	// Append adds an entry to the journal, it's on disk once Append returns.
+	Append(entry interface{}) error
+}
+
+// Migration upgrades a document by one schema version.
+type Migration func(old json.RawMessage) (json.RawMessage, error)
+
+// Unchanged is the migration for a version that only changed how the document is stored, not what's in it.
+// Documents from before this package existed are version 0, and are kept as is by the first migration.
+func Unchanged(old json.RawMessage) (json.RawMessage, error) {
+	return old, nil
+}
+
+// envelope is how a document is saved.
+type envelope struct {
+	Version int
+	Seq     int64 // The last journal entry that is included in Data.
+	Data    json.RawMessage
+}
+
+// record is a line of the journal.
+type record struct {
+	Seq   int64
+	Entry json.RawMessage
+}
+
+// File is a Store in a file, with its journal in the same file name with .journal added.
+type File struct {
+	path       string
+	perm       os.FileMode
+	migrations []Migration
+
+	mu  sync.Mutex // Guards seq and writes to the files.
+	seq int64      // Of the last entry appended to the journal.
+}
+
+// Open returns the store in path. The current schema version is the number of migrations, where migrations[i]
+// upgrades a document from version i to i+1. The files are created with perm.
+func Open(path string, perm os.FileMode, migrations ...Migration) *File {
+	return &File{path: path, perm: perm, migrations: migrations}
+}
+
+// Path returns the file that the document is saved to.
+func (f *File) Path() string {
+	return f.path
+}
+
+func (f *File) journal() string {
+	return f.path + ".journal"
+}
+
+// Version is the schema version that documents are saved with.
+func (f *File) Version() int {
+	return len(f.migrations)
+}
+
+// Load implements Store. An entry at the end of the journal that was cut short, by a crash while it was being
+// appended, is skipped.
+func (f *File) Load(v interface{}, replay func(entry json.RawMessage) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.seq = 0
+	b, err := ioutil.ReadFile(f.path)
+	missing := os.IsNotExist(err)
+	if err != nil && !missing {
+		return err
+	}
+	if !missing {
+		env, err := f.migrate(b)
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.path, err)
+		}
+		if err := json.Unmarshal(env.Data, v); err != nil {
+			return fmt.Errorf("%s: %v", f.path, err)
+		}
+		f.seq = env.Seq
+	}
+
+	records, err := readJournal(f.journal())
+	if os.IsNotExist(err) {
+		if missing {
+			return &os.PathError{Op: "load", Path: f.path, Err: os.ErrNotExist}
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		if r.Seq <= f.seq {
+			continue // Saved in the document already, the journal wasn't emptied before a crash.
+		}
+		if replay == nil {
+			return fmt.Errorf("%s: entry %d wasn't expected, there's no journal", f.journal(), r.Seq)
+		}
+		if err := replay(r.Entry); err != nil {
+			return fmt.Errorf("%s: entry %d: %v", f.journal(), r.Seq, err)
+		}
+		f.seq = r.Seq
+	}
+	return nil
+}
+
+// migrate unwraps a saved document, and brings it up to the current version.
+func (f *File) migrate(b []byte) (envelope, error) {
+	var env envelope
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return env, err
+	}
+	if _, ok := keys["Version"]; ok {
+		if err := json.Unmarshal(b, &env); err != nil {
+			return env, err
+		}
+	} else {
+		env.Data = b // Saved before there were versions.
+	}
+
+	if env.Version > f.Version() {
+		return env, fmt.Errorf("saved with version %d, but only versions up to %d are understood", env.Version, f.Version())
+	}
+	for ; env.Version < f.Version(); env.Version++ {
+		data, err := f.migrations[env.Version](env.Data)
+		if err != nil {
+			return env, fmt.Errorf("migrating from version %d: %v", env.Version, err)
+		}
+		env.Data = data
+	}
+	return env, nil
+}
+
+func readJournal(path string) ([]record, error) {
+	j, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer j.Close()
+
+	var records []record
+	r := bufio.NewReader(j)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			// The last line is only complete once its newline is written.
+			if len(bytes.TrimSpace(line)) > 0 {
+				fmt.Printf("Skipping the unfinished entry at the end of %s\n", path)
+			}
+			return records, nil
+		}
+		var rec record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		records = append(records, rec)
+	}
+}
+
+// Save implements Store.
+func (f *File) Save(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, err := json.Marshal(envelope{f.Version(), f.seq, data})
+	if err != nil {
+		return err
+	}
+	if err := writeFile(f.path, b, f.perm); err != nil {
+		return err
+	}
+	// A crash before the journal is emptied is fine, its entries are already in the document by their Seq.
+	if err := os.Remove(f.journal()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Append implements Store.
+func (f *File) Append(entry interface{}) error {
+	e, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, err := json.Marshal(record{f.seq + 1, e})
+	if err != nil {
+		return err
+	}
+	j, err := os.OpenFile(f.journal(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, f.perm)
+	if err != nil {
+		return err
+	}
+	if _, err := j.Write(append(b, '\n')); err != nil {
+		j.Close()
+		return err
+	}
+	if err := j.Sync(); err != nil {
+		j.Close()
+		return err
+	}
+	if err := j.Close(); err != nil {
+		return err
+	}
+	f.seq++
+	return nil
+}
+
+// writeFile replaces path with b in one step, by writing a temporary file next to it and renaming it over path.
+func writeFile(path string, b []byte, perm os.FileMode) error {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := ioutil.TempFile(dir, name+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Fails once it's been renamed.
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// Make the rename itself durable. Not every system can sync a directory, so that's best effort.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// Backup copies the stores' files into dir, which is created if needed.
+// The server can keep running, the journal is copied before the document so that a save in between loses no
+// entries: the copied journal's entries are either after the copied document's, or skipped by their Seq.
+func Backup(dir string, stores ...*File) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	for _, s := range stores {
+		for _, p := range []string{s.journal(), s.path} {
+			if err := copyFile(p, filepath.Join(dir, filepath.Base(p)), s.perm); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Restore replaces the stores' files with the ones that Backup put in dir. Every document is checked to load
+// before anything is replaced. The server must not be running.
+func Restore(dir string, stores ...*File) error {
+	for _, s := range stores {
+		backup := Open(filepath.Join(dir, filepath.Base(s.path)), s.perm, s.migrations...)
+		var v interface{}
+		err := backup.Load(&v, func(json.RawMessage) error { return nil }) // Entries are checked to be JSON.
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	for _, s := range stores {
+		for _, p := range []string{s.path, s.journal()} {
+			if err := copyFile(filepath.Join(dir, filepath.Base(p)), p, s.perm); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// copyFile replaces to with a copy of from. If from doesn't exist then neither will to.
+func copyFile(from, to string, perm os.FileMode) error {
+	b, err := ioutil.ReadFile(from)
+	if os.IsNotExist(err) {
+		if err := os.Remove(to); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return writeFile(to, b, perm)
+}
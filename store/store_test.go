@@ -0,0 +1,186 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/go-cmp/cmp"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "teststore")
+	if err != nil {
+		t.Fatalf("Couldn't create a temp dir: %v", err)
+	}
+	return dir
+}
+
+// counts is a document that counts the entries added to it.
+type counts map[string]int
+
+func (c counts) load(s Store) error {
+	return s.Load(&c, func(e json.RawMessage) error {
+		var k string
+		if err := json.Unmarshal(e, &k); err != nil {
+			return err
+		}
+		c[k]++
+		return nil
+	})
+}
+
+func TestJournal(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "counts.json")
+
+	s := Open(path, 0644, Unchanged)
+	if err := make(counts).load(s); !os.IsNotExist(err) {
+		t.Fatalf("Expected a new store not to exist, got %v", err)
+	}
+	for _, k := range []string{"a", "b", "a"} {
+		if err := s.Append(k); err != nil {
+			t.Fatalf("Couldn't append %s: %v", k, err)
+		}
+	}
+
+	got := make(counts)
+	if err := got.load(Open(path, 0644, Unchanged)); err != nil {
+		t.Fatalf("Couldn't load the journal: %v", err)
+	}
+	if diff := cmp.Diff(counts{"a": 2, "b": 1}, got); diff != "" {
+		t.Errorf("Journal mismatch (-want +got):\n%s", diff)
+	}
+
+	// Save the document, then bring back the journal as if there was a crash before it was removed.
+	journal, err := ioutil.ReadFile(path + ".journal")
+	if err != nil {
+		t.Fatalf("Couldn't read the journal: %v", err)
+	}
+	if err := s.Save(got); err != nil {
+		t.Fatalf("Couldn't save: %v", err)
+	}
+	if _, err := os.Stat(path + ".journal"); !os.IsNotExist(err) {
+		t.Errorf("Expected saving to remove the journal, got %v", err)
+	}
+	if err := s.Append("c"); err != nil {
+		t.Fatalf("Couldn't append c: %v", err)
+	}
+	b, err := ioutil.ReadFile(path + ".journal")
+	if err != nil {
+		t.Fatalf("Couldn't read the journal: %v", err)
+	}
+	// With a torn entry at the end too.
+	b = append(append(journal, b...), `{"Seq":5,"En`...)
+	if err := ioutil.WriteFile(path+".journal", b, 0644); err != nil {
+		t.Fatalf("Couldn't write the journal: %v", err)
+	}
+
+	got = make(counts)
+	if err := got.load(Open(path, 0644, Unchanged)); err != nil {
+		t.Fatalf("Couldn't load: %v", err)
+	}
+	if diff := cmp.Diff(counts{"a": 2, "b": 1, "c": 1}, got); diff != "" {
+		t.Errorf("Document mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "counts.json")
+
+	// A file from before there were versions.
+	if err := ioutil.WriteFile(path, []byte(`{"a":1}`), 0644); err != nil {
+		t.Fatalf("Couldn't write: %v", err)
+	}
+	double := func(old json.RawMessage) (json.RawMessage, error) {
+		c := make(counts)
+		if err := json.Unmarshal(old, &c); err != nil {
+			return nil, err
+		}
+		for k := range c {
+			c[k] *= 2
+		}
+		return json.Marshal(c)
+	}
+
+	got := make(counts)
+	s := Open(path, 0644, Unchanged, double)
+	if err := got.load(s); err != nil {
+		t.Fatalf("Couldn't load: %v", err)
+	}
+	if diff := cmp.Diff(counts{"a": 2}, got); diff != "" {
+		t.Errorf("Migrated document mismatch (-want +got):\n%s", diff)
+	}
+	if err := s.Save(got); err != nil {
+		t.Fatalf("Couldn't save: %v", err)
+	}
+
+	// It's saved as version 2, so it isn't doubled again.
+	got = make(counts)
+	if err := got.load(Open(path, 0644, Unchanged, double)); err != nil {
+		t.Fatalf("Couldn't load: %v", err)
+	}
+	if diff := cmp.Diff(counts{"a": 2}, got); diff != "" {
+		t.Errorf("Reloaded document mismatch (-want +got):\n%s", diff)
+	}
+
+	// But older servers can't read it.
+	if err := make(counts).load(Open(path, 0644, Unchanged)); err == nil {
+		t.Errorf("Expected a version 1 store to refuse a version 2 document")
+	}
+}
+
+func TestBackup(t *testing.T) {
+	dir, backups := tempDir(t), tempDir(t)
+	defer os.RemoveAll(dir)
+	defer os.RemoveAll(backups)
+
+	var stores []*File
+	for i := 0; i < 2; i++ {
+		s := Open(filepath.Join(dir, fmt.Sprintf("%d.json", i)), 0600, Unchanged)
+		if err := s.Save(counts{"a": i}); err != nil {
+			t.Fatalf("Couldn't save: %v", err)
+		}
+		stores = append(stores, s)
+	}
+	if err := stores[1].Append("b"); err != nil {
+		t.Fatalf("Couldn't append: %v", err)
+	}
+	if err := Backup(backups, stores...); err != nil {
+		t.Fatalf("Couldn't back up: %v", err)
+	}
+
+	// Make changes to throw away.
+	if err := stores[0].Save(counts{"z": 26}); err != nil {
+		t.Fatalf("Couldn't save: %v", err)
+	}
+	if err := stores[1].Save(counts{}); err != nil {
+		t.Fatalf("Couldn't save: %v", err)
+	}
+	if err := Restore(backups, stores...); err != nil {
+		t.Fatalf("Couldn't restore: %v", err)
+	}
+
+	for i, want := range []counts{{"a": 0}, {"a": 1, "b": 1}} {
+		got := make(counts)
+		if err := got.load(Open(stores[i].Path(), 0600, Unchanged)); err != nil {
+			t.Fatalf("Couldn't load %d: %v", i, err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("Restored document %d mismatch (-want +got):\n%s", i, diff)
+		}
+	}
+
+	// A broken backup doesn't replace anything.
+	if err := ioutil.WriteFile(filepath.Join(backups, "1.json"), []byte("{"), 0600); err != nil {
+		t.Fatalf("Couldn't write: %v", err)
+	}
+	if err := Restore(backups, stores...); err == nil {
+		t.Errorf("Expected restoring a broken backup to fail")
+	}
+}
@@ -0,0 +1,109 @@
+package textui
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sbadame/scopa/protocol"
+	"github.com/sbadame/scopa/scopa"
+	"strconv"
+	"strings"
+)
+
+// Help lists the commands that ParseCommand understands, plus "show" and "help" which clients handle themselves.
+const Help = `Commands:
+  drop RB            Play a card onto the table.
+  take 7D 3C+4S      Play a card to take cards from the table.
+  say Ciao!          Chat with the other player.
+  resign             Forfeit the game.
+  draw               Propose, or agree to, a draw.
+  abort              Propose, or agree to, throwing the game away before the first hand is played out.
+  rematch swap to 21 Offer to play again once the game is over. Optionally swap who deals, play
+                     asso-piglia-tutto or scopa, and extend the partita.
+  accept, decline    Answer a rematch offer.
+  show               Show the table again.
+  quit               Leave, the seat is kept for a while.`
+
+// ErrQuit is returned by ParseCommand when the player wants to leave.
+var ErrQuit = errors.New("quit")
+
+// ParseCommand parses a line typed by the player into the command to send.
+// Cards are written in the notation of scopa.ParseCard.
+func ParseCommand(line string) (protocol.Command, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return protocol.Command{}, fmt.Errorf("type help for the list of commands")
+	}
+
+	switch strings.ToLower(fields[0]) {
+	case "drop", "d":
+		if len(fields) != 2 {
+			return protocol.Command{}, fmt.Errorf("drop takes one card, e.g. drop RB")
+		}
+		c, err := scopa.ParseCard(fields[1])
+		if err != nil {
+			return protocol.Command{}, err
+		}
+		return protocol.Command{Type: protocol.DropCommand, Card: &c}, nil
+	case "take", "t":
+		if len(fields) < 3 {
+			return protocol.Command{}, fmt.Errorf("take needs a card and the cards to take, e.g. take 7D 3C+4S")
+		}
+		c, err := scopa.ParseCard(fields[1])
+		if err != nil {
+			return protocol.Command{}, err
+		}
+		var table []scopa.Card
+		for _, f := range fields[2:] {
+			for _, s := range strings.Split(f, "+") {
+				if s == "" {
+					continue
+				}
+				t, err := scopa.ParseCard(s)
+				if err != nil {
+					return protocol.Command{}, err
+				}
+				table = append(table, t)
+			}
+		}
+		return protocol.Command{Type: protocol.TakeCommand, Card: &c, Table: table}, nil
+	case "say", "chat":
+		text := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), fields[0]))
+		return protocol.Command{Type: protocol.ChatCommand, Text: text}, nil
+	case "resign":
+		return protocol.Command{Type: protocol.ResignCommand}, nil
+	case "draw":
+		return protocol.Command{Type: protocol.DrawCommand}, nil
+	case "abort":
+		return protocol.Command{Type: protocol.AbortCommand}, nil
+	case "rematch":
+		var o protocol.RematchOptions
+		for i := 1; i < len(fields); i++ {
+			switch f := strings.ToLower(fields[i]); f {
+			case "swap":
+				o.SwapSeats = true
+			case scopa.Scopa, scopa.AssoPigliaTutto:
+				o.Variant = f
+			case "to":
+				i++
+				if i == len(fields) {
+					return protocol.Command{}, fmt.Errorf("to needs the points to play the partita to, e.g. rematch to 21")
+				}
+				n, err := strconv.Atoi(fields[i])
+				if err != nil || n <= 0 {
+					return protocol.Command{}, fmt.Errorf("can't play the partita to %q points", fields[i])
+				}
+				o.Partita = n
+			default:
+				return protocol.Command{}, fmt.Errorf("unknown rematch option %q, e.g. rematch swap %s to 21", fields[i], scopa.AssoPigliaTutto)
+			}
+		}
+		return protocol.Command{Type: protocol.OfferRematchCommand, Rematch: &o}, nil
+	case "accept":
+		return protocol.Command{Type: protocol.AcceptRematchCommand}, nil
+	case "decline":
+		return protocol.Command{Type: protocol.DeclineRematchCommand}, nil
+	case "quit", "exit":
+		return protocol.Command{}, ErrQuit
+	}
+	return protocol.Command{}, fmt.Errorf("unknown command %q, type help for the list", fields[0])
+}
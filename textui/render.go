@@ -0,0 +1,172 @@
+// Package textui shows scopa games as text and parses the commands that players type, for terminals and other
+// line based clients.
+package textui
+
+import (
+	"fmt"
+	"github.com/sbadame/scopa/protocol"
+	"github.com/sbadame/scopa/scopa"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Cards lists cards in short notation, with their names so that nobody has to learn the notation first.
+func Cards(cs []scopa.Card) string {
+	if len(cs) == 0 {
+		return "(none)"
+	}
+	var s []string
+	for _, c := range cs {
+		s = append(s, fmt.Sprintf("%s (%s)", c.Short(), c))
+	}
+	return strings.Join(s, "  ")
+}
+
+// LastMove describes the last move in s, or returns "" if there hasn't been one yet.
+func LastMove(s *protocol.State) string {
+	if d := s.LastMove.Drop; d != nil {
+		return fmt.Sprintf("%s dropped %s", d.Player, Cards([]scopa.Card{d.Card}))
+	}
+	if t := s.LastMove.Take; t != nil {
+		return fmt.Sprintf("%s played %s to take %s", t.Player, Cards([]scopa.Card{t.Card}), Cards(t.Table))
+	}
+	return ""
+}
+
+// clockTime formats milliseconds as minutes and seconds, or hours for correspondence games.
+func clockTime(ms int64) string {
+	d := time.Duration(ms) * time.Millisecond
+	if d < 0 {
+		d = 0
+	}
+	if d >= time.Hour {
+		return d.Round(time.Minute).String()
+	}
+	d = d.Round(time.Second)
+	return fmt.Sprintf("%d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
+
+// Render writes the game as the player me sees it.
+func Render(w io.Writer, me string, s *protocol.State, c *protocol.Clock) {
+	fmt.Fprintln(w, strings.Repeat("-", 60))
+	if m := LastMove(s); m != "" {
+		fmt.Fprintf(w, "Last move: %s\n", m)
+	}
+	fmt.Fprintf(w, "Table:     %s\n", Cards(s.Table))
+	fmt.Fprintf(w, "Deck:      %d cards left\n", s.RemainingCardsInDeck)
+	for _, p := range s.Players {
+		name := p.Name
+		if name == me {
+			name += " (you)"
+		}
+		fmt.Fprintf(w, "%-20s %2d cards taken, %d scopa", name+":", len(p.Grabbed), p.Scopas)
+		if s.Ended && len(p.Awards) > 0 {
+			fmt.Fprintf(w, ", awards: %s", strings.Join(p.Awards, ", "))
+		}
+		fmt.Fprintln(w)
+	}
+
+	if c != nil {
+		var names []string
+		for n := range c.Remaining {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		var times []string
+		for _, n := range names {
+			times = append(times, fmt.Sprintf("%s %s", n, clockTime(c.Remaining[n])))
+		}
+		fmt.Fprintf(w, "Clock:     %s\n", strings.Join(times, ", "))
+	}
+
+	if s.Ended {
+		switch s.Ending {
+		case protocol.Resigned:
+			fmt.Fprintf(w, "%s resigned.\n", s.Forfeited)
+		case protocol.TimedOut:
+			fmt.Fprintf(w, "%s ran out of time.\n", s.Forfeited)
+		case protocol.Abandoned:
+			fmt.Fprintf(w, "%s left, and didn't come back in time.\n", s.Forfeited)
+		case protocol.Aborted:
+			fmt.Fprintln(w, "The game was aborted, it doesn't count.")
+		case protocol.Drawn:
+			fmt.Fprintln(w, "The game was agreed drawn.")
+		default:
+			if s.Forfeited != "" {
+				fmt.Fprintf(w, "%s forfeited.\n", s.Forfeited)
+			}
+		}
+		fmt.Fprintln(w, "Game over!")
+		return
+	}
+	fmt.Fprintf(w, "Your hand: %s\n", Cards(s.Player.Hand))
+	if s.NextPlayer == me {
+		fmt.Fprintln(w, "Your turn!")
+	} else {
+		fmt.Fprintf(w, "Waiting for %s...\n", s.NextPlayer)
+	}
+}
+
+// Rematch describes where the rematch offer r stands, for the player me.
+func Rematch(me string, r *protocol.Rematch) string {
+	var opts []string
+	if r.Options.SwapSeats {
+		opts = append(opts, "swapping who plays first")
+	}
+	if r.Options.Variant != "" {
+		opts = append(opts, "playing "+r.Options.Variant)
+	}
+	if r.Options.Partita != 0 {
+		opts = append(opts, fmt.Sprintf("to %d points", r.Options.Partita))
+	}
+	if r.Options.TimeControl != nil {
+		opts = append(opts, "with a "+*r.Options.TimeControl+" clock")
+	}
+	offer := "a rematch"
+	if len(opts) > 0 {
+		offer += ", " + strings.Join(opts, ", ")
+	}
+
+	switch r.Status {
+	case protocol.RematchOffered:
+		if r.From == me {
+			return fmt.Sprintf("You offered %s.", offer)
+		}
+		return fmt.Sprintf("%s offers %s. Type accept or decline.", r.From, offer)
+	case protocol.RematchAccepted:
+		return "The rematch is on!"
+	case protocol.RematchDeclined:
+		if r.From == me {
+			return "Your rematch was declined, the match is over."
+		}
+		return "The match is over."
+	}
+	return "Nobody played again in time, the match is over."
+}
+
+// Proposal describes a proposal to end the game early, for the player me.
+func Proposal(me string, p *protocol.Proposal) string {
+	what := "to abort the game"
+	if p.Type == protocol.DrawCommand {
+		what = "a draw"
+	}
+	if p.From == me {
+		return fmt.Sprintf("You proposed %s.", what)
+	}
+	return fmt.Sprintf("%s proposes %s, type %s to agree.", p.From, what, p.Type)
+}
+
+// Presence describes whether nick is there, see protocol.ServerMessage.Presence.
+func Presence(nick, status string) string {
+	switch status {
+	case protocol.Online:
+		return fmt.Sprintf("%s is here.", nick)
+	case protocol.Away:
+		return fmt.Sprintf("%s is away.", nick)
+	case protocol.Substituted:
+		return fmt.Sprintf("%s lost connection, the bot is playing for them until they're back.", nick)
+	}
+	return fmt.Sprintf("%s lost connection.", nick)
+}
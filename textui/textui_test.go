@@ -0,0 +1,101 @@
+package textui
+
+import (
+	"bytes"
+	"github.com/google/go-cmp/cmp"
+	"github.com/sbadame/scopa/protocol"
+	"github.com/sbadame/scopa/scopa"
+	"strings"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	settebello := scopa.Card{Suit: scopa.Denari, Value: 7}
+	re := scopa.Card{Suit: scopa.Bastoni, Value: 10}
+	tests := map[string]protocol.Command{
+		"drop RB":          {Type: protocol.DropCommand, Card: &re},
+		"d rb":             {Type: protocol.DropCommand, Card: &re},
+		"take 7D 3C+4S":    {Type: protocol.TakeCommand, Card: &settebello, Table: []scopa.Card{{Suit: scopa.Coppe, Value: 3}, {Suit: scopa.Spade, Value: 4}}},
+		"take 7D 3C 4S":    {Type: protocol.TakeCommand, Card: &settebello, Table: []scopa.Card{{Suit: scopa.Coppe, Value: 3}, {Suit: scopa.Spade, Value: 4}}},
+		"say  Bella scopa": {Type: protocol.ChatCommand, Text: "Bella scopa"},
+		"resign":           {Type: protocol.ResignCommand},
+		"rematch":          {Type: protocol.OfferRematchCommand, Rematch: &protocol.RematchOptions{}},
+		"rematch swap asso-piglia-tutto to 21": {Type: protocol.OfferRematchCommand, Rematch: &protocol.RematchOptions{
+			SwapSeats: true, Variant: scopa.AssoPigliaTutto, Partita: 21}},
+		"draw":    {Type: protocol.DrawCommand},
+		"abort":   {Type: protocol.AbortCommand},
+		"accept":  {Type: protocol.AcceptRematchCommand},
+		"decline": {Type: protocol.DeclineRematchCommand},
+	}
+	for line, want := range tests {
+		got, err := ParseCommand(line)
+		if err != nil {
+			t.Errorf("ParseCommand(%q) failed: %v", line, err)
+			continue
+		}
+		if d := cmp.Diff(want, got); d != "" {
+			t.Errorf("ParseCommand(%q) mismatch (-want +got):\n%s", line, d)
+		}
+	}
+
+	for _, line := range []string{"", "drop", "drop 7X", "take 7D", "take 7D 3C+11S", "dance", "rematch to", "rematch to -1", "rematch dance"} {
+		if c, err := ParseCommand(line); err == nil {
+			t.Errorf("ParseCommand(%q) = %#v, expected an error", line, c)
+		}
+	}
+	if _, err := ParseCommand("quit"); err != ErrQuit {
+		t.Errorf("Expected quit to return ErrQuit, got %v", err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	s := &protocol.State{
+		NextPlayer: "a",
+		Table:      []scopa.Card{{Suit: scopa.Coppe, Value: 3}},
+		Players:    []scopa.Player{{Name: "a", Scopas: 1}, {Name: "b"}},
+		Player:     scopa.Player{Name: "a", Hand: []scopa.Card{{Suit: scopa.Denari, Value: 7}}},
+		LastMove: protocol.Move{Drop: &protocol.Drop{
+			Player: "b",
+			Card:   scopa.Card{Suit: scopa.Coppe, Value: 3},
+		}},
+		RemainingCardsInDeck: 20,
+	}
+	var b bytes.Buffer
+	Render(&b, "a", s, &protocol.Clock{Mode: protocol.MoveClock, Remaining: map[string]int64{"a": 25000, "b": 30000}})
+
+	for _, want := range []string{
+		"Last move: b dropped 3C (3 di Coppe)",
+		"Table:     3C (3 di Coppe)",
+		"20 cards left",
+		"a (you):",
+		"Clock:     a 0:25, b 0:30",
+		"Your hand: 7D (Settebello)",
+		"Your turn!",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("Expected %q in:\n%s", want, b.String())
+		}
+	}
+}
+
+func TestRematch(t *testing.T) {
+	r := &protocol.Rematch{Status: protocol.RematchOffered, From: "a", Options: protocol.RematchOptions{SwapSeats: true, Partita: 21}}
+	if got, want := Rematch("b", r), "a offers a rematch, swapping who plays first, to 21 points. Type accept or decline."; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if got, want := Rematch("a", &protocol.Rematch{Status: protocol.RematchOffered, From: "a"}), "You offered a rematch."; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestRenderEnding(t *testing.T) {
+	s := &protocol.State{Ended: true, Forfeited: "b", Ending: protocol.Abandoned}
+	var b bytes.Buffer
+	Render(&b, "a", s, nil)
+	if want := "b left, and didn't come back in time."; !strings.Contains(b.String(), want) {
+		t.Errorf("Expected %q in:\n%s", want, b.String())
+	}
+	if got, want := Proposal("a", &protocol.Proposal{Type: protocol.DrawCommand, From: "b"}), "b proposes a draw, type draw to agree."; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}